@@ -0,0 +1,191 @@
+package bolt
+
+import (
+	"bufio"
+	"context"
+	sdfs "crane/simpledfs/client"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Transactional file sink into SDFS, the reference Sink implementation.
+// Every snapshot version is one transaction. It is staged in SDFS as
+// <Filename>_<task>_<version>.pending when pre-committed, and published as
+// <Filename>_<task>_<version> when committed, each line is a JSON tuple
+type FileSink struct {
+	Client   *sdfs.Client
+	Filename string
+	Dir      string
+	task     string
+	buffer   *os.File
+	writer   *bufio.Writer
+	written  int
+	staged   map[int]string
+	mutex    sync.Mutex
+}
+
+// Factory mode to return the FileSink instance, the transactions
+// are buffered in the local directory before uploading
+func NewFileSink(masterAddr, filename, dir string) *FileSink {
+	sink := &FileSink{}
+	sink.Client = sdfs.NewClient(masterAddr)
+	sink.Filename = filename
+	sink.Dir = dir
+	sink.staged = make(map[int]string)
+	return sink
+}
+
+func (fs *FileSink) Open(task string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.task = task
+	if err := os.MkdirAll(fs.Dir, 0755); err != nil {
+		return err
+	}
+	return fs.openBuffer()
+}
+
+func (fs *FileSink) Write(tuple []interface{}) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	b, err := json.Marshal(tuple)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.writer.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	fs.written++
+	return nil
+}
+
+func (fs *FileSink) PreCommit(version int) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	// The buffer is already staged if the previous upload failed
+	if fs.buffer != nil {
+		if err := fs.closeBuffer(); err != nil {
+			return err
+		}
+		// Nothing to publish for an empty transaction
+		if fs.written == 0 {
+			return fs.openBuffer()
+		}
+		staged := filepath.Join(fs.Dir, fs.name(version)+".pending")
+		if err := os.Rename(fs.bufferPath(), staged); err != nil {
+			return err
+		}
+		fs.staged[version] = staged
+	}
+
+	if staged, ok := fs.staged[version]; ok {
+		if err := fs.Client.Put(context.Background(), staged, fs.name(version)+".pending"); err != nil {
+			return err
+		}
+	}
+	return fs.openBuffer()
+}
+
+func (fs *FileSink) Commit(version int) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	ctx := context.Background()
+	pending := fs.name(version) + ".pending"
+
+	// Pre-committed by a previous task of the same name, fetch it from SDFS
+	staged, ok := fs.staged[version]
+	if !ok {
+		staged = filepath.Join(fs.Dir, pending)
+		err := fs.Client.Get(ctx, pending, staged)
+		if errors.Is(err, sdfs.ErrNotFound) {
+			// Empty or already committed
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := fs.Client.Put(ctx, staged, fs.name(version)); err != nil {
+		return err
+	}
+	if err := fs.Client.Delete(ctx, pending); err != nil && !errors.Is(err, sdfs.ErrNotFound) {
+		log.Println(err)
+	}
+	os.Remove(staged)
+	delete(fs.staged, version)
+	log.Printf("Sink %s Committed Version %d\n", fs.task, version)
+	return nil
+}
+
+func (fs *FileSink) Abort(version int) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	// Only one snapshot is in flight, so the next version is the only one
+	// possibly pre-committed by a previous task of the same name
+	err := fs.Client.Delete(context.Background(), fs.name(version+1)+".pending")
+	if err != nil && !errors.Is(err, sdfs.ErrNotFound) {
+		return err
+	}
+	for v, staged := range fs.staged {
+		if v <= version {
+			continue
+		}
+		err := fs.Client.Delete(context.Background(), fs.name(v)+".pending")
+		if err != nil && !errors.Is(err, sdfs.ErrNotFound) {
+			return err
+		}
+		os.Remove(staged)
+		delete(fs.staged, v)
+	}
+	// The writes since the last barrier are replayed later
+	if err := fs.closeBuffer(); err != nil {
+		return err
+	}
+	return fs.openBuffer()
+}
+
+func (fs *FileSink) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.closeBuffer()
+}
+
+// SDFS file name of the transaction
+func (fs *FileSink) name(version int) string {
+	return fmt.Sprintf("%s_%s_%d", fs.Filename, fs.task, version)
+}
+
+func (fs *FileSink) bufferPath() string {
+	return filepath.Join(fs.Dir, fmt.Sprintf("%s_%s.buffer", fs.Filename, fs.task))
+}
+
+// Must be called with the mutex held
+func (fs *FileSink) openBuffer() error {
+	buffer, err := os.Create(fs.bufferPath())
+	if err != nil {
+		return err
+	}
+	fs.buffer = buffer
+	fs.writer = bufio.NewWriter(buffer)
+	fs.written = 0
+	return nil
+}
+
+// Must be called with the mutex held
+func (fs *FileSink) closeBuffer() error {
+	if fs.buffer == nil {
+		return nil
+	}
+	err := fs.writer.Flush()
+	if cerr := fs.buffer.Close(); err == nil {
+		err = cerr
+	}
+	fs.buffer = nil
+	return err
+}
@@ -1,16 +1,27 @@
 package bolt
 
-import ()
+import (
+	"crane/core/utils"
+)
 
 type BoltInst struct {
-	Name          string
-	PrevTaskNames []string
-	TaskAddrs     []string
-	PluginFile    string
-	PluginSymbol  string
-	GroupingHint  string
-	FieldIndex    int
-	InstNum       int
+	Name           string
+	PrevTaskNames  []string
+	PrevStreams    []string
+	Streams        []string
+	OutputFields   map[string][]string
+	GroupingFields map[string][]string
+	TaskAddrs      []string
+	PluginFile     string
+	PluginSymbol   string
+	GroupingHint   string
+	FieldIndex     int
+	InstNum        int
+	CPU            float64
+	Memory         int
+	// Codec of the tuples the tasks receive, JSON if empty
+	Codec string
+	Batch utils.BatchConfig
 }
 
 func NewBoltInst(name, pluginFile, pluginSymbol, grouping string, mainField int) *BoltInst {
@@ -22,6 +33,10 @@ func NewBoltInst(name, pluginFile, pluginSymbol, grouping string, mainField int)
 	boltInst.FieldIndex = mainField
 	boltInst.InstNum = 1
 	boltInst.PrevTaskNames = make([]string, 0)
+	boltInst.PrevStreams = make([]string, 0)
+	boltInst.Streams = []string{utils.DEFAULT_STREAM}
+	boltInst.OutputFields = make(map[string][]string)
+	boltInst.GroupingFields = make(map[string][]string)
 	return boltInst
 }
 
@@ -29,13 +44,108 @@ func (bi *BoltInst) SetInstanceNum(n int) {
 	bi.InstNum = n
 }
 
+// Subscribe the default stream of the previous task
 func (bi *BoltInst) AddPrevTaskName(task string) {
+	bi.AddPrevTaskStream(task, utils.DEFAULT_STREAM)
+}
+
+// Subscribe a named output stream of the previous task
+func (bi *BoltInst) AddPrevTaskStream(task string, stream string) {
 	bi.PrevTaskNames = append(bi.PrevTaskNames, task)
+	bi.PrevStreams = append(bi.PrevStreams, stream)
+}
+
+// The stream subscribed from the i-th previous task
+func (bi *BoltInst) PrevStream(i int) string {
+	if i >= len(bi.PrevStreams) || bi.PrevStreams[i] == "" {
+		return utils.DEFAULT_STREAM
+	}
+	return bi.PrevStreams[i]
+}
+
+// Declare the resources every task requires for the resource aware
+// scheduler, CPU in percent of a core and memory in MB
+func (bi *BoltInst) SetResources(cpu float64, memory int) {
+	bi.CPU = cpu
+	bi.Memory = memory
+}
+
+// Select the codec of the tuples the tasks receive, one of
+// utils.CODEC_JSON (default) or CODEC_BINARY. The previous tasks fall
+// back to JSON if they do not know the codec
+func (bi *BoltInst) SetCodec(codec string) {
+	bi.Codec = codec
+}
+
+// Batch the tuples sent over every outgoing edge, up to maxTuples tuples
+// or maxBytes bytes (0 for no limit) waiting at most linger milliseconds
+func (bi *BoltInst) SetBatching(maxTuples, maxBytes, linger int) {
+	bi.Batch = utils.BatchConfig{MaxTuples: maxTuples, MaxBytes: maxBytes, Linger: linger}
+}
+
+// Declare a named output stream besides the default one, with the
+// names of its tuples' fields
+func (bi *BoltInst) DeclareStream(stream string, fields ...string) {
+	bi.Streams = append(bi.Streams, stream)
+	if len(fields) > 0 {
+		bi.declareFields(stream, fields)
+	}
 }
 
+// Declare the names of the fields of the default stream's tuples
+func (bi *BoltInst) DeclareOutputFields(fields ...string) {
+	bi.declareFields(utils.DEFAULT_STREAM, fields)
+}
+
+func (bi *BoltInst) declareFields(stream string, fields []string) {
+	if bi.OutputFields == nil {
+		bi.OutputFields = make(map[string][]string)
+	}
+	bi.OutputFields[stream] = fields
+}
+
+// Group the tuples from the previous task by the named fields, the tuples
+// with the same values of the fields go to the same task of this bolt.
+// It overrides the grouping hint of the previous task for this bolt
+func (bi *BoltInst) GroupByFields(task string, fields ...string) {
+	if bi.GroupingFields == nil {
+		bi.GroupingFields = make(map[string][]string)
+	}
+	bi.GroupingFields[task] = fields
+}
+
+// Collector of the tuples a bolt emits while executing an input tuple,
+// the emitted tuples are anchored to the input tuple
 type BoltOutputCollector struct {
+	emit func(string, []interface{})
+}
+
+// Factory mode to return the BoltOutputCollector instance,
+// used by the bolt workers
+func NewBoltOutputCollector(emit func(string, []interface{})) *BoltOutputCollector {
+	return &BoltOutputCollector{emit: emit}
+}
+
+// Emit a tuple to the default stream, a bolt can emit any number
+// of tuples per input tuple
+func (c *BoltOutputCollector) Emit(values ...interface{}) {
+	c.emit(utils.DEFAULT_STREAM, values)
+}
+
+// Emit a tuple to the named output stream
+func (c *BoltOutputCollector) EmitTo(stream string, values ...interface{}) {
+	c.emit(stream, values)
 }
 
+// Bolt, the typed interface of the bolts. The plugin exports a factory
+// of type func() Bolt as the bolt's plugin symbol, every task gets its own
+// instance. A bolt keeping state implements state.Stateful as well
 type Bolt interface {
-	Execute()
+	// Prepare the bolt of the task before it executes any tuple
+	Prepare(task string, collector *BoltOutputCollector) error
+	// Execute the input tuple and emit the results with the collector,
+	// an error fails the input tuple
+	Execute(tuple []interface{}) error
+	// Clean up the bolt when the task terminates
+	Cleanup()
 }
@@ -0,0 +1,23 @@
+package bolt
+
+// Sink, the bolt at the end of the pipeline whose writes are made visible
+// by two-phase commit with the snapshot versions. The plugin exports a
+// factory of type func() Sink as the bolt's plugin symbol
+type Sink interface {
+	// Open the sink for the task before any tuple is written
+	Open(task string) error
+	// Write the tuple into the transaction of the coming snapshot version
+	Write(tuple []interface{}) error
+	// Phase one, called when the barrier of the version arrives. The writes
+	// since the previous barrier must be durable but still invisible.
+	// It is retried until it succeeds, the barrier waits meanwhile
+	PreCommit(version int) error
+	// Phase two, called when the driver confirms the snapshot version is
+	// completed. It must be idempotent since it is retried after restoring
+	Commit(version int) error
+	// Discard the writes not pre-committed and the transactions
+	// pre-committed after the version, called after restoring from it
+	Abort(version int) error
+	// Close the sink when the task terminates
+	Close() error
+}
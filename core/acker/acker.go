@@ -0,0 +1,198 @@
+package acker
+
+import (
+	"crane/core/messages"
+	"crane/core/utils"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Entries not completed in this period are dropped, the spouts
+	// replay their tuples on their own timeout
+	ENTRY_TIMEOUT = 5 * time.Minute
+)
+
+// Tracking entry of a spout tuple tree
+type entry struct {
+	xor       uint64
+	spoutConn string
+	inited    bool
+	// A bolt may fail the tuple before the spout's init arrives
+	failed  bool
+	created time.Time
+}
+
+// Acker, the system task tracking the tuple trees emitted by spouts.
+// Every edge id of a tree is XORed into its entry once when emitted and once
+// when acked, so the tree is completed when the value gets back to zero
+type Acker struct {
+	Name        string
+	port        string
+	publisher   *messages.Publisher
+	pending     map[uint64]*entry
+	mutex       sync.Mutex
+	wg          sync.WaitGroup
+	SupervisorC chan string
+	WorkerC     chan string
+	terminated  int32
+}
+
+// Factory mode to return the Acker instance
+func NewAcker(name string, port string, supervisorC chan string, workerC chan string) *Acker {
+	acker := &Acker{}
+	acker.Name = name
+	acker.port = port
+	acker.pending = make(map[uint64]*entry)
+	acker.SupervisorC = supervisorC
+	acker.WorkerC = workerC
+	return acker
+}
+
+func (a *Acker) Start() {
+	defer close(a.SupervisorC)
+	defer close(a.WorkerC)
+	defer atomic.StoreInt32(&a.terminated, 1)
+
+	log.Printf("Acker %s Start\n", a.Name)
+	a.publisher = messages.NewPublisher(":" + a.port)
+	if a.publisher == nil {
+		log.Printf("Acker %s fails to listen on port %s\n", a.Name, a.port)
+		return
+	}
+	go a.publisher.AcceptConns()
+	go a.publisher.PublishMessage(a.publisher.PublishBoard)
+	go a.TalkWithSupervisor()
+	go a.receiveAcks()
+	go a.expireEntries()
+
+	a.wg.Add(1)
+	a.wg.Wait()
+	a.publisher.Close()
+	log.Printf("Acker %s Terminates\n", a.Name)
+}
+
+// Receive the ack messages from spout and bolt workers
+func (a *Acker) receiveAcks() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("receiveAcks panic and recovered", r)
+		}
+	}()
+	for {
+		received := false
+		a.publisher.RWLock.RLock()
+		for connId, channel := range a.publisher.Channels {
+			select {
+			case message := <-channel:
+				received = true
+				payload := utils.CheckType(message.Payload)
+				if payload.Header.Type == utils.CONN_NOTIFY {
+					continue
+				}
+				ack := utils.AckMessage{}
+				if err := json.Unmarshal(message.Payload, &ack); err != nil {
+					continue
+				}
+				a.handleAck(ack, connId)
+			default:
+			}
+		}
+		a.publisher.RWLock.RUnlock()
+		if !received {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func (a *Acker) handleAck(ack utils.AckMessage, connId string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	e, ok := a.pending[ack.Root]
+	if !ok {
+		e = &entry{created: time.Now()}
+		a.pending[ack.Root] = e
+	}
+
+	switch ack.Type {
+	case utils.TUPLE_ACK_INIT:
+		e.inited = true
+		e.spoutConn = connId
+		e.xor ^= ack.Xor
+		if e.failed {
+			a.notifySpout(utils.TUPLE_FAIL, ack.Root, e.spoutConn)
+			delete(a.pending, ack.Root)
+			return
+		}
+	case utils.TUPLE_ACK:
+		e.xor ^= ack.Xor
+	case utils.TUPLE_FAIL:
+		if !e.inited {
+			e.failed = true
+			return
+		}
+		a.notifySpout(utils.TUPLE_FAIL, ack.Root, e.spoutConn)
+		delete(a.pending, ack.Root)
+		return
+	}
+
+	if e.inited && e.xor == 0 {
+		a.notifySpout(utils.TUPLE_ACK, ack.Root, e.spoutConn)
+		delete(a.pending, ack.Root)
+	}
+}
+
+// Must be called with the mutex held
+func (a *Acker) notifySpout(ackType string, root uint64, spoutConn string) {
+	b, _ := json.Marshal(utils.AckMessage{Type: ackType, Root: root})
+	a.publisher.PublishBoard <- messages.Message{
+		Payload:      b,
+		TargetConnId: spoutConn,
+	}
+}
+
+// Drop the entries whose tuples never complete
+func (a *Acker) expireEntries() {
+	for {
+		time.Sleep(time.Minute)
+		a.mutex.Lock()
+		for root, e := range a.pending {
+			if time.Since(e.created) > ENTRY_TIMEOUT {
+				delete(a.pending, root)
+			}
+		}
+		a.mutex.Unlock()
+	}
+}
+
+// The channel to communicate with the supervisor
+func (a *Acker) TalkWithSupervisor() {
+	// Message Type:
+	// Superviosr -> Worker
+	// 2. Please Kill Yourself                         Superviosr -> Worker
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("TalkWithSupervisor panic and recovered", r)
+		}
+	}()
+
+	for message := range a.SupervisorC {
+		if strings.HasPrefix(message, "2") {
+			a.wg.Done()
+			return
+		}
+	}
+}
+
+// Status of the acker for the supervisor heartbeats
+func (a *Acker) Status() utils.WorkerStatus {
+	status := utils.WorkerStatus{Task: a.Name, Kind: "acker", State: utils.WORKER_RUNNING}
+	if atomic.LoadInt32(&a.terminated) == 1 {
+		status.State = utils.WORKER_DEAD
+	}
+	return status
+}
@@ -1,78 +1,166 @@
 package boltworker
 
 import (
+	"crane/bolt"
+	"crane/core/codec"
 	"crane/core/messages"
+	"crane/core/state"
 	"crane/core/utils"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	BUFLEN   = 1024
-	BUFFSIZE = 1024
+	BUFLEN          = 1024
+	BUFFSIZE        = 1024
+	STATE_RETENTION = 3
+	// Suffix of the state name saving the sink's pre-committed versions
+	SINK_STATE_SUFFIX = "_sink"
 )
 
 type BoltWorker struct {
 	Name        string
 	numWorkers  int
 	executors   []*Executor
-	tuples      chan []interface{}
-	results     chan []interface{}
+	tuples      chan utils.TupleMessage
+	results     chan result
 	port        string
 	subAddrs    []string
 	publisher   *messages.Publisher
 	subscribers []*messages.Subscriber
+	rewires     chan rewire
 	preGrouping string
 	preField    int
 	sucGrouping string
 	sucField    int
-	sucIndexMap map[string]map[int]string
+	sucStreams  map[string][]string
+	sucIndexes  map[string]map[string][]int
+	// Codecs the worker offers to the previous tasks
+	codecs      []string
+	// Connections of the successor tasks and the codecs of their edges,
+	// a *successors replaced whenever a successor subscribes
+	sucRoutes   atomic.Value
+	// Batches of the tuples to the successors
+	batch       utils.BatchConfig
+	batcher     *messages.Batcher
+	state       state.StateBackend
+	ackerAddr   string
+	ackerSub    *messages.Subscriber
+	barrier     int
+	sink        bolt.Sink
+	newSink     func() bolt.Sink
+	committing  []int
+	instNum     int
+	stateInst   int
 	rwmutex     sync.RWMutex
 	wg          sync.WaitGroup
 	SupervisorC chan string
 	WorkerC     chan string
 	Version     string
+	// Counters and state reported by the supervisor heartbeats
+	emitted    uint64
+	executed   uint64
+	failed     uint64
+	terminated int32
+	// Closed when the worker is killed or crashes, with the reason
+	// of the crash for the supervisor to restart it
+	done     chan struct{}
+	stopOnce sync.Once
+	failure  atomic.Value
+}
+
+// Subscribers replacing the ones of the moved previous tasks by their
+// indexes, and the snapshot version abandoned, applied by receiveTuple
+type rewire struct {
+	subscribers map[int]*messages.Subscriber
+	abandoned   int
+}
+
+// Outputs of an executor, anchored to the input tuple
+type result struct {
+	anchor utils.TupleMessage
+	tuples []utils.TupleMessage
+	err    error
 }
 
 type Executor struct {
 	id        int
 	available bool
-	results   chan []interface{}
-	procFunc  func([]interface{}, *[]interface{}, *[]interface{}) error
-	variables []interface{}
+	results   chan result
+	bolt      bolt.Bolt
+	collector *bolt.BoltOutputCollector
+	emitted   []utils.TupleMessage
+	crash     func(reason string)
 }
 
 func NewBoltWorker(numWorkers int, name string,
 	pluginFilename string, pluginSymbol string,
 	port string, subAddrs []string,
 	preGrouping string, preField int,
-	sucGrouping string, sucField int,
-	supervisorC chan string, workerC chan string, version int) *BoltWorker {
-
-	tuples := make(chan []interface{}, BUFLEN)
-	results := make(chan []interface{}, BUFLEN)
+	sucGrouping string, sucField int, sucStreams map[string][]string,
+	sucIndexes map[string]map[string][]int,
+	supervisorC chan string, workerC chan string, version int,
+	stateBackend state.StateBackend, ackerAddr string,
+	instNum int, stateInstNum int, codecName string, batch utils.BatchConfig) (bw *BoltWorker, err error) {
+	// A panic of the plugin fails the task, not the supervisor
+	defer func() {
+		if r := recover(); r != nil {
+			bw, err = nil, fmt.Errorf("bolt %s panics starting: %v", name, r)
+		}
+	}()
 
-	// Lookup ProcFunc
-	procFunc := utils.LookupProcFunc(pluginFilename, pluginSymbol)
+	if _, err := codec.NewCodec(codecName); err != nil {
+		return nil, err
+	}
+	tuples := make(chan utils.TupleMessage, BUFLEN)
+	results := make(chan result, BUFLEN)
+
+	// Lookup the factory of the bolt, the ProcFunc, or the factory of the sink
+	var newBolt func() bolt.Bolt
+	var newSink func() bolt.Sink
+	var sink bolt.Sink
+	symbol, err := utils.LookupSymbol(pluginFilename, pluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	switch symbol := symbol.(type) {
+	case func() bolt.Bolt:
+		newBolt = symbol
+	case func([]interface{}, *[]interface{}, *[]interface{}) error:
+		newBolt = func() bolt.Bolt {
+			return newProcFuncBolt(symbol)
+		}
+	case func() bolt.Sink:
+		newSink = symbol
+		sink = symbol()
+	default:
+		return nil, fmt.Errorf("unexpected type %T of symbol %s", symbol, pluginSymbol)
+	}
 
 	// Create executors
 	executors := make([]*Executor, 0)
 	for i := 0; i < numWorkers; i++ {
-		variables := make([]interface{}, 0) // Store bolt's global variables
 		executor := &Executor{
 			id:        i,
 			available: true,
 			results:   results,
-			procFunc:  procFunc,
-			variables: variables,
+		}
+		// Every executor prepares its own bolt instance
+		if newBolt != nil {
+			executor.bolt = newBolt()
+			executor.collector = bolt.NewBoltOutputCollector(func(stream string, values []interface{}) {
+				executor.emitted = append(executor.emitted, utils.TupleMessage{Stream: stream, Values: values})
+			})
+			if err := executor.bolt.Prepare(name, executor.collector); err != nil {
+				log.Println(err)
+			}
 		}
 		executors = append(executors, executor)
 	}
@@ -81,10 +169,7 @@ func NewBoltWorker(numWorkers int, name string,
 	var publisher *messages.Publisher
 	subscribers := make([]*messages.Subscriber, 0)
 
-	// A map to record the index of successor
-	sucIndexMap := make(map[string]map[int]string)
-
-	bw := &BoltWorker{
+	bw = &BoltWorker{
 		Name:        name,
 		numWorkers:  numWorkers,
 		executors:   executors,
@@ -94,27 +179,43 @@ func NewBoltWorker(numWorkers int, name string,
 		subAddrs:    subAddrs,
 		publisher:   publisher,
 		subscribers: subscribers,
+		rewires:     make(chan rewire, 1),
 		preGrouping: preGrouping,
 		preField:    preField,
 		sucGrouping: sucGrouping,
 		sucField:    sucField,
-		sucIndexMap: sucIndexMap,
+		sucStreams:  sucStreams,
+		sucIndexes:  sucIndexes,
+		codecs:      codec.Offer(codecName),
+		batch:       batch,
+		state:       stateBackend,
+		ackerAddr:   ackerAddr,
+		sink:        sink,
+		newSink:     newSink,
+		committing:  make([]int, 0),
+		instNum:     instNum,
+		stateInst:   stateInstNum,
 		SupervisorC: supervisorC,
 		WorkerC:     workerC,
+		done:        make(chan struct{}),
+	}
+	for _, executor := range executors {
+		executor.crash = bw.crash
 	}
 
-	// Start from restore, read state file to get variables
+	// Start from restore, load state to get variables
 	if version > 0 {
 		bw.DeserializeVariables(strconv.Itoa(version))
 	}
 	bw.Version = strconv.Itoa(version)
+	bw.barrier = version
 
-	return bw
+	bw.sucRoutes.Store(&successors{indexes: make(map[string]map[int]string), codecs: make(map[string]codec.Codec)})
+	return bw, nil
 }
 
 func (bw *BoltWorker) Start() {
-	defer close(bw.SupervisorC)
-	defer close(bw.WorkerC)
+	bw.wg.Add(1)
 	defer close(bw.tuples)
 	defer close(bw.results)
 
@@ -124,23 +225,49 @@ func (bw *BoltWorker) Start() {
 	bw.publisher = messages.NewPublisher(":" + bw.port)
 	go bw.publisher.AcceptConns()
 	go bw.publisher.PublishMessage(bw.publisher.PublishBoard)
+	bw.batcher = messages.NewBatcher(bw.publisher.PublishBoard, bw.batch)
 	time.Sleep(1 * time.Second) // Wait for all boltWorkers' publisher established
 
+	// Open the sink and recover its transactions
+	if bw.sink != nil {
+		bw.openSink()
+		defer bw.sink.Close()
+	}
+	for _, executor := range bw.executors {
+		if executor.bolt != nil {
+			defer executor.bolt.Cleanup()
+		}
+	}
+
 	// Start subscribers
 	for _, subAddr := range bw.subAddrs {
 		subscriber := messages.NewSubscriber(subAddr)
+		if subscriber == nil {
+			bw.crash(fmt.Sprintf("fails to connect previous task %s", subAddr))
+			break
+		}
 		bw.subscribers = append(bw.subscribers, subscriber)
 		go subscriber.ReadMessage()
 		go subscriber.RequestMessage()
 	}
 	time.Sleep(1 * time.Second) // Wait for all subscriber established
 
+	// Subscribe the acker to ack the processed tuples
+	if bw.ackerAddr != "" {
+		bw.ackerSub = messages.NewSubscriber(bw.ackerAddr)
+		if bw.ackerSub == nil {
+			log.Printf("%s fails to connect acker %s, tuples are not acked\n", bw.Name, bw.ackerAddr)
+		} else {
+			go bw.ackerSub.RequestMessage()
+		}
+	}
+
 	// Listen to subscriber, they will tell who they are
 	go bw.listenToSubscribers()
 
-	// Tell the previous hop who am I
+	// Tell the previous hop who am I and the codecs I decode
 	for _, subscriber := range bw.subscribers {
-		bin, _ := json.Marshal(bw.Name)
+		bin, _ := json.Marshal(utils.SubscribeHello{Name: bw.Name, Codecs: bw.codecs})
 		subscriber.Request <- messages.Message{
 			Payload: bin,
 		}
@@ -148,7 +275,7 @@ func (bw *BoltWorker) Start() {
 	// End tell
 
 	time.Sleep(2 * time.Second) // Wait for spout to establish suc index map
-	fmt.Printf("Map: %v\n", bw.sucIndexMap)
+	fmt.Printf("Map: %v\n", bw.successors().indexes)
 
 	// bw.buildSucIndexMap()
 
@@ -159,34 +286,50 @@ func (bw *BoltWorker) Start() {
 	go bw.distributeTuple()
 	go bw.outputTuple()
 
-	bw.wg.Add(1)
 	bw.wg.Wait()
 	bw.publisher.Close()
+	for _, subscriber := range bw.subscribers {
+		subscriber.Conn.Close()
+	}
+	if bw.ackerSub != nil {
+		bw.ackerSub.Conn.Close()
+	}
+	atomic.StoreInt32(&bw.terminated, 1)
 	log.Printf("Bolt Worker %s Terminates\n", bw.Name)
 }
 
 func (bw *BoltWorker) listenToSubscribers() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("listenToSubscribers panic and recovered", r)
+			bw.crash(fmt.Sprintf("listenToSubscribers panics: %v", r))
 		}
 	}()
 	for {
+		select {
+		case <-bw.done:
+			return
+		default:
+		}
 		for connId, channel := range bw.publisher.Channels {
 			bw.publisher.RWLock.RLock()
 			select {
 			case message := <- channel:
 				log.Println(message)
-				var workerName string
-				json.Unmarshal(message.Payload, &workerName)
+				// The successor is gone, its index is taken over by the
+				// task replacing it when it subscribes
+				if utils.CheckType(message.Payload).Header.Type == utils.CONN_NOTIFY {
+					break
+				}
+				workerName, c, err := codec.ParseHello(message.Payload)
+				if err != nil {
+					log.Printf("%s Receives Invalid Hello From %s: %v\n", bw.Name, connId, err)
+					break
+				}
 				words := strings.Split(workerName, "_")
 				boltType := words[0]
 				boltIndex := words[1]
-				if (bw.sucIndexMap[boltType] == nil) {
-					bw.sucIndexMap[boltType] = make(map[int]string)
-				}
 				index, _ := strconv.Atoi(boltIndex)
-				bw.sucIndexMap[boltType][index] = connId
+				bw.addSuccessor(boltType, index, connId, c)
 			default:
 			}
 			bw.publisher.RWLock.RUnlock()
@@ -197,31 +340,93 @@ func (bw *BoltWorker) listenToSubscribers() {
 func (bw *BoltWorker) receiveTuple() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("receiveTupel recovered", r)
+			bw.crash(fmt.Sprintf("receiveTuple panics: %v", r))
 		}
 	}()
+	// Subscribers whose barrier has arrived are blocked until the barriers
+	// from all the subscribers are aligned, their tuples after the barrier
+	// wait in the channel
+	aligned := make(map[int]bool)
+	// Barriers of the snapshots abandoned on failures never align
+	abandoned, aligning := 0, 0
 	for {
-		for _, subscriber := range bw.subscribers {
+		select {
+		case <-bw.done:
+			return
+		case r := <-bw.rewires:
+			for index, subscriber := range r.subscribers {
+				bw.subscribers[index].Conn.Close()
+				bw.subscribers[index] = subscriber
+			}
+			if r.abandoned > abandoned {
+				abandoned = r.abandoned
+				if aligning <= abandoned {
+					aligned = make(map[int]bool)
+				}
+			}
+		default:
+		}
+		received := false
+		for index, subscriber := range bw.subscribers {
+			if aligned[index] {
+				continue
+			}
 			select {
 			case msg, ok := <-subscriber.PublishBoard:
 				if !ok {
 					return
 				}
-				var tuple []interface{}
-				json.Unmarshal(msg.Payload, &tuple)
-				if len(tuple) > 0 {
+				received = true
+				var tuple utils.TupleMessage
+				if err := codec.Decode(msg.Payload, &tuple); err != nil {
+					log.Printf("%s Fails to Decode Tuple From %s: %v\n", bw.Name, msg.SourceConnId, err)
+					continue
+				}
+				if tuple.Barrier > 0 {
+					// Barrier of a snapshot taken before restoring
+					if tuple.Barrier <= bw.barrier || tuple.Barrier <= abandoned {
+						continue
+					}
+					aligned[index] = true
+					aligning = tuple.Barrier
+					if len(aligned) == len(bw.subscribers) {
+						bw.barrier = tuple.Barrier
+						aligned = make(map[int]bool)
+						bw.tuples <- tuple
+					}
+					continue
+				}
+				if len(tuple.Values) > 0 {
 					bw.tuples <- tuple
 				}
+			default:
 			}
 		}
+		if !received {
+			time.Sleep(time.Millisecond)
+		}
 	}
 }
 
 func (bw *BoltWorker) distributeTuple() {
+	// The user's Snapshot and Write may panic
+	defer func() {
+		if r := recover(); r != nil {
+			bw.crash(fmt.Sprintf("distributeTuple panics: %v", r))
+		}
+	}()
 	// TODO: only need group by field
 	switch bw.preGrouping = utils.GROUPING_BY_SHUFFLE; bw.preGrouping {
 	case utils.GROUPING_BY_SHUFFLE:
 		for tuple := range bw.tuples {
+			if tuple.Barrier > 0 {
+				bw.checkpoint(tuple)
+				continue
+			}
+			if bw.sink != nil {
+				bw.writeTuple(tuple)
+				continue
+			}
 			bw.executors[0].processTuple(tuple)
 		}
 	// case utils.GROUPING_BY_SHUFFLE:
@@ -240,7 +445,11 @@ func (bw *BoltWorker) distributeTuple() {
 	// 	}
 	case utils.GROUPING_BY_FIELD:
 		for tuple := range bw.tuples {
-			execid := utils.Hash(tuple[bw.preField]) % bw.numWorkers
+			if tuple.Barrier > 0 {
+				bw.checkpoint(tuple)
+				continue
+			}
+			execid := utils.Hash(tuple.Values[bw.preField]) % bw.numWorkers
 			executor := bw.executors[execid]
 			processed := false
 			for !processed {
@@ -255,15 +464,101 @@ func (bw *BoltWorker) distributeTuple() {
 	}
 }
 
-func (e *Executor) processTuple(tuple []interface{}) {
+// Serialize the variables when the barriers are aligned and forward the
+// barrier after the results of the tuples before it
+func (bw *BoltWorker) checkpoint(barrier utils.TupleMessage) {
+	version := strconv.Itoa(barrier.Barrier)
+	if bw.sink != nil {
+		bw.preCommit(barrier.Barrier)
+	}
+	bw.SerializeVariables(version)
+	bw.Version = version
+	bw.results <- result{anchor: barrier}
+	// Notify the supervisor it serialized the variables
+	bw.WorkerC <- fmt.Sprintf("1. %s Serialized Variables With Version %s", bw.Name, version)
+}
+
+// Open the sink, and when restoring, commit the transactions pre-committed
+// at the snapshot and abort the ones after it
+func (bw *BoltWorker) openSink() {
+	if err := bw.sink.Open(bw.Name); err != nil {
+		log.Println(err)
+		return
+	}
+	version, _ := strconv.Atoi(bw.Version)
+	if version > 0 {
+		bw.commit(version)
+	} else {
+		version = 0
+	}
+	if err := bw.sink.Abort(version); err != nil {
+		log.Println(err)
+	}
+	if version > 0 && bw.stateInst > bw.instNum {
+		bw.recoverRemovedSinks(version)
+	}
+}
+
+// Write the tuple into the sink instead of the executors
+func (bw *BoltWorker) writeTuple(tuple utils.TupleMessage) {
+	bw.rwmutex.Lock()
+	err := bw.sink.Write(tuple.Values)
+	bw.rwmutex.Unlock()
+	if err != nil {
+		log.Println(err)
+		atomic.AddUint64(&bw.failed, 1)
+		bw.ack(utils.TUPLE_FAIL, tuple.Root, 0)
+		return
+	}
+	atomic.AddUint64(&bw.executed, 1)
+	bw.ack(utils.TUPLE_ACK, tuple.Root, tuple.Id)
+}
+
+// Phase one of the sink, retried until the writes are durable
+func (bw *BoltWorker) preCommit(version int) {
+	bw.rwmutex.Lock()
+	defer bw.rwmutex.Unlock()
+	for {
+		err := bw.sink.PreCommit(version)
+		if err == nil {
+			break
+		}
+		log.Printf("%s Fails to Pre-Commit Version %d: %v\n", bw.Name, version, err)
+		time.Sleep(time.Second)
+	}
+	bw.committing = append(bw.committing, version)
+}
+
+// Phase two of the sink, commit the transactions up to the completed
+// version in order, the failed ones are retried with the next version
+func (bw *BoltWorker) commit(version int) {
+	bw.rwmutex.Lock()
+	defer bw.rwmutex.Unlock()
+	for len(bw.committing) > 0 && bw.committing[0] <= version {
+		if err := bw.sink.Commit(bw.committing[0]); err != nil {
+			log.Printf("%s Fails to Commit Version %d: %v\n", bw.Name, bw.committing[0], err)
+			return
+		}
+		bw.committing = bw.committing[1:]
+	}
+}
+
+func (e *Executor) processTuple(tuple utils.TupleMessage) {
 	// e.available = false
+	defer func() {
+		if r := recover(); r != nil {
+			e.crash(fmt.Sprintf("Execute panics: %v", r))
+		}
+	}()
 
 	// fmt.Printf("executor (%d) process tuple (%v)\n", e.id, tuple)
-	var result []interface{}
-	e.procFunc(tuple, &result, &e.variables)
-	// fmt.Printf("executor %d output tuple (%v)\n", e.id, result)
-	if len(result) > 0 {
-		e.results <- result
+	e.emitted = make([]utils.TupleMessage, 0)
+	err := e.bolt.Execute(tuple.Values)
+	// fmt.Printf("executor %d output tuples (%v)\n", e.id, e.emitted)
+	// Tracked tuples always pass through to be acked or failed, the
+	// errors of the untracked ones to be counted and logged
+	if len(e.emitted) > 0 || tuple.Root != 0 || err != nil {
+		e.results <- result{anchor: tuple, tuples: e.emitted, err: err}
 	}
 
 	// e.available = true
@@ -272,47 +567,173 @@ func (e *Executor) processTuple(tuple []interface{}) {
 func (bw *BoltWorker) outputTuple() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("Recovered in f", r)
+			bw.crash(fmt.Sprintf("outputTuple panics: %v", r))
 		}
 	}()
-	switch bw.sucGrouping {
-	case utils.GROUPING_BY_SHUFFLE:
-		count := 0
-		for tuple := range bw.tuples {
-			bin, _ := json.Marshal(tuple)
-			for _, v := range bw.sucIndexMap {
-				sucid := count % len(v)
-				sucConnId := v[sucid+1]
-				bw.publisher.PublishBoard <- messages.Message{
-					Payload:      bin,
-					TargetConnId: sucConnId,
+	count := 0
+	for {
+		result, ok := bw.nextResult()
+		if !ok {
+			return
+		}
+		// Barriers go to every successor, after the tuples batched
+		if result.anchor.Barrier > 0 {
+			bw.publisher.Pool.Range(func(id string, conn net.Conn) {
+				bw.batcher.Add(messages.Message{
+					Payload:      bw.encode(id, result.anchor),
+					TargetConnId: id,
+				})
+			})
+			bw.batcher.Flush()
+			continue
+		}
+		if result.err != nil {
+			atomic.AddUint64(&bw.failed, 1)
+			log.Printf("%s Fails to Execute Tuple %v: %v\n", bw.Name, result.anchor.Values, result.err)
+		} else {
+			atomic.AddUint64(&bw.executed, 1)
+		}
+		if result.err != nil && result.anchor.Root != 0 {
+			bw.ack(utils.TUPLE_FAIL, result.anchor.Root, 0)
+			continue
+		}
+
+		// Anchor the outputs to the root of the input tuple, the acker
+		// gets the input edge id and the new edge ids at once
+		xor := result.anchor.Id
+		for _, tuple := range result.tuples {
+			for _, target := range bw.targets(tuple, count) {
+				var id uint64
+				if result.anchor.Root != 0 {
+					id = utils.NewTupleId()
+					xor ^= id
+				}
+				bin := bw.encode(target, utils.TupleMessage{Id: id, Root: result.anchor.Root, Stream: tuple.Stream, Values: tuple.Values})
+				if bin == nil {
+					continue
 				}
+				bw.batcher.Add(messages.Message{
+					Payload:      bin,
+					TargetConnId: target,
+				})
 			}
 			count++
 		}
-	case utils.GROUPING_BY_FIELD:
-		for tuple := range bw.tuples {
-			bin, _ := json.Marshal(tuple)
-			for _, v := range bw.sucIndexMap {
-				sucid := utils.Hash(tuple[bw.sucField]) % len(v)
-				sucConnId := v[sucid+1]
-				bw.publisher.PublishBoard <- messages.Message{
-					Payload:      bin,
-					TargetConnId: sucConnId,
-				}
+		atomic.AddUint64(&bw.emitted, uint64(len(result.tuples)))
+		bw.ack(utils.TUPLE_ACK, result.anchor.Root, xor)
+	}
+}
+
+// Next result to output. The batches are sent meanwhile once no result
+// is queued or their linger passed, false after the last result
+func (bw *BoltWorker) nextResult() (result, bool) {
+	for {
+		if len(bw.results) == 0 {
+			bw.batcher.Idle()
+		}
+		select {
+		case result, ok := <-bw.results:
+			if !ok {
+				bw.batcher.Flush()
 			}
+			return result, ok
+		case <-bw.batcher.Expired():
+			bw.batcher.Expire()
 		}
-	case utils.GROUPING_BY_ALL:
-		for result := range bw.results {
-			bin, _ := json.Marshal(result)
-			bw.publisher.Pool.Range(func(id string, conn net.Conn) {
-				bw.publisher.PublishBoard <- messages.Message{
-					Payload:      bin,
-					TargetConnId: id,
-				}
-			})
+	}
+}
+
+// Routes to the successor tasks, the connection of every task by its
+// component and index, and the codec of the edge by the connection. It is
+// never modified, a subscribing task replaces it with a copy
+type successors struct {
+	indexes map[string]map[int]string
+	codecs  map[string]codec.Codec
+}
+
+func (bw *BoltWorker) successors() *successors {
+	return bw.sucRoutes.Load().(*successors)
+}
+
+// Route the task of the component and index to the connection, the task
+// and its codec replace the previous ones at once for the output path.
+// Called by listenToSubscribers only
+func (bw *BoltWorker) addSuccessor(component string, index int, connId string, c codec.Codec) {
+	old := bw.successors()
+	s := &successors{
+		indexes: make(map[string]map[int]string, len(old.indexes)+1),
+		codecs:  make(map[string]codec.Codec, len(old.codecs)+1),
+	}
+	for name, tasks := range old.indexes {
+		s.indexes[name] = make(map[int]string, len(tasks)+1)
+		for i, id := range tasks {
+			s.indexes[name][i] = id
+		}
+	}
+	for id, oc := range old.codecs {
+		s.codecs[id] = oc
+	}
+	if s.indexes[component] == nil {
+		s.indexes[component] = make(map[int]string)
+	}
+	s.indexes[component][index] = connId
+	s.codecs[connId] = c
+	bw.sucRoutes.Store(s)
+}
+
+// Encode the tuple with the codec of the successor, nil if the codec
+// fails to encode it
+func (bw *BoltWorker) encode(connId string, tuple utils.TupleMessage) []byte {
+	c, ok := bw.successors().codecs[connId]
+	if !ok {
+		c = codec.JsonCodec{}
+	}
+	bin, err := c.Encode(tuple)
+	if err != nil {
+		log.Printf("%s Fails to Encode Tuple With %s Codec: %v\n", bw.Name, c.Name(), err)
+		return nil
+	}
+	return bin
+}
+
+// Successor tasks of the tuple, by the grouping of the components
+// subscribing the stream the tuple is emitted to
+func (bw *BoltWorker) targets(tuple utils.TupleMessage, count int) []string {
+	targets := make([]string, 0)
+	for component, v := range bw.successors().indexes {
+		if !utils.Subscribed(bw.sucStreams, component, tuple.Stream) {
+			continue
+		}
+		// The successor groups by the named fields
+		if indexes, ok := bw.sucIndexes[component][tuple.Stream]; ok {
+			sucid := utils.Hash(utils.SelectFields(tuple.Values, indexes)) % len(v)
+			targets = append(targets, v[sucid+1])
+			continue
+		}
+		switch bw.sucGrouping {
+		case utils.GROUPING_BY_SHUFFLE:
+			sucid := count % len(v)
+			targets = append(targets, v[sucid+1])
+		case utils.GROUPING_BY_FIELD:
+			sucid := utils.Hash(tuple.Values[bw.sucField]) % len(v)
+			targets = append(targets, v[sucid+1])
+		case utils.GROUPING_BY_ALL:
+			for _, connId := range v {
+				targets = append(targets, connId)
+			}
 		}
-	default:
+	}
+	return targets
+}
+
+// Send the ack message of a tracked tuple to the acker
+func (bw *BoltWorker) ack(ackType string, root uint64, xor uint64) {
+	if root == 0 || bw.ackerSub == nil {
+		return
+	}
+	bin, _ := json.Marshal(utils.AckMessage{Type: ackType, Root: root, Xor: xor})
+	bw.ackerSub.Request <- messages.Message{
+		Payload: bin,
 	}
 }
 
@@ -326,85 +747,250 @@ func (bw *BoltWorker) outputTuple() {
 // 	})
 // }
 
-// Serialize and store executors' variables into local file
+// Serialize and save executors' bolt states into the state backend
 func (bw *BoltWorker) SerializeVariables(version string) {
 	log.Printf("%s Start Serializing Variables With Version %s\n", bw.Name, version)
-	// Merge all executors' variables
-	var bins []interface{}
+	// Merge all executors' states, nil for the stateless bolts
+	var bins [][]byte
 	for _, executor := range bw.executors {
-		bins = append(bins, executor.variables)
+		var bin []byte
+		if keyed, ok := executor.bolt.(state.KeyedStateful); ok {
+			states, err := keyed.SnapshotKeys()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			bin, _ = json.Marshal(states)
+		} else if stateful, ok := executor.bolt.(state.Stateful); ok {
+			snapshot, err := stateful.Snapshot()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			bin = snapshot
+		}
+		bins = append(bins, bin)
 	}
 
-	// Create file to store
-	filename := fmt.Sprintf("%s_%s", bw.Name, version)
-	file, err := os.Create(filename)
+	// Save bins's binary value as the state of this version
+	b, err := json.Marshal(bins)
 	if err != nil {
-		fmt.Println(err)
-		// os.Exit(1)
+		log.Println(err)
+		return
+	}
+	v, _ := strconv.Atoi(version)
+	if err := bw.state.Save(bw.Name, v, b); err != nil {
+		log.Println(err)
+		return
+	}
+	// Only keep the latest versions for restoring
+	if err := state.Prune(bw.state, bw.Name, STATE_RETENTION); err != nil {
+		log.Println(err)
 	}
-	defer file.Close()
 
-	// Store bins's binary value into the file
-	b, _ := json.Marshal(bins)
-	file.Write(b)
+	// Save the sink's transactions not committed yet
+	if bw.sink != nil {
+		bw.rwmutex.RLock()
+		b, _ := json.Marshal(bw.committing)
+		bw.rwmutex.RUnlock()
+		if err := bw.state.Save(bw.Name+SINK_STATE_SUFFIX, v, b); err != nil {
+			log.Println(err)
+			return
+		}
+		if err := state.Prune(bw.state, bw.Name+SINK_STATE_SUFFIX, STATE_RETENTION); err != nil {
+			log.Println(err)
+		}
+	}
 }
 
-// Deserialize executors' variables from local file
+// Deserialize executors' bolt states from the state backend
 func (bw *BoltWorker) DeserializeVariables(version string) {
-	fmt.Printf("%s Start Deserializing Variables With Version %s\n", bw.Name, version)
-	// Open the local file that stores the variables' binary value
-	filename := fmt.Sprintf("%s_%s", bw.Name, version)
-	b, err := ioutil.ReadFile(filename)
+	log.Printf("%s Start Deserializing Variables With Version %s\n", bw.Name, version)
+	v, _ := strconv.Atoi(version)
+	// The number of tasks changed since the snapshot
+	if bw.stateInst > 0 && bw.stateInst != bw.instNum {
+		bw.repartitionVariables(v)
+		return
+	}
+	bins, err := bw.loadVariables(bw.Name, v)
 	if err != nil {
-		fmt.Println(err)
-		// os.Exit(1)
+		log.Println(err)
+		return
+	}
+
+	// Restore each executor's bolt state
+	for index, bin := range bins {
+		if index >= len(bw.executors) || bin == nil {
+			continue
+		}
+		if keyed, ok := bw.executors[index].bolt.(state.KeyedStateful); ok {
+			var states []state.KeyedState
+			json.Unmarshal(bin, &states)
+			if err := keyed.RestoreKeys(states); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		stateful, ok := bw.executors[index].bolt.(state.Stateful)
+		if !ok {
+			continue
+		}
+		if err := stateful.Restore(bin); err != nil {
+			log.Println(err)
+		}
 	}
 
+	// Load the sink's transactions not committed at the snapshot
+	if bw.sink != nil {
+		bw.committing = bw.loadCommitting(bw.Name, v)
+	}
+}
+
+// Load the executors' states of the task at the version
+func (bw *BoltWorker) loadVariables(task string, version int) ([][]byte, error) {
+	b, err := bw.state.Load(task, version)
+	if err != nil {
+		return nil, err
+	}
 	// Unmarshal the binary value
-	var bins []interface{}
-	json.Unmarshal(b, &bins)
+	var bins [][]byte
+	err = json.Unmarshal(b, &bins)
+	return bins, err
+}
 
-	// Deserialize to get each executor's variables
-	for index, bin := range bins {
-		bw.executors[index].variables = bin.([]interface{})
+// Load the sink's transactions of the task not committed at the version
+func (bw *BoltWorker) loadCommitting(task string, version int) []int {
+	committing := make([]int, 0)
+	b, err := bw.state.Load(task+SINK_STATE_SUFFIX, version)
+	if err != nil {
+		log.Println(err)
+		return committing
 	}
+	json.Unmarshal(b, &committing)
+	return committing
 }
 
 // The channel to communicate with the supervisor
 func (bw *BoltWorker) TalkWithSupervisor() {
 	// Message Type:
 	// Superviosr -> Worker
-	// 1. Please Serialize Variables With Version X    Superviosr -> Worker
 	// 2. Please Kill Yourself                         Superviosr -> Worker
-	// 3. Please Suspend                               Superviosr -> Worker
-	// 4. Please Resume                                Superviosr -> Worker
+	// 5. Please Commit Version X                      Superviosr -> Worker
+	// 6. Please Rewire old>new ... Abandoning X       Superviosr -> Worker
 	// Worker -> Supervisor
 	// 1. Serialized Variables With Version X          Worker -> Supervisor
-	// 2. W Suspended                                  Worker -> Supervisor
+	// Bolt workers serialize variables when the checkpoint barriers align
 
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("Recovered in f", r)
+			bw.crash(fmt.Sprintf("TalkWithSupervisor panics: %v", r))
 		}
 	}()
 
 	for {
 		select {
+		case <-bw.done:
+			return
 		case message := <-bw.SupervisorC:
 			switch string(message[0]) {
-			case "1":
-				words := strings.Fields(message)
-				version := words[len(words)-1]
-				bw.SerializeVariables(version)
-				bw.Version = version
-				// Notify the supervisor it serialized the variables
-				bw.WorkerC <- fmt.Sprintf("1. %s Serialized Variables With Version %s", bw.Name, version)
-
 			case "2":
-				bw.wg.Done()
+				bw.stop("")
+
+			case "5":
+				if bw.sink != nil {
+					words := strings.Fields(message)
+					version, _ := strconv.Atoi(words[len(words)-1])
+					bw.commit(version)
+				}
+
+			case "6":
+				bw.rewire(message)
 			}
 		default:
 			time.Sleep(5 * time.Millisecond)
 		}
 	}
 }
+
+// Subscribe to the new addresses of the previous tasks moved to other
+// supervisors, the receiving loop swaps the subscribers
+func (bw *BoltWorker) rewire(message string) {
+	words := strings.Fields(message)
+	abandoned, _ := strconv.Atoi(words[len(words)-1])
+	r := rewire{subscribers: make(map[int]*messages.Subscriber), abandoned: abandoned}
+	for _, pair := range words[3 : len(words)-2] {
+		old, addr, _ := strings.Cut(pair, ">")
+		for index, subAddr := range bw.subAddrs {
+			if subAddr != old {
+				continue
+			}
+			subscriber := messages.NewSubscriber(addr)
+			if subscriber == nil {
+				log.Printf("%s fails to connect %s\n", bw.Name, addr)
+				continue
+			}
+			go subscriber.ReadMessage()
+			go subscriber.RequestMessage()
+			// Tell the moved task who am I
+			bin, _ := json.Marshal(utils.SubscribeHello{Name: bw.Name, Codecs: bw.codecs})
+			subscriber.Request <- messages.Message{
+				Payload: bin,
+			}
+			bw.subAddrs[index] = addr
+			r.subscribers[index] = subscriber
+			log.Printf("%s Rewired From %s to %s\n", bw.Name, old, addr)
+		}
+	}
+	bw.rewires <- r
+}
+
+// Stop the worker, with the reason if it crashed. Only the first
+// stop counts, the panics of the goroutines stopping with it do not
+func (bw *BoltWorker) stop(reason string) {
+	bw.stopOnce.Do(func() {
+		if reason != "" {
+			bw.failure.Store(reason)
+		}
+		close(bw.done)
+		bw.wg.Done()
+	})
+}
+
+// Crash the worker, its supervisor restarts it
+func (bw *BoltWorker) crash(reason string) {
+	log.Printf("Bolt Worker %s Crashes: %s\n", bw.Name, reason)
+	bw.stop(reason)
+}
+
+// Reason of the crash, empty if the worker is running or killed
+func (bw *BoltWorker) Failure() string {
+	reason, _ := bw.failure.Load().(string)
+	return reason
+}
+
+// Status of the worker for the supervisor heartbeats
+func (bw *BoltWorker) Status() utils.WorkerStatus {
+	status := utils.WorkerStatus{
+		Task:     bw.Name,
+		Kind:     "bolt",
+		State:    utils.WORKER_RUNNING,
+		Emitted:  atomic.LoadUint64(&bw.emitted),
+		Executed: atomic.LoadUint64(&bw.executed),
+		Failed:   atomic.LoadUint64(&bw.failed),
+	}
+	// The driver checks the state before rebalancing the bolt
+	if len(bw.executors) > 0 {
+		if _, ok := bw.executors[0].bolt.(state.KeyedStateful); ok {
+			status.StateKind = utils.STATE_KEYED
+		} else if _, ok := bw.executors[0].bolt.(state.Stateful); ok {
+			status.StateKind = utils.STATE_TASK
+		}
+	}
+	if atomic.LoadInt32(&bw.terminated) == 1 {
+		status.State = utils.WORKER_DEAD
+	}
+	if status.Error = bw.Failure(); status.Error != "" {
+		status.State = utils.WORKER_FAILED
+	}
+	return status
+}
@@ -0,0 +1,46 @@
+package boltworker
+
+import (
+	"crane/bolt"
+	"encoding/json"
+)
+
+// Bolt driving the raw process function exported by the plugins
+// written before bolt.Bolt, its variables are checkpointed as JSON
+type procFuncBolt struct {
+	procFunc  func([]interface{}, *[]interface{}, *[]interface{}) error
+	variables []interface{}
+	collector *bolt.BoltOutputCollector
+}
+
+func newProcFuncBolt(procFunc func([]interface{}, *[]interface{}, *[]interface{}) error) *procFuncBolt {
+	return &procFuncBolt{
+		procFunc:  procFunc,
+		variables: make([]interface{}, 0), // Store bolt's global variables
+	}
+}
+
+func (pb *procFuncBolt) Prepare(task string, collector *bolt.BoltOutputCollector) error {
+	pb.collector = collector
+	return nil
+}
+
+func (pb *procFuncBolt) Execute(tuple []interface{}) error {
+	var result []interface{}
+	err := pb.procFunc(tuple, &result, &pb.variables)
+	if len(result) > 0 {
+		pb.collector.Emit(result...)
+	}
+	return err
+}
+
+func (pb *procFuncBolt) Cleanup() {
+}
+
+func (pb *procFuncBolt) Snapshot() ([]byte, error) {
+	return json.Marshal(pb.variables)
+}
+
+func (pb *procFuncBolt) Restore(data []byte) error {
+	return json.Unmarshal(data, &pb.variables)
+}
@@ -0,0 +1,125 @@
+package boltworker
+
+import (
+	"crane/core/state"
+	"crane/core/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Component name and 1-based index of the task, e.g. WordCountBolt_2
+func splitTaskName(name string) (string, int) {
+	i := strings.LastIndex(name, "_")
+	if i < 0 {
+		return name, 1
+	}
+	index, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return name, 1
+	}
+	return name[:i], index
+}
+
+// Restore the variables after the bolt is rebalanced. The keyed states of
+// all the previous tasks are repartitioned by the hash of the keys like the
+// tuples are, the other states are kept by the tasks of the same name
+func (bw *BoltWorker) repartitionVariables(version int) {
+	component, index := splitTaskName(bw.Name)
+	log.Printf("%s Repartitions Variables of %d Tasks Into %d\n", bw.Name, bw.stateInst, bw.instNum)
+
+	keyed := make([]state.KeyedState, 0)
+	for i := 1; i <= bw.stateInst; i++ {
+		task := fmt.Sprintf("%s_%d", component, i)
+		bins, err := bw.loadVariables(task, version)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for e, bin := range bins {
+			if bin == nil {
+				continue
+			}
+			// Only the executors of the keyed bolts have their keys
+			if e >= len(bw.executors) {
+				break
+			}
+			if _, ok := bw.executors[e].bolt.(state.KeyedStateful); !ok {
+				if i == index {
+					bw.restoreExecutor(e, bin)
+				}
+				continue
+			}
+			var states []state.KeyedState
+			json.Unmarshal(bin, &states)
+			for _, s := range states {
+				if utils.Hash(s.Key)%bw.instNum == index-1 {
+					keyed = append(keyed, s)
+				}
+			}
+		}
+	}
+
+	// The tasks of a bolt have one executor, it restores all the keys
+	// of the task
+	if len(bw.executors) > 0 {
+		if k, ok := bw.executors[0].bolt.(state.KeyedStateful); ok {
+			if err := k.RestoreKeys(keyed); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	if index > bw.stateInst {
+		log.Printf("%s Is a New Task, Its Variables Not Keyed Start Empty\n", bw.Name)
+	}
+
+	// The sink's transactions are kept by the task of the same name
+	if bw.sink != nil && index <= bw.stateInst {
+		bw.committing = bw.loadCommitting(bw.Name, version)
+	}
+}
+
+// Restore the not keyed state of the executor
+func (bw *BoltWorker) restoreExecutor(e int, bin []byte) {
+	stateful, ok := bw.executors[e].bolt.(state.Stateful)
+	if !ok {
+		return
+	}
+	if err := stateful.Restore(bin); err != nil {
+		log.Println(err)
+	}
+}
+
+// Commit the transactions pre-committed by the sink tasks removed by
+// rebalancing, every removed task is recovered by one remaining task
+func (bw *BoltWorker) recoverRemovedSinks(version int) {
+	component, index := splitTaskName(bw.Name)
+	for i := bw.instNum + 1; i <= bw.stateInst; i++ {
+		if (i-1)%bw.instNum != index-1 {
+			continue
+		}
+		task := fmt.Sprintf("%s_%d", component, i)
+		sink := bw.newSink()
+		if err := sink.Open(task); err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, v := range bw.loadCommitting(task, version) {
+			if v > version {
+				continue
+			}
+			if err := sink.Commit(v); err != nil {
+				log.Printf("%s Fails to Commit Version %d of %s: %v\n", bw.Name, v, task, err)
+			}
+		}
+		if err := sink.Abort(version); err != nil {
+			log.Println(err)
+		}
+		if err := sink.Close(); err != nil {
+			log.Println(err)
+		}
+		log.Printf("%s Recovered the Sink of Removed Task %s\n", bw.Name, task)
+	}
+}
@@ -2,38 +2,72 @@ package client
 
 import (
 	"crane/core/messages"
+	"crane/core/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Error of the topology rejected by the driver, with all the reasons
+type SubmissionError struct {
+	Errors []string
+}
+
+func (e *SubmissionError) Error() string {
+	return "topology rejected: " + strings.Join(e.Errors, "; ")
+}
+
+// Time to wait for the response of the driver
+const REQUEST_TIMEOUT = 30 * time.Second
+
 // Client, the instance for client to submit
 // tasks and contact with the master node
 type Client struct {
-	Sub *messages.Subscriber
+	Sub        *messages.Subscriber
+	requestSeq uint64
+	pending    map[uint64]chan *utils.PayloadMessage
+	lock       sync.Mutex
+	once       sync.Once
 }
 
-// Factory mode to return the Client instance
+// Factory mode to return the Client instance, the addresses of the
+// leader and the standby drivers are separated by commas
 func NewClient(driverAddr string) *Client {
 	client := &Client{}
-	client.Sub = messages.NewSubscriber(driverAddr)
+	client.Sub = messages.NewSubscriberOfAny(strings.Split(driverAddr, ","))
 	if client.Sub == nil {
 		return nil
 	}
+	client.pending = make(map[uint64]chan *utils.PayloadMessage)
 	return client
 }
 
-// Client instance start to submit topology message
-// after reveicing acknowledgment, it would terminate
+// Client instance start to read the responses of the driver,
+// each one is dispatched to the request of the same id
 func (c *Client) Start() {
-	go c.Sub.RequestMessage()
-	go c.Sub.ReadMessage()
-	for {
-		select {
-		case rcvMsg := <-c.Sub.PublishBoard:
-			log.Printf("Receive Message from %s: %s", rcvMsg.SourceConnId, rcvMsg.Payload)
-			return
-		default:
+	c.once.Do(func() {
+		go c.Sub.RequestMessage()
+		go c.Sub.ReadMessage()
+		go c.dispatch()
+	})
+}
 
+func (c *Client) dispatch() {
+	for rcvMsg := range c.Sub.PublishBoard {
+		payload := utils.CheckType(rcvMsg.Payload)
+		c.lock.Lock()
+		ch, ok := c.pending[payload.Header.RequestId]
+		delete(c.pending, payload.Header.RequestId)
+		c.lock.Unlock()
+		if !ok {
+			log.Printf("Receive Unexpected Message from %s: %s", rcvMsg.SourceConnId, rcvMsg.Payload)
+			continue
 		}
+		ch <- payload
 	}
 }
 
@@ -45,3 +79,131 @@ func (c *Client) ContactDriver(msg []byte) {
 		SourceConnId: c.Sub.Conn.LocalAddr().String(),
 	}
 }
+
+// Send the request to the driver and wait for its response
+func (c *Client) Request(contentType string, content interface{}) (*utils.PayloadMessage, error) {
+	c.Start()
+	c.lock.Lock()
+	c.requestSeq++
+	id := c.requestSeq
+	ch := make(chan *utils.PayloadMessage, 1)
+	c.pending[id] = ch
+	c.lock.Unlock()
+
+	b, err := utils.MarshalRequest(contentType, id, content)
+	if err != nil {
+		return nil, err
+	}
+	c.ContactDriver(b)
+
+	select {
+	case payload := <-ch:
+		return payload, nil
+	case <-time.After(REQUEST_TIMEOUT):
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+		return nil, fmt.Errorf("no response of %s from the driver in %v", contentType, REQUEST_TIMEOUT)
+	}
+}
+
+// Submit the topology, the error is a *SubmissionError
+// with all the reasons if the driver rejects it
+func (c *Client) Submit(topo interface{}) (string, error) {
+	payload, err := c.Request(utils.TOPO_SUBMISSION, topo)
+	if err != nil {
+		return "", err
+	}
+	res := &utils.TopoSubmissionResponse{}
+	utils.Unmarshal(payload.Content, res)
+	if !res.Accepted {
+		return "", &SubmissionError{Errors: res.Errors}
+	}
+	return res.Id, nil
+}
+
+// Kill the topology, its workers are stopped and it is not restored any more
+func (c *Client) Kill(id string) error {
+	return c.Command(utils.TOPO_KILL, utils.TopologyCommand{Topology: id})
+}
+
+// Deactivate the topology, its spouts are suspended until activated
+func (c *Client) Deactivate(id string) error {
+	return c.Command(utils.TOPO_DEACTIVATE, utils.TopologyCommand{Topology: id})
+}
+
+// Activate the deactivated topology, its spouts resume
+func (c *Client) Activate(id string) error {
+	return c.Command(utils.TOPO_ACTIVATE, utils.TopologyCommand{Topology: id})
+}
+
+// Change the number of the tasks of the bolt of the running topology.
+// All the tasks of the topology restart from the last completed snapshot
+func (c *Client) Rebalance(id string, component string, instNum int) error {
+	return c.Command(utils.TOPO_REBALANCE, utils.TopologyCommand{
+		Topology:  id,
+		Component: component,
+		InstNum:   instNum,
+	})
+}
+
+// Send the command to the driver and wait for its response
+func (c *Client) Command(cmdType string, cmd utils.TopologyCommand) error {
+	payload, err := c.Request(cmdType, cmd)
+	if err != nil {
+		return err
+	}
+	res := &utils.CommandResponse{}
+	utils.Unmarshal(payload.Content, res)
+	if !res.Ok {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+// Take a snapshot of the topology now, not waiting for the interval
+func (c *Client) Checkpoint(id string) error {
+	return c.Command(utils.TOPO_CHECKPOINT, utils.TopologyCommand{Topology: id})
+}
+
+// Summaries of the running topologies
+func (c *Client) ListTopologies() ([]utils.TopologySummary, error) {
+	summaries := make([]utils.TopologySummary, 0)
+	err := c.Query(utils.TOPO_LIST, utils.TopologyQuery{}, &summaries)
+	return summaries, err
+}
+
+// Components of the topology and the placements of its tasks
+func (c *Client) DescribeTopology(id string) (*utils.TopologyDescription, error) {
+	desc := &utils.TopologyDescription{}
+	err := c.Query(utils.TOPO_DESCRIBE, utils.TopologyQuery{Topology: id}, desc)
+	return desc, err
+}
+
+// Supervisors joined the cluster, with their free slots
+func (c *Client) ListSupervisors() ([]utils.SupervisorInfo, error) {
+	supervisors := make([]utils.SupervisorInfo, 0)
+	err := c.Query(utils.SUPERVISOR_LIST, utils.TopologyQuery{}, &supervisors)
+	return supervisors, err
+}
+
+// The latest lines logged by the supervisor about the task
+func (c *Client) TailLogs(id string, task string, lines int) ([]string, error) {
+	logs := make([]string, 0)
+	err := c.Query(utils.TASK_LOGS, utils.TopologyQuery{Topology: id, Task: task, Lines: lines}, &logs)
+	return logs, err
+}
+
+// Send the query to the driver and unmarshal its result into v
+func (c *Client) Query(queryType string, query utils.TopologyQuery, v interface{}) error {
+	payload, err := c.Request(queryType, query)
+	if err != nil {
+		return err
+	}
+	res := &utils.QueryResponse{}
+	utils.Unmarshal(payload.Content, res)
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return json.Unmarshal(res.Content, v)
+}
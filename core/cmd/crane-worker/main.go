@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"crane/core/boltworker"
+	"crane/core/messages"
+	"crane/core/spoutworker"
+	"crane/core/state"
+	"crane/core/utils"
+	sdfs "crane/simpledfs/client"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Spout or bolt worker run in this process
+type Runner interface {
+	Start()
+	Failure() string
+	Status() utils.WorkerStatus
+}
+
+// Worker process started by a supervisor with -isolate, it runs the spout
+// or bolt of a single task and talks to the supervisor over a unix socket,
+// one JSON payload per frame. It exits with 1 if the worker crashes
+func main() {
+	socketPtr := flag.String("socket", "", "Unix socket of the supervisor")
+	sdfsPtr := flag.String("sdfs", sdfs.DefaultMasterAddr, "SDFS master's IP:Port address")
+	statePtr := flag.String("state", "./state", "Directory of the local state backend")
+	dirPtr := flag.String("dir", ".", "Directory of the plugin files")
+	flag.Parse()
+
+	conn, err := net.Dial("unix", *socketPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := messages.Handshake(conn); err != nil {
+		log.Fatal(err)
+	}
+	var lock sync.Mutex
+	send := func(msgType string, content interface{}) {
+		b, _ := utils.Marshal(msgType, content)
+		lock.Lock()
+		defer lock.Unlock()
+		if err := messages.WriteFrame(conn, b); err != nil {
+			log.Println(err)
+		}
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := messages.ReadFrame(reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	name, w, err := NewWorker(utils.CheckType(line), *dirPtr, *statePtr, *sdfsPtr)
+	if err != nil {
+		log.Println(err)
+		send(utils.WORKER_FAILURE, utils.WorkerFailure{Task: name, Reason: err.Error()})
+		os.Exit(1)
+	}
+	log.Printf("Worker %s Running in Process %d\n", name, os.Getpid())
+
+	// Relay the messages between the worker and the supervisor, the
+	// worker exits with its supervisor
+	go func() {
+		for {
+			line, err := messages.ReadFrame(reader)
+			if err != nil {
+				log.Printf("Supervisor of Worker %s Is Gone: %v\n", name, err)
+				os.Exit(1)
+			}
+			payload := utils.CheckType(line)
+			if payload.Header.Type == utils.WORKER_MESSAGE {
+				var message string
+				utils.Unmarshal(payload.Content, &message)
+				w.SupervisorC <- message
+			}
+		}
+	}()
+	go func() {
+		for message := range w.WorkerC {
+			send(utils.WORKER_MESSAGE, message)
+		}
+	}()
+	go func() {
+		for {
+			send(utils.WORKER_STATUS, w.Status())
+			time.Sleep(utils.HEARTBEAT_INTERVAL * time.Second)
+		}
+	}()
+
+	w.Start()
+	send(utils.WORKER_STATUS, w.Status())
+	if reason := w.Failure(); reason != "" {
+		send(utils.WORKER_FAILURE, utils.WorkerFailure{Task: name, Reason: reason})
+		os.Exit(1)
+	}
+}
+
+// Worker of the task and its channels to the supervisor
+type Worker struct {
+	SupervisorC chan string
+	WorkerC     chan string
+	Runner
+}
+
+// Create the worker of the task payload, with the state backend of
+// this process, so the memory backend is not shared with the supervisor
+func NewWorker(payload *utils.PayloadMessage, pluginDir string, stateDir string, sdfsAddr string) (string, *Worker, error) {
+	w := &Worker{SupervisorC: make(chan string), WorkerC: make(chan string)}
+	switch payload.Header.Type {
+	case utils.BOLT_TASK:
+		task := &utils.BoltTaskMessage{}
+		utils.Unmarshal(payload.Content, task)
+		// The supervisor tails the logs of the task by its name
+		log.SetPrefix(task.Name + " ")
+		backend, err := newStateBackend(task.Topology, task.StateBackend, stateDir, sdfsAddr)
+		if err != nil {
+			return task.Name, nil, err
+		}
+		w.Runner, err = boltworker.NewBoltWorker(1, task.Name, filepath.Join(pluginDir, task.PluginFile), task.PluginSymbol,
+			task.Port, task.PrevBoltAddr, task.PrevBoltGroupingHint, task.PrevBoltFieldIndex,
+			task.SuccBoltGroupingHint, task.SuccBoltFieldIndex, task.SuccStreams, task.SuccFieldIndexes, w.SupervisorC, w.WorkerC, task.SnapshotVersion,
+			backend, task.AckerAddr, task.InstNum, task.StateInstNum, task.Codec, task.Batch)
+		return task.Name, w, err
+
+	case utils.SPOUT_TASK:
+		task := &utils.SpoutTaskMessage{}
+		utils.Unmarshal(payload.Content, task)
+		log.SetPrefix(task.Name + " ")
+		backend, err := newStateBackend(task.Topology, task.StateBackend, stateDir, sdfsAddr)
+		if err != nil {
+			return task.Name, nil, err
+		}
+		w.Runner, err = spoutworker.NewSpoutWorker(task.Name, filepath.Join(pluginDir, task.PluginFile), task.PluginSymbol, task.Port,
+			task.GroupingHint, task.FieldIndex, task.SuccStreams, task.SuccFieldIndexes, w.SupervisorC, w.WorkerC, task.SnapshotVersion, backend,
+			task.AckerAddr, task.AckTimeout, task.Batch)
+		return task.Name, w, err
+	}
+	return "", nil, fmt.Errorf("unknown task %s", payload.Header.Type)
+}
+
+// State backend of the kind selected by the topology, in its namespace
+func newStateBackend(topologyId string, kind string, stateDir string, sdfsAddr string) (state.StateBackend, error) {
+	backend, err := state.NewBackend(kind, stateDir, sdfsAddr)
+	if err != nil {
+		return nil, err
+	}
+	return state.NewNamespacedBackend(backend, topologyId), nil
+}
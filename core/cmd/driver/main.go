@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crane/core/driver"
+	"crane/core/utils"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	configPtr := flag.String("config", "", "Cluster config file, YAML or JSON, $"+utils.ENV_CONFIG+" by default")
+	peersPtr := flag.String("peers", "", "Drivers' IP:Port addresses in the order they take over as the leader, comma separated, the drivers of the config by default")
+	selfPtr := flag.String("self", "", "This driver's IP:Port address among the peers, to run as a leader or a standby")
+	storePtr := flag.String("store", utils.STATE_BACKEND_LOCAL, "Store of the cluster state, local or sdfs")
+	statePtr := flag.String("state", "", "File of the cluster state in the store, "+driver.DRIVER_STATE_FILE+" in the data directory by default")
+	sdfsPtr := flag.String("sdfs", "", "SDFS master's IP:Port address, overrides the config")
+	dirPtr := flag.String("dir", "", "Data directory, overrides the config")
+	flag.Parse()
+
+	cfg, err := utils.LoadConfig(*configPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *peersPtr != "" {
+		cfg.Drivers = strings.Split(*peersPtr, ",")
+	}
+	if *sdfsPtr != "" {
+		cfg.SdfsMaster = *sdfsPtr
+	}
+	if *dirPtr != "" {
+		cfg.DataDir = *dirPtr
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	if *statePtr == "" {
+		*statePtr = filepath.Join(cfg.DataDir, driver.DRIVER_STATE_FILE)
+	}
+
+	store, err := driver.NewStore(*storePtr, *statePtr, cfg.SdfsMaster)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// A single driver listens on the port of the first address
+	self := cfg.Drivers[0]
+	if *selfPtr != "" {
+		self = *selfPtr
+		found := false
+		for _, peer := range cfg.Drivers {
+			found = found || peer == self
+		}
+		if !found {
+			log.Fatalf("Driver %q is not one of the peers %v\n", self, cfg.Drivers)
+		}
+		driver.WaitForLeadership(cfg.Drivers, self)
+	}
+	_, port, err := net.SplitHostPort(self)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	d := driver.NewDriver(":" + port)
+	d.Store = store
+	if err := d.Restore(); err != nil {
+		log.Fatal(err)
+	}
+	if *selfPtr != "" {
+		go d.CheckTerm()
+	}
+	LocalIP := utils.GetLocalIP().String()
+	LocalHostname := utils.GetLocalHostname()
+	log.Printf("Local Machine Info [%s] [%s]\n", LocalIP, LocalHostname)
+	d.StartDaemon()
+}
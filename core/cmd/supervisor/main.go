@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crane/core/supervisor"
+	"crane/core/utils"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+func main() {
+	configPtr := flag.String("config", "", "Cluster config file, YAML or JSON, $"+utils.ENV_CONFIG+" by default")
+	driversPtr := flag.String("drivers", "", "IP:Port addresses of the leader and the standby drivers, comma separated, overrides the config")
+	sdfsPtr := flag.String("sdfs", "", "SDFS master's IP:Port address, overrides the config")
+	hostPtr := flag.String("host", "", "Host the other machines reach the workers at, overrides the config")
+	portsPtr := flag.String("ports", "", "Range of the ports of the workers, e.g. 6000-6999, overrides the config")
+	dirPtr := flag.String("dir", "", "Data directory of the plugin files and the states, overrides the config")
+	cpuPtr := flag.Float64("cpu", float64(runtime.NumCPU()*100), "CPU for the tasks in percent of a core")
+	memoryPtr := flag.Int("memory", 0, "Memory for the tasks in MB, the total memory by default")
+	slotsPtr := flag.Int("slots", utils.SUPERVISOR_SLOTS, "Max number of tasks, at most the number of the ports")
+	isolatePtr := flag.Bool("isolate", false, "Run every worker in its own crane-worker process")
+	workerBinPtr := flag.String("worker-bin", "crane-worker", "crane-worker binary for -isolate")
+	flag.Parse()
+
+	cfg, err := utils.LoadConfig(*configPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *driversPtr != "" {
+		cfg.Drivers = strings.Split(*driversPtr, ",")
+	}
+	if *sdfsPtr != "" {
+		cfg.SdfsMaster = *sdfsPtr
+	}
+	if *hostPtr != "" {
+		cfg.Host = *hostPtr
+	}
+	if *portsPtr != "" {
+		cfg.Ports = *portsPtr
+	}
+	if *dirPtr != "" {
+		cfg.DataDir = *dirPtr
+	}
+	portMin, portMax, err := cfg.PortRange()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The crane-worker processes run with the same directory
+	dataDir, err := filepath.Abs(cfg.DataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	// remove all sdfs files set up before
+	files, err := filepath.Glob(filepath.Join(dataDir, "*_*"))
+	if err != nil {
+		log.Println(err)
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			log.Println(err)
+		}
+	}
+
+	LocalIP := utils.GetLocalIP().String()
+	LocalHostname := utils.GetLocalHostname()
+	log.Printf("Local Machine Info [%s] [%s]\n", LocalIP, LocalHostname)
+
+	s := supervisor.NewSupervisor(cfg.Drivers, cfg.SdfsMaster)
+	if s == nil {
+		log.Println("Initialize supervisor failed")
+		return
+	}
+	s.Host = cfg.Host
+	s.PortMin, s.PortMax = portMin, portMax
+	s.DataDir = dataDir
+	s.Capacity = utils.Resources{CPU: *cpuPtr, Memory: *memoryPtr}
+	if s.Capacity.Memory <= 0 {
+		s.Capacity.Memory = utils.GetTotalMemory()
+	}
+	if s.Capacity.Memory <= 0 {
+		s.Capacity.Memory = utils.DEFAULT_SUPERVISOR_MEMORY
+	}
+	// Every task listens on a port of its own
+	s.Slots = *slotsPtr
+	if s.Slots > portMax-portMin+1 {
+		s.Slots = portMax - portMin + 1
+	}
+	if *isolatePtr {
+		s.WorkerBinary, err = exec.LookPath(*workerBinPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Run Workers in Processes of %s\n", s.WorkerBinary)
+	}
+	// Keep the latest logs of the workers for the crane tool
+	log.SetOutput(io.MultiWriter(os.Stderr, s.Logs))
+	s.StartDaemon()
+}
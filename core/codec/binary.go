@@ -0,0 +1,295 @@
+package codec
+
+import (
+	"crane/core/utils"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// First byte of the binary tuples, no JSON document starts with it
+const BINARY_MAGIC = 0xB1
+
+// Type tags of the values of the binary tuples
+const (
+	TAG_NIL = iota
+	TAG_FALSE
+	TAG_TRUE
+	TAG_INT
+	TAG_INT32
+	TAG_INT64
+	TAG_UINT64
+	TAG_FLOAT32
+	TAG_FLOAT64
+	TAG_STRING
+	TAG_BYTES
+	TAG_TIME
+	TAG_LIST
+	TAG_MAP
+	// Values of the other types, encoded as JSON
+	TAG_JSON
+)
+
+var errShortTuple = errors.New("binary tuple is truncated")
+
+// BinaryCodec encodes the tuples compactly with a type tag per value, the
+// values arrive with their types: int, int32, int64, uint64, float32,
+// float64, string, []byte, time.Time, bool, nil, and the []interface{} and
+// map[string]interface{} of them. The other unsigned integers arrive as
+// uint64, and the values of the other types as decoded from JSON
+type BinaryCodec struct{}
+
+func (BinaryCodec) Name() string {
+	return utils.CODEC_BINARY
+}
+
+func (BinaryCodec) Encode(tuple utils.TupleMessage) ([]byte, error) {
+	b := []byte{BINARY_MAGIC}
+	b = binary.AppendUvarint(b, tuple.Id)
+	b = binary.AppendUvarint(b, tuple.Root)
+	b = appendString(b, tuple.Stream)
+	b = binary.AppendVarint(b, int64(tuple.Barrier))
+	b = binary.AppendUvarint(b, uint64(len(tuple.Values)))
+	var err error
+	for _, value := range tuple.Values {
+		if b, err = appendValue(b, value); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (BinaryCodec) Decode(b []byte, tuple *utils.TupleMessage) error {
+	if len(b) == 0 || b[0] != BINARY_MAGIC {
+		return errors.New("not a binary tuple")
+	}
+	d := &decoder{b: b[1:]}
+	tuple.Id = d.uvarint()
+	tuple.Root = d.uvarint()
+	tuple.Stream = d.string()
+	tuple.Barrier = int(d.varint())
+	n := d.uvarint()
+	// Every value takes one byte at least
+	if n > uint64(len(d.b)) {
+		return errShortTuple
+	}
+	tuple.Values = make([]interface{}, n)
+	for i := range tuple.Values {
+		tuple.Values[i] = d.value()
+	}
+	return d.err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendValue(b []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(b, TAG_NIL), nil
+	case bool:
+		if v {
+			return append(b, TAG_TRUE), nil
+		}
+		return append(b, TAG_FALSE), nil
+	case int:
+		return binary.AppendVarint(append(b, TAG_INT), int64(v)), nil
+	case int8:
+		return binary.AppendVarint(append(b, TAG_INT64), int64(v)), nil
+	case int16:
+		return binary.AppendVarint(append(b, TAG_INT64), int64(v)), nil
+	case int32:
+		return binary.AppendVarint(append(b, TAG_INT32), int64(v)), nil
+	case int64:
+		return binary.AppendVarint(append(b, TAG_INT64), v), nil
+	case uint:
+		return binary.AppendUvarint(append(b, TAG_UINT64), uint64(v)), nil
+	case uint8:
+		return binary.AppendUvarint(append(b, TAG_UINT64), uint64(v)), nil
+	case uint16:
+		return binary.AppendUvarint(append(b, TAG_UINT64), uint64(v)), nil
+	case uint32:
+		return binary.AppendUvarint(append(b, TAG_UINT64), uint64(v)), nil
+	case uint64:
+		return binary.AppendUvarint(append(b, TAG_UINT64), v), nil
+	case float32:
+		return binary.BigEndian.AppendUint32(append(b, TAG_FLOAT32), math.Float32bits(v)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, TAG_FLOAT64), math.Float64bits(v)), nil
+	case string:
+		return appendString(append(b, TAG_STRING), v), nil
+	case []byte:
+		b = binary.AppendUvarint(append(b, TAG_BYTES), uint64(len(v)))
+		return append(b, v...), nil
+	case time.Time:
+		// The zone offset is kept, the location name is not
+		t, err := v.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = binary.AppendUvarint(append(b, TAG_TIME), uint64(len(t)))
+		return append(b, t...), nil
+	case []interface{}:
+		b = binary.AppendUvarint(append(b, TAG_LIST), uint64(len(v)))
+		var err error
+		for _, e := range v {
+			if b, err = appendValue(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = binary.AppendUvarint(append(b, TAG_MAP), uint64(len(v)))
+		var err error
+		for key, e := range v {
+			b = appendString(b, key)
+			if b, err = appendValue(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	j, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("encode %T: %v", value, err)
+	}
+	b = binary.AppendUvarint(append(b, TAG_JSON), uint64(len(j)))
+	return append(b, j...), nil
+}
+
+// Decoder of the binary values, the first error stops decoding
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.b = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail(errShortTuple)
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail(errShortTuple)
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail(errShortTuple)
+		return nil
+	}
+	v := make([]byte, n)
+	copy(v, d.b)
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) fixed(n int) []byte {
+	if len(d.b) < n {
+		d.fail(errShortTuple)
+		return make([]byte, n)
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) value() interface{} {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.b) == 0 {
+		d.fail(errShortTuple)
+		return nil
+	}
+	tag := d.b[0]
+	d.b = d.b[1:]
+	switch tag {
+	case TAG_NIL:
+		return nil
+	case TAG_FALSE:
+		return false
+	case TAG_TRUE:
+		return true
+	case TAG_INT:
+		return int(d.varint())
+	case TAG_INT32:
+		return int32(d.varint())
+	case TAG_INT64:
+		return d.varint()
+	case TAG_UINT64:
+		return d.uvarint()
+	case TAG_FLOAT32:
+		return math.Float32frombits(binary.BigEndian.Uint32(d.fixed(4)))
+	case TAG_FLOAT64:
+		return math.Float64frombits(binary.BigEndian.Uint64(d.fixed(8)))
+	case TAG_STRING:
+		return d.string()
+	case TAG_BYTES:
+		return d.bytes()
+	case TAG_TIME:
+		var t time.Time
+		if err := t.UnmarshalBinary(d.bytes()); err != nil {
+			d.fail(err)
+		}
+		return t
+	case TAG_LIST:
+		n := d.uvarint()
+		if n > uint64(len(d.b)) {
+			d.fail(errShortTuple)
+			return nil
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = d.value()
+		}
+		return list
+	case TAG_MAP:
+		n := d.uvarint()
+		if n > uint64(len(d.b)) {
+			d.fail(errShortTuple)
+			return nil
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			key := d.string()
+			m[key] = d.value()
+		}
+		return m
+	case TAG_JSON:
+		var v interface{}
+		if err := json.Unmarshal(d.bytes(), &v); err != nil {
+			d.fail(err)
+		}
+		return v
+	}
+	d.fail(fmt.Errorf("unknown value tag %d", tag))
+	return nil
+}
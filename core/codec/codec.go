@@ -0,0 +1,81 @@
+package codec
+
+import (
+	"crane/core/utils"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes the tuples sent over an edge of the topology. The bolt
+// subscribing a task offers the codecs it decodes in the order it prefers
+// and the task picks the first one it knows, so every edge has its codec
+type Codec interface {
+	Name() string
+	Encode(tuple utils.TupleMessage) ([]byte, error)
+	Decode(b []byte, tuple *utils.TupleMessage) error
+}
+
+// Codec of the name, one of utils.CODEC_JSON or CODEC_BINARY
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case utils.CODEC_JSON, "":
+		return JsonCodec{}, nil
+	case utils.CODEC_BINARY:
+		return BinaryCodec{}, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// Decode the tuple encoded by any codec, the binary tuples start with
+// a byte no JSON document starts with
+func Decode(b []byte, tuple *utils.TupleMessage) error {
+	if len(b) > 0 && b[0] == BINARY_MAGIC {
+		return BinaryCodec{}.Decode(b, tuple)
+	}
+	return JsonCodec{}.Decode(b, tuple)
+}
+
+// Codecs offered by a bolt preferring the codec, JSON is always
+// offered last for the tasks knowing no other codec
+func Offer(preferred string) []string {
+	if preferred == "" || preferred == utils.CODEC_JSON {
+		return []string{utils.CODEC_JSON}
+	}
+	return []string{preferred, utils.CODEC_JSON}
+}
+
+// Name of the bolt task subscribing and the codec of the edge, the first
+// codec offered that this task knows. A bolt of the previous releases says
+// only its name, its edge stays JSON
+func ParseHello(payload []byte) (string, Codec, error) {
+	var name string
+	if err := json.Unmarshal(payload, &name); err == nil {
+		return name, JsonCodec{}, nil
+	}
+	hello := utils.SubscribeHello{}
+	if err := json.Unmarshal(payload, &hello); err != nil {
+		return "", nil, err
+	}
+	for _, offered := range hello.Codecs {
+		if c, err := NewCodec(offered); err == nil {
+			return hello.Name, c, nil
+		}
+	}
+	return hello.Name, JsonCodec{}, nil
+}
+
+// JsonCodec encodes the tuples as JSON, the numbers arrive as float64
+// and the byte slices as base64 strings
+type JsonCodec struct{}
+
+func (JsonCodec) Name() string {
+	return utils.CODEC_JSON
+}
+
+func (JsonCodec) Encode(tuple utils.TupleMessage) ([]byte, error) {
+	return json.Marshal(tuple)
+}
+
+func (JsonCodec) Decode(b []byte, tuple *utils.TupleMessage) error {
+	return json.Unmarshal(b, tuple)
+}
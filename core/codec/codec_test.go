@@ -0,0 +1,148 @@
+package codec
+
+import (
+	"crane/core/utils"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	at := time.Date(2024, 2, 29, 23, 59, 59, 123456789, time.FixedZone("", 5*3600+1800))
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"nil", nil},
+		{"false", false},
+		{"true", true},
+		{"int", 42},
+		{"int32", int32(math.MinInt32)},
+		{"int64 max", int64(math.MaxInt64)},
+		{"int64 min", int64(math.MinInt64)},
+		{"uint64 max", uint64(math.MaxUint64)},
+		{"float32", float32(1.5)},
+		{"float64", 3.141592653589793},
+		{"float64 inf", math.Inf(-1)},
+		{"string", "crane ✓"},
+		{"empty string", ""},
+		{"bytes", []byte{0, 1, 0xB1, 0xFF}},
+		{"empty bytes", []byte{}},
+		{"time", at},
+		{"list", []interface{}{int64(1), "a", nil, []byte("b")}},
+		{"map", map[string]interface{}{"n": int64(7), "b": []byte("x"), "ok": true}},
+		{"nested", []interface{}{map[string]interface{}{"l": []interface{}{uint64(1), []interface{}{}}}}},
+	}
+	c := BinaryCodec{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := utils.TupleMessage{Id: 1 << 63, Root: 2, Stream: "s", Values: []interface{}{tt.value}}
+			b, err := c.Encode(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out utils.TupleMessage
+			if err := Decode(b, &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.Id != in.Id || out.Root != in.Root || out.Stream != in.Stream {
+				t.Fatalf("header %+v, expected %+v", out, in)
+			}
+			got := out.Values[0]
+			if want, ok := tt.value.(time.Time); ok {
+				// The offset is kept, the location name is not
+				gt, ok := got.(time.Time)
+				_, wantOffset := want.Zone()
+				_, gotOffset := gt.Zone()
+				if !ok || !gt.Equal(want) || gotOffset != wantOffset {
+					t.Fatalf("got %T %v, expected %v", got, got, want)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.value) {
+				t.Fatalf("got %T %v, expected %T %v", got, got, tt.value, tt.value)
+			}
+		})
+	}
+}
+
+// The times nested in the lists and maps keep their instants
+func TestBinaryNestedTime(t *testing.T) {
+	at := time.Unix(1700000000, 5).In(time.FixedZone("", -7*3600))
+	b, err := BinaryCodec{}.Encode(utils.TupleMessage{Values: []interface{}{map[string]interface{}{"t": []interface{}{at}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out utils.TupleMessage
+	if err := Decode(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	got := out.Values[0].(map[string]interface{})["t"].([]interface{})[0].(time.Time)
+	if !got.Equal(at) {
+		t.Fatalf("got %v, expected %v", got, at)
+	}
+}
+
+func TestBinaryTruncated(t *testing.T) {
+	b, err := BinaryCodec{}.Encode(utils.TupleMessage{Stream: "s", Values: []interface{}{int64(1), "two", []byte{3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(b); i++ {
+		var out utils.TupleMessage
+		if err := Decode(b[:i], &out); err == nil {
+			t.Fatalf("decoded the tuple truncated to %d of %d bytes", i, len(b))
+		}
+	}
+}
+
+func TestJsonDefault(t *testing.T) {
+	b, err := JsonCodec{}.Encode(utils.TupleMessage{Values: []interface{}{int64(3)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out utils.TupleMessage
+	if err := Decode(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Values[0] != float64(3) {
+		t.Fatalf("got %T %v, JSON numbers arrive as float64", out.Values[0], out.Values[0])
+	}
+}
+
+func TestParseHello(t *testing.T) {
+	hello := func(name string, codecs ...string) []byte {
+		b, _ := json.Marshal(utils.SubscribeHello{Name: name, Codecs: codecs})
+		return b
+	}
+	legacy, _ := json.Marshal("Bolt_1")
+	tests := []struct {
+		name    string
+		payload []byte
+		task    string
+		codec   string
+	}{
+		{"legacy name", legacy, "Bolt_1", utils.CODEC_JSON},
+		{"binary", hello("Bolt_2", utils.CODEC_BINARY, utils.CODEC_JSON), "Bolt_2", utils.CODEC_BINARY},
+		{"json", hello("Bolt_3", utils.CODEC_JSON), "Bolt_3", utils.CODEC_JSON},
+		{"unknown first", hello("Bolt_4", "msgpack", utils.CODEC_BINARY), "Bolt_4", utils.CODEC_BINARY},
+		{"unknown only", hello("Bolt_5", "msgpack"), "Bolt_5", utils.CODEC_JSON},
+		{"no codecs", hello("Bolt_6"), "Bolt_6", utils.CODEC_JSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task, c, err := ParseHello(tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if task != tt.task || c.Name() != tt.codec {
+				t.Fatalf("got %s with %s, expected %s with %s", task, c.Name(), tt.task, tt.codec)
+			}
+		})
+	}
+	if _, _, err := ParseHello([]byte("not json")); err == nil {
+		t.Fatal("parsed an invalid hello")
+	}
+}
@@ -0,0 +1,98 @@
+package driver
+
+import (
+	"crane/core/utils"
+	"log"
+	"time"
+)
+
+// Record the heartbeat of the supervisor, the capacity it reports
+// replaces the one it joined with
+func (d *Driver) RecordHeartbeat(supervisor string, hb *utils.Heartbeat) {
+	d.LockPort.Lock()
+	if _, ok := d.Capacity[supervisor]; !ok {
+		// The supervisor is removed already
+		d.LockPort.Unlock()
+		return
+	}
+	d.Heartbeats[supervisor] = *hb
+	d.LastHeartbeat[supervisor] = time.Now()
+	d.setCapacity(supervisor, &utils.JoinRequest{Capacity: hb.Capacity, Slots: hb.Slots})
+	d.LockPort.Unlock()
+	for _, w := range hb.Workers {
+		if w.State == utils.WORKER_DEAD {
+			log.Printf("Worker %s of Topology %s on %s Is Dead\n", w.Task, w.Topology, supervisor)
+		}
+	}
+}
+
+// Take the supervisors without heartbeats in the timeout as failed, their
+// connections may stay open when the machine or the network fails
+func (d *Driver) DetectFailures() {
+	for {
+		select {
+		case <-d.closed:
+			return
+		case <-time.After(utils.HEARTBEAT_INTERVAL * time.Second):
+		}
+		failed := make([]string, 0)
+		d.LockPort.Lock()
+		for supervisor, last := range d.LastHeartbeat {
+			if time.Since(last) > utils.HEARTBEAT_TIMEOUT*time.Second {
+				failed = append(failed, supervisor)
+			}
+		}
+		d.LockPort.Unlock()
+
+		for _, supervisor := range failed {
+			log.Printf("No Heartbeat From Supervisor %s in %d Seconds, Take It As Failed\n", supervisor, utils.HEARTBEAT_TIMEOUT)
+			d.RemoveSupervisor(supervisor)
+			// The supervisor rejoins with a new connection if it is alive
+			if conn := d.Pub.Pool.Get(supervisor); conn != nil {
+				conn.Close()
+			}
+		}
+	}
+}
+
+// Remove the failed supervisor and restore the topologies with tasks on
+// it, nothing is done if the connection is not of a supervisor
+func (d *Driver) RemoveSupervisor(connId string) {
+	d.LockSIM.Lock()
+	removed := false
+	for index, supervisor := range d.SupervisorIdMap {
+		if supervisor == connId {
+			d.SupervisorIdMap = append(d.SupervisorIdMap[:index], d.SupervisorIdMap[index+1:]...)
+			delete(d.SupervisorNames, connId)
+			removed = true
+			break
+		}
+	}
+	d.LockSIM.Unlock()
+	if !removed {
+		return
+	}
+
+	d.LockPort.Lock()
+	delete(d.PortMap, connId)
+	delete(d.PortResources, connId)
+	delete(d.Capacity, connId)
+	delete(d.Slots, connId)
+	delete(d.Hosts, connId)
+	delete(d.PortRanges, connId)
+	delete(d.Heartbeats, connId)
+	delete(d.LastHeartbeat, connId)
+	d.LockPort.Unlock()
+
+	// only the topologies with tasks on the supervisor are restored
+	d.LockTopo.RLock()
+	for _, ts := range d.Topologies {
+		for _, host := range ts.hosts() {
+			if host == connId {
+				go d.RestoreRequest(ts, connId)
+				break
+			}
+		}
+	}
+	d.LockTopo.RUnlock()
+}
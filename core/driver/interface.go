@@ -1,4 +1,4 @@
-package main
+package driver
 
 import (
 	"crane/bolt"
@@ -15,36 +15,64 @@ import (
 	"time"
 )
 
+// File of the cluster state persisted by the leader driver, not named
+// like the SDFS files cleaned by the supervisors
+const DRIVER_STATE_FILE = "driver.json"
+
 // Driver, the master node daemon server for scheduling and
 // dispaching the spouts or bolts task
 type Driver struct {
-	Pub                   *messages.Publisher
-	Topo                  *topology.Topology
-	SupervisorIdMap       []string
-	LockSIM               sync.RWMutex
-	TopologyGraph         map[string][]interface{}
-	SpoutMap              map[string]spout.SpoutInst
-	BoltMap               map[string]bolt.BoltInst
-	VmIndexMap            map[int]string
-	CtlTimer              []*time.Timer
-	SuspendResponseCount  int
-	SnapshotResponseCount int
-	TaskSum               int
-	SnapshotVersion       int
-	SnapshotInterval      int
+	Pub             *messages.Publisher
+	Topologies      map[string]*TopologyState
+	LockTopo        sync.RWMutex
+	TopologySeq     int
+	PortMap         map[string]map[int]string
+	PortResources   map[string]map[int]utils.Resources
+	Capacity        map[string]utils.Resources
+	Slots           map[string]int
+	Heartbeats      map[string]utils.Heartbeat
+	LastHeartbeat   map[string]time.Time
+	LockPort        sync.Mutex
+	SupervisorIdMap []string
+	SupervisorNames map[string]string
+	LockSIM         sync.RWMutex
+	PendingLogs     map[uint64]pendingRequest
+	RequestSeq      uint64
+	// Host and first and last ports the workers of every supervisor
+	// listen on, by the supervisor
+	Hosts      map[string]string
+	PortRanges map[string][2]int
+	// Store persisting the cluster state for the standby drivers,
+	// and the term of this driver as the leader
+	Store     StateStore
+	Term      int
+	LockStore sync.Mutex
+	closed    chan struct{}
+}
+
+// Client request forwarded to a supervisor, waiting for its response
+type pendingRequest struct {
+	connId    string
+	requestId uint64
 }
 
 // Factory mode to return the Driver instance
 func NewDriver(addr string) *Driver {
 	driver := &Driver{}
 	driver.Pub = messages.NewPublisher(addr)
+	driver.Topologies = make(map[string]*TopologyState)
+	driver.PortMap = make(map[string]map[int]string)
+	driver.PortResources = make(map[string]map[int]utils.Resources)
+	driver.Capacity = make(map[string]utils.Resources)
+	driver.Slots = make(map[string]int)
+	driver.Heartbeats = make(map[string]utils.Heartbeat)
+	driver.LastHeartbeat = make(map[string]time.Time)
 	driver.SupervisorIdMap = make([]string, 0)
-	driver.VmIndexMap = make(map[int]string)
-	driver.CtlTimer = make([]*time.Timer, 0)
-	driver.SuspendResponseCount = 0
-	driver.SnapshotResponseCount = 0
-	driver.SnapshotVersion = 0
-	driver.SnapshotInterval = 30
+	driver.SupervisorNames = make(map[string]string)
+	driver.PendingLogs = make(map[uint64]pendingRequest)
+	driver.Hosts = make(map[string]string)
+	driver.PortRanges = make(map[string][2]int)
+	driver.closed = make(chan struct{})
 	return driver
 }
 
@@ -52,13 +80,25 @@ func NewDriver(addr string) *Driver {
 func (d *Driver) StartDaemon() {
 	go d.Pub.AcceptConns()
 	go d.Pub.PublishMessage(d.Pub.PublishBoard)
+	go d.DetectFailures()
 	for {
+		select {
+		case <-d.closed:
+			return
+		default:
+		}
+		// Yield the CPU when no connection has a message
+		idle := true
 		for connId, channel := range d.Pub.Channels {
 			d.Pub.RWLock.RLock()
 			select {
 			case supervisorMsg := <-channel:
+				idle = false
 				payload := utils.CheckType(supervisorMsg.Payload)
-				log.Printf("Receiving %s request form %s\n", payload.Header.Type, connId)
+				// heartbeats and pings are too frequent to log
+				if payload.Header.Type != utils.HEARTBEAT && payload.Header.Type != utils.LEADER_PING {
+					log.Printf("Receiving %s request form %s\n", payload.Header.Type, connId)
+				}
 				// parse the header information
 				switch payload.Header.Type {
 				// if it is the join request from supervisor
@@ -67,107 +107,205 @@ func (d *Driver) StartDaemon() {
 					utils.Unmarshal(payload.Content, content)
 					d.LockSIM.Lock()
 					d.SupervisorIdMap = append(d.SupervisorIdMap, connId)
+					d.SupervisorNames[connId] = content.Name
 					d.LockSIM.Unlock()
-					log.Println("Supervisor ID Name", content.Name)
+					d.SetCapacity(connId, content)
+					log.Println("Supervisor ID Name", content.Name, "Capacity", content.Capacity, "Slots", content.Slots)
+				// the supervisor is alive, with its resources and workers
+				case utils.HEARTBEAT:
+					hb := &utils.Heartbeat{}
+					utils.Unmarshal(payload.Content, hb)
+					d.RecordHeartbeat(connId, hb)
+				// a standby driver checks this driver is still the leader
+				case utils.LEADER_PING:
+					d.RespondPing(connId)
 				// if it is the connection notification about the connection pools
 				case utils.CONN_NOTIFY:
 					content := &messages.ConnNotify{}
 					utils.Unmarshal(payload.Content, content)
 					if content.Type == messages.CONN_DELETE {
-						for _, timer := range d.CtlTimer {
-							log.Println("Clean previous timer")
-							timer.Stop()
-						}
-						d.CtlTimer = make([]*time.Timer, 0)
-
-						d.LockSIM.RLock()
-						for index, connId_ := range d.SupervisorIdMap {
-							if connId_ == connId {
-								d.SupervisorIdMap = append(d.SupervisorIdMap[:index], d.SupervisorIdMap[index+1:]...)
-								delete(d.Pub.Channels, connId)
-								go d.RestoreRequest()
-							}
-						}
-						d.LockSIM.RUnlock()
+						// the connection of a client
+						delete(d.Pub.Channels, connId)
+
+						d.RemoveSupervisor(connId)
 					}
 				// if it is the topology submitted from the client, which is
 				// the application written by the developer
 				case utils.TOPO_SUBMISSION:
-					d.Pub.PublishBoard <- messages.Message{
-						Payload:      []byte("OK"),
-						TargetConnId: connId,
-					}
 					topo := &topology.Topology{}
 					utils.Unmarshal(payload.Content, topo)
-					d.Topo = topo
-					d.BuildTopology(topo)
-				// Spout instance responses
-				case utils.SUSPEND_RESPONSE:
-					d.SuspendResponseCount++
-
-					if d.SuspendResponseCount == len(d.SpoutMap) {
-						d.Snapshot()
-						d.SuspendResponseCount = 0
+					// Reject the invalid topology before scheduling it
+					errs := append(topo.Validate(), d.CheckResources(topo)...)
+					if len(errs) > 0 {
+						d.RespondSubmission(connId, payload.Header.RequestId, "", errs)
+						break
+					}
+					ts := d.AddTopology(topo)
+					d.PersistAsync()
+					d.RespondSubmission(connId, payload.Header.RequestId, ts.Id, errs)
+					log.Printf("Topology %s Submitted\n", ts.Id)
+					go d.BuildTopology(ts)
+					go d.CheckpointRequest(ts)
+				// lifecycle commands of a running topology from the client
+				case utils.TOPO_KILL, utils.TOPO_DEACTIVATE, utils.TOPO_ACTIVATE, utils.TOPO_REBALANCE, utils.TOPO_CHECKPOINT:
+					cmd := &utils.TopologyCommand{}
+					utils.Unmarshal(payload.Content, cmd)
+					err := d.HandleCommand(payload.Header.Type, cmd)
+					if err != nil {
+						log.Println(err)
+					} else {
+						d.PersistAsync()
+					}
+					d.RespondCommand(connId, payload.Header.RequestId, err)
+				// queries about the cluster from the client
+				case utils.TOPO_LIST, utils.TOPO_DESCRIBE, utils.SUPERVISOR_LIST:
+					query := &utils.TopologyQuery{}
+					utils.Unmarshal(payload.Content, query)
+					result, err := d.HandleQuery(payload.Header.Type, query)
+					d.RespondQuery(connId, payload.Header.RequestId, result, err)
+				// the logs of a task are kept by the supervisor running it
+				case utils.TASK_LOGS:
+					query := &utils.TopologyQuery{}
+					utils.Unmarshal(payload.Content, query)
+					if err := d.RequestLogs(connId, payload.Header.RequestId, query); err != nil {
+						d.RespondQuery(connId, payload.Header.RequestId, nil, err)
+					}
+				// the crashed worker is restarted by its supervisor
+				case utils.WORKER_FAILURE, utils.WORKER_RESTART:
+					failure := &utils.WorkerFailure{}
+					utils.Unmarshal(payload.Content, failure)
+					d.HandleWorkerFailure(payload.Header.Type, failure)
+				case utils.LOGS_RESPONSE:
+					var lines []string
+					utils.Unmarshal(payload.Content, &lines)
+					d.LockSIM.Lock()
+					pending, ok := d.PendingLogs[payload.Header.RequestId]
+					delete(d.PendingLogs, payload.Header.RequestId)
+					d.LockSIM.Unlock()
+					if !ok {
+						break
 					}
+					d.RespondQuery(pending.connId, pending.requestId, lines, nil)
 				// Snapshot completion responses from all supervisors
 				case utils.SNAPSHOT_RESPONSE:
-					d.SnapshotResponseCount++
-
-					if d.SnapshotResponseCount == len(d.SupervisorIdMap) {
-						// Confirm a correct version snapshot has completed
-						d.SnapshotVersion++
-						d.SnapshotResponseCount = 0
+					msg := &utils.SnapshotMessage{}
+					utils.Unmarshal(payload.Content, msg)
+					ts := d.GetTopology(msg.Topology)
+					if ts != nil && d.CompleteSnapshot(ts, msg.Version) {
+						d.Commit(ts, msg.Version)
+						d.PersistAsync()
 					}
 				}
 			default:
 			}
 			d.Pub.RWLock.RUnlock()
 		}
+		if idle {
+			time.Sleep(time.Millisecond)
+		}
 	}
 }
 
+// Stop the driver daemon and close the connections, the topologies
+// are forgotten but not killed on the supervisors
+func (d *Driver) Stop() {
+	d.LockTopo.Lock()
+	d.Topologies = make(map[string]*TopologyState)
+	d.LockTopo.Unlock()
+	close(d.closed)
+	d.Pub.Close()
+	d.Pub.Pool.Range(func(id string, conn net.Conn) {
+		conn.Close()
+	})
+}
+
 // Build the graph topology using vector-edge map
-func (d *Driver) BuildTopology(topo *topology.Topology) {
+func (d *Driver) BuildTopology(ts *TopologyState) {
+	ts.setBuilding(true)
+	defer ts.setBuilding(false)
+	topo := ts.Topo
 	// make the map for a task name (spout or bolt) to the task instance
-	d.TopologyGraph = make(map[string][]interface{})
-	d.SpoutMap = make(map[string]spout.SpoutInst)
-	d.BoltMap = make(map[string]bolt.BoltInst)
-	if len(d.SupervisorIdMap) == 0 {
+	ts.TopologyGraph = make(map[string][]interface{})
+	ts.SpoutMap = make(map[string]spout.SpoutInst)
+	ts.BoltMap = make(map[string]bolt.BoltInst)
+	// the previous workers of the topology are killed
+	d.ReleasePorts(ts.Id)
+	if len(d.supervisors()) == 0 {
+		log.Println("No supervisor to build the topology")
 		return
 	}
 
-	// build the vectors table
+	// build the vectors table, and the streams subscribed by the
+	// successors of each task, a task may subscribe several streams
+	// of the same previous task
+	succStreams := make(map[string]map[string][]string)
+	// the indexes of the fields each successor groups the streams by
+	succIndexes := make(map[string]map[string]map[string][]int)
 	for i, _ := range topo.Bolts {
 		preVecs := topo.Bolts[i].PrevTaskNames
-		for _, vec := range preVecs {
-			if d.TopologyGraph[vec] == nil {
-				d.TopologyGraph[vec] = make([]interface{}, 0)
+		for j, vec := range preVecs {
+			if succStreams[vec] == nil {
+				succStreams[vec] = make(map[string][]string)
+			}
+			if succStreams[vec][topo.Bolts[i].Name] == nil {
+				if ts.TopologyGraph[vec] == nil {
+					ts.TopologyGraph[vec] = make([]interface{}, 0)
+				}
+				ts.TopologyGraph[vec] = append(ts.TopologyGraph[vec], &topo.Bolts[i])
+			}
+			stream := topo.Bolts[i].PrevStream(j)
+			succStreams[vec][topo.Bolts[i].Name] = append(succStreams[vec][topo.Bolts[i].Name], stream)
+
+			fields, ok := topo.Bolts[i].GroupingFields[vec]
+			if !ok {
+				continue
+			}
+			indexes, err := topo.FieldIndexes(vec, stream, fields)
+			if err != nil {
+				log.Println(err)
+				continue
 			}
-			d.TopologyGraph[vec] = append(d.TopologyGraph[vec], &topo.Bolts[i])
+			if succIndexes[vec] == nil {
+				succIndexes[vec] = make(map[string]map[string][]int)
+			}
+			if succIndexes[vec][topo.Bolts[i].Name] == nil {
+				succIndexes[vec][topo.Bolts[i].Name] = make(map[string][]int)
+			}
+			succIndexes[vec][topo.Bolts[i].Name][stream] = indexes
 		}
 		topo.Bolts[i].TaskAddrs = make([]string, 0)
 	}
 
 	for i, _ := range topo.Spouts {
 		preVec := "None"
-		if d.TopologyGraph[preVec] == nil {
-			d.TopologyGraph[preVec] = make([]interface{}, 0)
+		if ts.TopologyGraph[preVec] == nil {
+			ts.TopologyGraph[preVec] = make([]interface{}, 0)
 		}
-		d.TopologyGraph[preVec] = append(d.TopologyGraph[preVec], &topo.Spouts[i])
+		ts.TopologyGraph[preVec] = append(ts.TopologyGraph[preVec], &topo.Spouts[i])
 		topo.Spouts[i].TaskAddrs = make([]string, 0)
 	}
+	ts.SuccStreams = succStreams
+	ts.SuccIndexes = succIndexes
 
-	visited := make(map[string]bool)
-	count := 0
-	addrs := make(map[int][]interface{})
-	d.GenTopologyMessages("None", &visited, &count, &addrs)
-	d.PrintTopology("None", 0)
-	// Stage 1 : Send pull request to supervisor to pull the file needed
-	// including the plugin file and state files for restoring
-	if d.SnapshotVersion == 0 {
-		for id, _ := range addrs {
-			targetId := d.SupervisorIdMap[uint32(id)]
-			msg := utils.FilePull{topo.Bolts[0].PluginFile}
+	instances, assignment, offers, err := d.Schedule(topo, ts.Id)
+	if err != nil {
+		log.Printf("Schedule Topology %s Failed: %v\n", ts.Id, err)
+		return
+	}
+	addrs, err := d.PlaceTasks(ts, instances, assignment, offers)
+	if err != nil {
+		log.Printf("Place Topology %s Failed: %v\n", ts.Id, err)
+		d.ReleasePorts(ts.Id)
+		return
+	}
+	count := len(instances)
+	d.PrintTopology(ts, "None", 0)
+	// Stage 1 : Send pull request to supervisor to pull the plugin files needed,
+	// the states for restoring are loaded by workers from the state backend
+	for _, tasks := range addrs {
+		targetId := tasks.supervisor
+		for _, file := range pluginFiles(tasks.tasks) {
+			msg := utils.FilePull{Filename: file}
 			b, _ := utils.Marshal(utils.FILE_PULL, msg)
 			d.Pub.PublishBoard <- messages.Message{
 				Payload:      b,
@@ -176,18 +314,40 @@ func (d *Driver) BuildTopology(topo *topology.Topology) {
 		}
 	}
 
-	d.TaskSum = count
-
 	// To store the keys in slice in sorted order
 	var keys []int
 	for k := range addrs {
 		keys = append(keys, k)
 	}
 	sort.Ints(keys)
-	log.Println("Supervisors", addrs, keys)
+	log.Println("Supervisors", keys)
+
+	hosts := make([]string, 0)
+	for _, id := range keys {
+		hosts = append(hosts, addrs[id].supervisor)
+	}
 
 	countMap := make(map[string]int)
 
+	// Place the acker on the first supervisor,
+	// before the workers start to connect it
+	ackerAddr, ackerHost := "", ""
+	if topo.AckTimeout > 0 {
+		ackerHost = hosts[0]
+		ackerAddr, err = d.StartAcker(ts, ackerHost)
+		if err != nil {
+			log.Printf("Start Acker of Topology %s Failed: %v\n", ts.Id, err)
+			d.ReleasePorts(ts.Id)
+			return
+		}
+	}
+	ts.LockState.Lock()
+	ts.TaskSum = count
+	ts.TaskHostSum = len(addrs)
+	ts.Hosts = hosts
+	ts.AckerAddr, ts.AckerHost = ackerAddr, ackerHost
+	ts.LockState.Unlock()
+
 	//spoutsSuccBoltsConnIdMap := make(map[string]map[string]map[string]int)
 	// generate bolts connection ID to count num mapping
 	/*for _, k := range keys {*/
@@ -216,287 +376,568 @@ func (d *Driver) BuildTopology(topo *topology.Topology) {
 	//}
 	/*}*/
 
-	if d.SnapshotVersion > 0 {
-		for _, k := range keys {
-			tasks := addrs[k]
-			targetId := d.SupervisorIdMap[uint32(k)]
-			for _, task := range tasks {
-				time.Sleep(400 * time.Millisecond)
-				spout, ok := task.(*spout.SpoutInst)
-				if ok {
-					if countMap[spout.Name] == 0 {
-						countMap[spout.Name] = 1
-					}
-					stateFileName := spout.Name + "_" + fmt.Sprintf("%d_%d", countMap[spout.Name], d.SnapshotVersion-1)
-					msg := utils.FilePull{stateFileName}
-					b, _ := utils.Marshal(utils.FILE_PULL, msg)
-					d.Pub.PublishBoard <- messages.Message{
-						Payload:      b,
-						TargetConnId: targetId,
-					}
-					countMap[spout.Name]++
-				} else {
-					bolt, _ := task.(*bolt.BoltInst)
-					if countMap[bolt.Name] == 0 {
-						countMap[bolt.Name] = 1
-					}
-
-					stateFileName := bolt.Name + "_" + fmt.Sprintf("%d_%d", countMap[bolt.Name], d.SnapshotVersion-1)
-					msg := utils.FilePull{stateFileName}
-					b, _ := utils.Marshal(utils.FILE_PULL, msg)
-					d.Pub.PublishBoard <- messages.Message{
-						Payload:      b,
-						TargetConnId: targetId,
-					}
-					countMap[bolt.Name]++
-				}
-			}
-		}
-	}
-
 	time.Sleep(5 * time.Second)
 	// Stage 2 : Send the task message information to supervisors
-	countMap = make(map[string]int)
+	placements := make([]utils.TaskPlacement, 0)
 	for _, id := range keys {
 		tasks := addrs[id]
-		targetId := d.SupervisorIdMap[uint32(id)]
-		for offset, task := range tasks {
+		targetId := tasks.supervisor
+		for offset, task := range tasks.tasks {
 			time.Sleep(20 * time.Millisecond)
-			spout, ok := task.(*spout.SpoutInst)
-			if ok {
-				if countMap[spout.Name] == 0 {
-					countMap[spout.Name] = 1
-				}
-				msg := utils.SpoutTaskMessage{
-					Name:            spout.Name + "_" + fmt.Sprintf("%d", countMap[spout.Name]),
-					GroupingHint:    spout.GroupingHint,
-					FieldIndex:      spout.FieldIndex,
-					PluginFile:      spout.PluginFile,
-					PluginSymbol:    spout.PluginSymbol,
-					Port:            fmt.Sprintf("%d", utils.CONTRACTOR_BASE_PORT+offset),
-					SnapshotVersion: d.SnapshotVersion - 1,
-				}
-				fmt.Println(msg)
-				b, _ := utils.Marshal(utils.SPOUT_TASK, msg)
-				d.Pub.PublishBoard <- messages.Message{
-					Payload:      b,
-					TargetConnId: targetId,
-				}
-				countMap[spout.Name]++
-			} else {
-				bolt, ok := task.(*bolt.BoltInst)
-				if countMap[bolt.Name] == 0 {
-					countMap[bolt.Name] = 1
-				}
-				msg := utils.BoltTaskMessage{
-					Name:                 bolt.Name + "_" + fmt.Sprintf("%d", countMap[bolt.Name]),
-					SuccBoltGroupingHint: bolt.GroupingHint,
-					SuccBoltFieldIndex:   bolt.FieldIndex,
-					PluginFile:           bolt.PluginFile,
-					PluginSymbol:         bolt.PluginSymbol,
-					Port:                 fmt.Sprintf("%d", utils.CONTRACTOR_BASE_PORT+offset),
-					SnapshotVersion:      d.SnapshotVersion - 1,
-				}
-
-				_, ok = d.SpoutMap[bolt.PrevTaskNames[0]]
-				if ok {
-					prev := d.SpoutMap[bolt.PrevTaskNames[0]]
-					msg.PrevBoltGroupingHint = prev.GroupingHint
-					msg.PrevBoltFieldIndex = prev.FieldIndex
-				} else {
-					prev := d.BoltMap[bolt.PrevTaskNames[0]]
-					msg.PrevBoltGroupingHint = prev.GroupingHint
-					msg.PrevBoltFieldIndex = prev.FieldIndex
-				}
-
-				addr := make([]string, 0)
-				for _, name := range bolt.PrevTaskNames {
-					_, ok := d.SpoutMap[name]
-					if ok {
-						prev := d.SpoutMap[name]
-						addr = append(addr, prev.TaskAddrs...)
-					} else {
-						prev := d.BoltMap[name]
-						addr = append(addr, prev.TaskAddrs...)
-					}
-				}
-				msg.PrevBoltAddr = addr
-				fmt.Println(msg)
-
-				b, _ := utils.Marshal(utils.BOLT_TASK, msg)
-				d.Pub.PublishBoard <- messages.Message{
-					Payload:      b,
-					TargetConnId: targetId,
-				}
-				countMap[bolt.Name]++
+			component := componentName(task)
+			if countMap[component] == 0 {
+				countMap[component] = 1
+			}
+			name := component + "_" + fmt.Sprintf("%d", countMap[component])
+			msgType, msg := d.taskMessage(ts, task, name, tasks.ports[offset])
+			fmt.Println(msg)
+			placements = append(placements, d.placement(component, name, targetId, fmt.Sprintf("%d", tasks.ports[offset])))
+			b, _ := utils.Marshal(msgType, msg)
+			d.Pub.PublishBoard <- messages.Message{
+				Payload:      b,
+				TargetConnId: targetId,
 			}
+			countMap[component]++
 		}
 	}
 
+	ts.LockState.Lock()
+	ts.Placements = placements
+	ts.LockState.Unlock()
+
 	time.Sleep(5 * time.Second)
 
 	// Stage 3 : Send dispatch signal
-	for id, _ := range addrs {
-		d.LockSIM.RLock()
-		targetId := d.SupervisorIdMap[uint32(id)]
-		d.LockSIM.RUnlock()
-		b, _ := utils.Marshal(utils.TASK_ALL_DISPATCHED, "ok")
+	for _, tasks := range addrs {
+		targetId := tasks.supervisor
+		b, _ := utils.Marshal(utils.TASK_ALL_DISPATCHED, ts.Id)
 		d.Pub.PublishBoard <- messages.Message{
 			Payload:      b,
 			TargetConnId: targetId,
 		}
 	}
 
-	// Stage 4 : Start snapshot process
-	go d.SuspendRequest()
+	// The spouts of the deactivated topology stay suspended
+	if !ts.active() {
+		d.SendToHosts(ts, utils.SUSPEND_REQUEST, ts.Id)
+	}
 }
 
-// Failover for a supervisor down and start restore process
-func (d *Driver) RestoreRequest() {
-	// no topology submitted
-	if d.Topo == nil {
-		return
+// Message of the spout or bolt task to its supervisor, the task restores
+// from the last completed snapshot and a bolt subscribes to all the tasks
+// of its previous components
+func (d *Driver) taskMessage(ts *TopologyState, task interface{}, name string, port int) (string, interface{}) {
+	topo := ts.Topo
+	ts.LockState.RLock()
+	version, ackerAddr := ts.CompletedVersion, ts.AckerAddr
+	stateInstNum := ts.StateInstNum[componentName(task)]
+	ts.LockState.RUnlock()
+	switch c := task.(type) {
+	case *spout.SpoutInst:
+		return utils.SPOUT_TASK, utils.SpoutTaskMessage{
+			Topology:         ts.Id,
+			Name:             name,
+			GroupingHint:     c.GroupingHint,
+			FieldIndex:       c.FieldIndex,
+			PluginFile:       c.PluginFile,
+			PluginSymbol:     c.PluginSymbol,
+			Port:             fmt.Sprintf("%d", port),
+			SnapshotVersion:  version,
+			StateBackend:     topo.StateBackend,
+			AckerAddr:        ackerAddr,
+			AckTimeout:       topo.AckTimeout,
+			SuccStreams:      ts.SuccStreams[c.Name],
+			SuccFieldIndexes: ts.SuccIndexes[c.Name],
+			Batch:            c.Batch,
+		}
+	case *bolt.BoltInst:
+		msg := utils.BoltTaskMessage{
+			Topology:             ts.Id,
+			Name:                 name,
+			SuccBoltGroupingHint: c.GroupingHint,
+			SuccBoltFieldIndex:   c.FieldIndex,
+			PluginFile:           c.PluginFile,
+			PluginSymbol:         c.PluginSymbol,
+			Port:                 fmt.Sprintf("%d", port),
+			SnapshotVersion:      version,
+			StateBackend:         topo.StateBackend,
+			AckerAddr:            ackerAddr,
+			SuccStreams:          ts.SuccStreams[c.Name],
+			SuccFieldIndexes:     ts.SuccIndexes[c.Name],
+			InstNum:              c.InstNum,
+			StateInstNum:         stateInstNum,
+			Codec:                c.Codec,
+			Batch:                c.Batch,
+		}
+
+		_, ok := ts.SpoutMap[c.PrevTaskNames[0]]
+		if ok {
+			prev := ts.SpoutMap[c.PrevTaskNames[0]]
+			msg.PrevBoltGroupingHint = prev.GroupingHint
+			msg.PrevBoltFieldIndex = prev.FieldIndex
+		} else {
+			prev := ts.BoltMap[c.PrevTaskNames[0]]
+			msg.PrevBoltGroupingHint = prev.GroupingHint
+			msg.PrevBoltFieldIndex = prev.FieldIndex
+		}
+
+		addr := make([]string, 0)
+		subscribed := make(map[string]bool)
+		for _, name := range c.PrevTaskNames {
+			if subscribed[name] {
+				continue
+			}
+			subscribed[name] = true
+			_, ok := ts.SpoutMap[name]
+			if ok {
+				prev := ts.SpoutMap[name]
+				addr = append(addr, prev.TaskAddrs...)
+			} else {
+				prev := ts.BoltMap[name]
+				addr = append(addr, prev.TaskAddrs...)
+			}
+		}
+		msg.PrevBoltAddr = addr
+		return utils.BOLT_TASK, msg
+	}
+	return "", nil
+}
+
+// Check the tasks of the topology fit into the free slots and the
+// resources of the supervisors
+func (d *Driver) CheckResources(topo *topology.Topology) []error {
+	d.LockSIM.RLock()
+	supervisors := append([]string{}, d.SupervisorIdMap...)
+	d.LockSIM.RUnlock()
+	if len(supervisors) == 0 {
+		return []error{fmt.Errorf("no supervisor joined the cluster")}
+	}
+	tasks := topo.TaskNum()
+	if topo.AckTimeout > 0 {
+		tasks++
+	}
+	slots := 0
+	for _, supervisor := range supervisors {
+		slots += d.FreeSlots(supervisor)
+	}
+	if tasks > slots {
+		return []error{fmt.Errorf("%d tasks exceed the %d free slots of %d supervisors",
+			tasks, slots, len(supervisors))}
+	}
+	// The scheduler may not fit the tasks into the supervisors' resources
+	if _, _, _, err := d.Schedule(topo, ""); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// Placement of the task on the supervisor
+func (d *Driver) placement(component string, task string, supervisor string, port string) utils.TaskPlacement {
+	host := d.Host(supervisor)
+	return utils.TaskPlacement{
+		Task:       task,
+		Component:  component,
+		Supervisor: supervisor,
+		Addr:       host + ":" + port,
+	}
+}
+
+// Reply the topology submission with its id, or the errors rejecting it
+func (d *Driver) RespondSubmission(connId string, requestId uint64, id string, errs []error) {
+	res := utils.TopoSubmissionResponse{
+		Id:       id,
+		Accepted: len(errs) == 0,
+		Errors:   make([]string, 0),
+	}
+	for _, err := range errs {
+		log.Println("Invalid topology:", err)
+		res.Errors = append(res.Errors, err.Error())
 	}
+	b, _ := utils.MarshalRequest(utils.TOPO_SUBMISSION_RES, requestId, res)
+	d.Pub.PublishBoard <- messages.Message{
+		Payload:      b,
+		TargetConnId: connId,
+	}
+}
+
+// Name of the spout or bolt component of the task
+func componentName(task interface{}) string {
+	switch t := task.(type) {
+	case *spout.SpoutInst:
+		return t.Name
+	case *bolt.BoltInst:
+		return t.Name
+	}
+	return ""
+}
+
+// Distinct plugin files of the tasks
+func pluginFiles(tasks []interface{}) []string {
+	files := make([]string, 0)
+	pulled := make(map[string]bool)
+	for _, task := range tasks {
+		var file string
+		switch t := task.(type) {
+		case *spout.SpoutInst:
+			file = t.PluginFile
+		case *bolt.BoltInst:
+			file = t.PluginFile
+		}
+		if !pulled[file] {
+			pulled[file] = true
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// Dispatch the acker task of the topology to the supervisor
+// and return the acker address
+func (d *Driver) StartAcker(ts *TopologyState, targetId string) (string, error) {
+	host := d.Host(targetId)
+	allocated, err := d.AllocatePort(targetId, ts.Id, utils.TaskResources(0, 0))
+	if err != nil {
+		return "", err
+	}
+	port := fmt.Sprintf("%d", allocated)
+	msg := utils.AckerTaskMessage{
+		Topology: ts.Id,
+		Name:     "Acker_1",
+		Port:     port,
+	}
+	b, _ := utils.Marshal(utils.ACKER_TASK, msg)
+	d.Pub.PublishBoard <- messages.Message{
+		Payload:      b,
+		TargetConnId: targetId,
+	}
+	return host + ":" + port, nil
+}
 
-	d.SnapshotInterval += 20
+// Failover for a supervisor down and start restore process of the topology,
+// the timer restarts on every failure so that the supervisors failing
+// together are recovered at once
+func (d *Driver) RestoreRequest(ts *TopologyState, failed string) {
+	ts.LockState.Lock()
+	ts.SnapshotInterval += 20
+	ts.LockState.Unlock()
+
+	ts.LockRecover.Lock()
+	defer ts.LockRecover.Unlock()
+	ts.FailedHosts = append(ts.FailedHosts, failed)
+	if ts.RestoreTimer != nil {
+		log.Println("Clean previous timer")
+		ts.RestoreTimer.Stop()
+	}
+	ts.RestoreTimer = time.AfterFunc(2*time.Second, func() {
+		log.Printf("Timeout, recover topology %s\n", ts.Id)
+		d.Recover(ts)
+	})
+}
 
-	// reset the counter of two snapshot state process responses
-	d.SuspendResponseCount = 0
-	d.SnapshotResponseCount = 0
+// Shutdown the current workers of the topology on all supervisors and
+// build it again, the workers restore from the last completed snapshot
+func (d *Driver) Rebuild(ts *TopologyState) {
+	// the topology is killed meanwhile
+	if d.GetTopology(ts.Id) == nil {
+		return
+	}
+	// reset the snapshot in flight, its barriers are lost with the workers
+	ts.LockState.Lock()
+	ts.SnapshotResponseCount = 0
+	ts.SnapshotInFlight = false
+	ts.LockState.Unlock()
+
+	b, _ := utils.Marshal(utils.RESTORE_REQUEST, ts.Id)
+	for _, connId := range d.supervisors() {
+		d.Pub.PublishBoard <- messages.Message{
+			Payload:      b,
+			TargetConnId: connId,
+		}
+	}
+	time.Sleep(800 * time.Millisecond)
+	d.BuildTopology(ts)
+}
 
-	timer := time.NewTimer(2 * time.Second)
-	d.CtlTimer = append(d.CtlTimer, timer)
-	go func() {
-		<-timer.C
-		log.Println("Timeout, build topology")
-		// send out restore message to all other supervisors
-		// and let them shutdown current workers
-		b, _ := utils.Marshal(utils.RESTORE_REQUEST, utils.RESTORE_REQUEST)
-		for _, connId := range d.SupervisorIdMap {
+// Execute the lifecycle command on the running topology
+func (d *Driver) HandleCommand(cmdType string, cmd *utils.TopologyCommand) error {
+	ts := d.GetTopology(cmd.Topology)
+	if ts == nil {
+		return fmt.Errorf("topology %s is not running", cmd.Topology)
+	}
+	switch cmdType {
+	case utils.TOPO_KILL:
+		log.Printf("Kill Topology %s\n", ts.Id)
+		d.RemoveTopology(ts.Id)
+		b, _ := utils.Marshal(utils.KILL_REQUEST, ts.Id)
+		for _, connId := range d.supervisors() {
 			d.Pub.PublishBoard <- messages.Message{
 				Payload:      b,
 				TargetConnId: connId,
 			}
 		}
-		time.Sleep(800 * time.Millisecond)
-		d.BuildTopology(d.Topo)
-	}()
-
+		d.ReleasePorts(ts.Id)
+	case utils.TOPO_DEACTIVATE:
+		log.Printf("Deactivate Topology %s\n", ts.Id)
+		ts.LockState.Lock()
+		ts.Active = false
+		ts.LockState.Unlock()
+		d.SendToHosts(ts, utils.SUSPEND_REQUEST, ts.Id)
+	case utils.TOPO_ACTIVATE:
+		log.Printf("Activate Topology %s\n", ts.Id)
+		ts.LockState.Lock()
+		ts.Active = true
+		ts.LockState.Unlock()
+		d.SendToHosts(ts, utils.RESUME_REQUEST, ts.Id)
+	case utils.TOPO_REBALANCE:
+		return d.Rebalance(ts, cmd.Component, cmd.InstNum)
+	case utils.TOPO_CHECKPOINT:
+		if err := d.Snapshot(ts); err != nil {
+			return err
+		}
+		log.Printf("Checkpoint Topology %s\n", ts.Id)
+	}
+	return nil
 }
 
-// Timer to request suspend on spout instances
-// before request backup snapshot for each node workers
-func (d *Driver) SuspendRequest() {
-	for {
-		time.Sleep(50 * time.Second)
-		hostConnIdMap := make(map[string]string)
-		for _, connId := range d.SupervisorIdMap {
-			host, _, _ := net.SplitHostPort(connId)
-			hostConnIdMap[host] = connId
+// Change the number of the tasks of the bolt. This is a full restart, all
+// the tasks of the topology are redeployed from the last completed snapshot
+// and the tuples since are replayed, so the states of all the tasks stay
+// of one snapshot. The keyed states are repartitioned to the new tasks by
+// the workers, the bolts whose states can not follow are refused before
+// the topology is torn down
+func (d *Driver) Rebalance(ts *TopologyState, component string, instNum int) error {
+	if instNum < 1 {
+		return fmt.Errorf("bolt %s can not have %d instances", component, instNum)
+	}
+	if ts.building() {
+		return fmt.Errorf("topology %s is being built, try again later", ts.Id)
+	}
+	for i := range ts.Topo.Bolts {
+		b := &ts.Topo.Bolts[i]
+		if b.Name != component {
+			continue
+		}
+		if err := d.checkRebalance(ts, b, instNum); err != nil {
+			return err
+		}
+		if instNum > b.InstNum {
+			slots := 0
+			for _, supervisor := range d.supervisors() {
+				slots += d.FreeSlots(supervisor)
+			}
+			if instNum-b.InstNum > slots {
+				return fmt.Errorf("%d more tasks exceed the %d free slots", instNum-b.InstNum, slots)
+			}
+			// The topology is rescheduled with the resources of its own tasks
+			prev := b.InstNum
+			b.InstNum = instNum
+			_, _, _, err := d.Schedule(ts.Topo, ts.Id)
+			b.InstNum = prev
+			if err != nil {
+				return err
+			}
+		}
+		if !ts.startBuilding() {
+			return fmt.Errorf("topology %s is being built, try again later", ts.Id)
 		}
+		log.Printf("Rebalance %s of Topology %s From %d to %d Tasks\n", component, ts.Id, b.InstNum, instNum)
+		b.InstNum = instNum
+		go d.Rebuild(ts)
+		return nil
+	}
+	return fmt.Errorf("topology %s has no bolt %s", ts.Id, component)
+}
 
-		spoutHosts := make(map[string]string)
-		for _, spout := range d.SpoutMap {
-			for _, addr := range spout.TaskAddrs {
-				host, _, _ := net.SplitHostPort(addr)
-				spoutHosts[host] = hostConnIdMap[host]
+// Check the state of the bolt follows its tasks rebalanced, by the kind
+// of the state its workers report. The keys follow the tasks only if the
+// tuples are routed by fields, and the states kept per task are lost with
+// the tasks removed
+func (d *Driver) checkRebalance(ts *TopologyState, b *bolt.BoltInst, instNum int) error {
+	placements := ts.placements()
+	kind, reported := "", false
+	d.LockPort.Lock()
+	for _, p := range placements {
+		if p.Component != b.Name {
+			continue
+		}
+		for _, w := range d.Heartbeats[p.Supervisor].Workers {
+			if w.Topology == ts.Id && w.Task == p.Task {
+				kind, reported = w.StateKind, true
 			}
 		}
-		if d.SnapshotInterval > 30 {
-			for i := d.SnapshotInterval; i >= 30; i-- {
+	}
+	d.LockPort.Unlock()
+	if !reported {
+		return fmt.Errorf("tasks of bolt %s reported no status yet, try again later", b.Name)
+	}
+	switch kind {
+	case utils.STATE_KEYED:
+		if !ts.groupedByFields(b) {
+			return fmt.Errorf("keyed bolt %s is not grouped by fields on all its inputs, its keys would not follow the tasks", b.Name)
+		}
+	case utils.STATE_TASK:
+		if instNum < b.InstNum {
+			return fmt.Errorf("bolt %s keeps its state per task, the states of the %d tasks removed would be lost", b.Name, b.InstNum-instNum)
+		}
+	}
+	return nil
+}
+
+// Send the message to the supervisors with tasks of the topology
+func (d *Driver) SendToHosts(ts *TopologyState, msgType string, content interface{}) {
+	b, _ := utils.Marshal(msgType, content)
+	for _, connId := range ts.hosts() {
+		d.Pub.PublishBoard <- messages.Message{
+			Payload:      b,
+			TargetConnId: connId,
+		}
+	}
+}
+
+// Reply the topology command with the error, if any
+func (d *Driver) RespondCommand(connId string, requestId uint64, err error) {
+	res := utils.CommandResponse{Ok: err == nil}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	b, _ := utils.MarshalRequest(utils.TOPO_COMMAND_RES, requestId, res)
+	d.Pub.PublishBoard <- messages.Message{
+		Payload:      b,
+		TargetConnId: connId,
+	}
+}
+
+// Timer to request checkpoints. Spouts serialize their variables and
+// inject the barrier into their streams, bolts serialize when the barriers
+// from all their inputs are aligned, so no worker is stopped for a snapshot
+func (d *Driver) CheckpointRequest(ts *TopologyState) {
+	for d.GetTopology(ts.Id) != nil {
+		time.Sleep(50 * time.Second)
+		ts.LockState.Lock()
+		interval := ts.SnapshotInterval
+		ts.SnapshotInterval = 30
+		ts.LockState.Unlock()
+		if interval > 30 {
+			for i := interval; i >= 30; i-- {
 				time.Sleep(time.Second)
 			}
-			d.SnapshotInterval = 30
 		}
 
-		for _, connId := range spoutHosts {
-			b, _ := utils.Marshal(utils.SUSPEND_REQUEST, utils.SUSPEND_REQUEST)
-			d.Pub.PublishBoard <- messages.Message{
-				Payload:      b,
-				TargetConnId: connId,
-			}
+		// No barrier passes the suspended spouts or the workers being built
+		if !ts.active() || ts.building() {
+			continue
+		}
+		if err := d.Snapshot(ts); err != nil {
+			log.Printf("%v, Skip Checkpoint\n", err)
 		}
 	}
 }
 
-// Send snapshot signal to supervisors
-func (d *Driver) Snapshot() {
-	if d.SnapshotVersion == 0 {
-		d.SnapshotVersion = 1
+// Send snapshot signal to the supervisors of the topology, only one
+// snapshot is in flight at the same time
+func (d *Driver) Snapshot(ts *TopologyState) error {
+	ts.LockState.Lock()
+	if !ts.Active || ts.Building {
+		ts.LockState.Unlock()
+		return fmt.Errorf("topology %s is not active", ts.Id)
 	}
-	for _, connId := range d.SupervisorIdMap {
-		b, _ := utils.Marshal(utils.SNAPSHOT_REQUEST, d.SnapshotVersion)
+	if ts.SnapshotInFlight {
+		ts.LockState.Unlock()
+		return fmt.Errorf("topology %s snapshot version %d is in flight", ts.Id, ts.SnapshotVersion)
+	}
+	if ts.SnapshotVersion == 0 {
+		ts.SnapshotVersion = 1
+	}
+	ts.SnapshotInFlight = true
+	version := ts.SnapshotVersion
+	hosts := append([]string{}, ts.Hosts...)
+	ts.LockState.Unlock()
+	for _, connId := range hosts {
+		b, _ := utils.Marshal(utils.SNAPSHOT_REQUEST, utils.SnapshotMessage{Topology: ts.Id, Version: version})
 		d.Pub.PublishBoard <- messages.Message{
 			Payload:      b,
 			TargetConnId: connId,
 		}
 	}
-
+	return nil
 }
 
-// Generate Topology Messages for each bolt or spout instance
-func (d *Driver) GenTopologyMessages(next string, visited *map[string]bool, count *int, addrs *map[int][]interface{}) {
-	if d.TopologyGraph == nil {
-		log.Println("No topology has been built")
-		return
+// Count the response of a supervisor to the snapshot, true once all the
+// supervisors of the topology responded. Responses of an abandoned
+// snapshot are ignored
+func (d *Driver) CompleteSnapshot(ts *TopologyState, version int) bool {
+	ts.LockState.Lock()
+	defer ts.LockState.Unlock()
+	if !ts.SnapshotInFlight || version != ts.SnapshotVersion {
+		return false
 	}
-
-	startVecs := d.TopologyGraph[next]
-	if startVecs == nil {
-		return
+	ts.SnapshotResponseCount++
+	if ts.SnapshotResponseCount != ts.TaskHostSum {
+		return false
+	}
+	// Confirm a correct version snapshot has completed
+	log.Printf("Topology %s Snapshot Version %d Completed\n", ts.Id, version)
+	ts.CompletedVersion = version
+	ts.SnapshotVersion++
+	ts.SnapshotResponseCount = 0
+	ts.SnapshotInFlight = false
+	for _, b := range ts.Topo.Bolts {
+		ts.StateInstNum[b.Name] = b.InstNum
 	}
+	return true
+}
 
-	for _, vec := range startVecs {
-		if next == "None" {
-			spout := vec.(*spout.SpoutInst)
-			if (*visited)[(*spout).Name] == true {
-				continue
-			}
+// Send commit signal of the completed snapshot version to supervisors,
+// sink bolts publish their transactions pre-committed up to the version
+func (d *Driver) Commit(ts *TopologyState, version int) {
+	for _, connId := range ts.hosts() {
+		b, _ := utils.Marshal(utils.SNAPSHOT_COMMIT, utils.SnapshotMessage{Topology: ts.Id, Version: version})
+		d.Pub.PublishBoard <- messages.Message{
+			Payload:      b,
+			TargetConnId: connId,
+		}
+	}
+}
 
-			fmt.Printf("#%s ", (*spout).Name)
-			(*visited)[(*spout).Name] = true
-			for i := 0; i < (*spout).InstNum; i++ {
-				id := (*count) % len(d.SupervisorIdMap)
-				if (*addrs)[id] == nil {
-					(*addrs)[id] = make([]interface{}, 0)
-				}
-				targetId := d.SupervisorIdMap[uint32(id)]
-				host, _, _ := net.SplitHostPort(targetId)
-				(*spout).TaskAddrs = append((*spout).TaskAddrs, host+":"+fmt.Sprintf("%d", utils.CONTRACTOR_BASE_PORT+len((*addrs)[id])))
-				(*addrs)[id] = append((*addrs)[id], spout)
-				(*count)++
-			}
-			d.GenTopologyMessages(spout.Name, visited, count, addrs)
-		} else {
-			bolt := vec.(*bolt.BoltInst)
-			if (*visited)[(*bolt).Name] == true {
-				continue
+// Place the task instances on the supervisors assigned by the scheduler,
+// the ports are allocated and the task addresses are generated
+func (d *Driver) PlaceTasks(ts *TopologyState, instances []interface{}, assignment []int, offers []SupervisorOffer) (map[int]*placement, error) {
+	addrs := make(map[int]*placement)
+	for i, inst := range instances {
+		id := assignment[i]
+		if addrs[id] == nil {
+			addrs[id] = &placement{supervisor: offers[id].Id}
+		}
+		targetId := offers[id].Id
+		host := d.Host(targetId)
+		switch c := inst.(type) {
+		case *spout.SpoutInst:
+			port, err := d.AllocatePort(targetId, ts.Id, utils.TaskResources(c.CPU, c.Memory))
+			if err != nil {
+				return nil, err
 			}
-			(*visited)[(*bolt).Name] = true
-			fmt.Printf("#%s ", (*bolt).Name)
-			for i := 0; i < (*bolt).InstNum; i++ {
-				id := (*count) % len(d.SupervisorIdMap)
-				if (*addrs)[id] == nil {
-					(*addrs)[id] = make([]interface{}, 0)
-				}
-				targetId := d.SupervisorIdMap[uint32(id)]
-				host, _, _ := net.SplitHostPort(targetId)
-				(*bolt).TaskAddrs = append((*bolt).TaskAddrs, host+":"+fmt.Sprintf("%d", utils.CONTRACTOR_BASE_PORT+len((*addrs)[id])))
-				(*addrs)[id] = append((*addrs)[id], bolt)
-				(*count)++
+			c.TaskAddrs = append(c.TaskAddrs, host+":"+fmt.Sprintf("%d", port))
+			addrs[id].ports = append(addrs[id].ports, port)
+		case *bolt.BoltInst:
+			port, err := d.AllocatePort(targetId, ts.Id, utils.TaskResources(c.CPU, c.Memory))
+			if err != nil {
+				return nil, err
 			}
-			d.GenTopologyMessages(bolt.Name, visited, count, addrs)
+			c.TaskAddrs = append(c.TaskAddrs, host+":"+fmt.Sprintf("%d", port))
+			addrs[id].ports = append(addrs[id].ports, port)
 		}
+		addrs[id].tasks = append(addrs[id].tasks, inst)
 	}
+	return addrs, nil
 }
 
 // Output the topology in the std out
-func (d *Driver) PrintTopology(next string, level int) {
-	if d.TopologyGraph == nil {
+func (d *Driver) PrintTopology(ts *TopologyState, next string, level int) {
+	if ts.TopologyGraph == nil {
 		log.Println("No topology has been built")
 		return
 	}
-	startVecs := d.TopologyGraph[next]
+	startVecs := ts.TopologyGraph[next]
 	if startVecs == nil {
 		return
 	}
@@ -507,14 +948,14 @@ func (d *Driver) PrintTopology(next string, level int) {
 		}
 		if next == "None" {
 			fmt.Printf("#%s ", vec.(*spout.SpoutInst).Name)
-			d.SpoutMap[vec.(*spout.SpoutInst).Name] = (*vec.(*spout.SpoutInst))
+			ts.SpoutMap[vec.(*spout.SpoutInst).Name] = (*vec.(*spout.SpoutInst))
 			fmt.Println(vec.(*spout.SpoutInst).TaskAddrs)
-			d.PrintTopology(vec.(*spout.SpoutInst).Name, level+1)
+			d.PrintTopology(ts, vec.(*spout.SpoutInst).Name, level+1)
 		} else {
 			fmt.Printf("--- %s ", vec.(*bolt.BoltInst).Name)
-			d.BoltMap[vec.(*bolt.BoltInst).Name] = (*vec.(*bolt.BoltInst))
+			ts.BoltMap[vec.(*bolt.BoltInst).Name] = (*vec.(*bolt.BoltInst))
 			fmt.Println(vec.(*bolt.BoltInst).TaskAddrs)
-			d.PrintTopology(vec.(*bolt.BoltInst).Name, level+1)
+			d.PrintTopology(ts, vec.(*bolt.BoltInst).Name, level+1)
 		}
 	}
 }
@@ -526,13 +967,3 @@ func (d *Driver) Hashcode(id string) uint32 {
 	hashcode = (hashcode + 5) >> 5 % uint32(len(d.SupervisorIdMap))
 	return hashcode
 }
-
-func main() {
-	driver := NewDriver(":" + fmt.Sprintf("%d", utils.DRIVER_PORT))
-	LocalIP := utils.GetLocalIP().String()
-	LocalHostname := utils.GetLocalHostname()
-	log.Printf("Local Machine Info [%s] [%s]\n", LocalIP, LocalHostname)
-
-	driver.VmIndexMap = utils.GetVmMap()
-	driver.StartDaemon()
-}
@@ -0,0 +1,198 @@
+package driver
+
+import (
+	"bufio"
+	"crane/core/messages"
+	"crane/core/utils"
+	"log"
+	"net"
+	"time"
+)
+
+// Wait as a standby while another driver of the peers leads, and return
+// when this driver is to take over. Only the leader listens for the
+// supervisors, so a peer accepting the connection is the leader. The
+// standbys take over in the order of the peers when the leader is lost
+func WaitForLeadership(peers []string, self string) {
+	priority := 0
+	others := make([]string, 0)
+	for i, peer := range peers {
+		if peer == self {
+			priority = i
+		} else {
+			others = append(others, peer)
+		}
+	}
+	lost := time.Now().Add(-utils.HEARTBEAT_TIMEOUT * time.Second)
+	for {
+		for _, peer := range others {
+			if watchLeader(peer) {
+				log.Printf("Leader Driver %s Is Lost\n", peer)
+				lost = time.Now()
+			}
+		}
+		// The peers before this one take over first
+		wait := time.Duration(utils.HEARTBEAT_TIMEOUT+priority*utils.HEARTBEAT_INTERVAL) * time.Second
+		if time.Since(lost) >= wait {
+			log.Printf("No Leader Driver in %v, Take Over\n", wait)
+			return
+		}
+		time.Sleep(utils.HEARTBEAT_INTERVAL * time.Second)
+	}
+}
+
+// Ping the leader driver of the address until it is lost, false if
+// it does not accept the connection
+func watchLeader(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, utils.HEARTBEAT_INTERVAL*time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if _, err := messages.Handshake(conn); err != nil {
+		log.Printf("Leader Driver %s Refused: %v\n", addr, err)
+		return false
+	}
+	log.Printf("Standby of Leader Driver %s\n", addr)
+	reader := bufio.NewReader(conn)
+	b, _ := utils.Marshal(utils.LEADER_PING, "")
+	for {
+		conn.SetDeadline(time.Now().Add(utils.HEARTBEAT_TIMEOUT * time.Second))
+		if err := messages.WriteFrame(conn, b); err != nil {
+			return true
+		}
+		if _, err := messages.ReadFrame(reader); err != nil {
+			return true
+		}
+		time.Sleep(utils.HEARTBEAT_INTERVAL * time.Second)
+	}
+}
+
+// Restore the topologies persisted by the previous leader, with a new term.
+// They are rebuilt from their last completed snapshots once the supervisors
+// join this driver
+func (d *Driver) Restore() error {
+	cs, err := d.Store.Load()
+	if err != nil {
+		return err
+	}
+	if cs == nil {
+		cs = &ClusterState{}
+	}
+	d.LockTopo.Lock()
+	d.Term = cs.Term + 1
+	d.TopologySeq = cs.TopologySeq
+	for _, record := range cs.Topologies {
+		ts := NewTopologyState(record.Id, record.Topo)
+		ts.Active = record.Active
+		ts.CompletedVersion = record.CompletedVersion
+		// The snapshot in flight when the leader was lost is skipped
+		ts.SnapshotVersion = record.SnapshotVersion + 1
+		for name, instNum := range record.StateInstNum {
+			ts.StateInstNum[name] = instNum
+		}
+		d.Topologies[ts.Id] = ts
+	}
+	d.LockTopo.Unlock()
+	// The previous leader steps down when it sees the new term
+	if err := d.Persist(); err != nil {
+		return err
+	}
+	log.Printf("Leader Driver of Term %d Restores %d Topologies\n", d.Term, len(cs.Topologies))
+
+	go func() {
+		time.Sleep(utils.HEARTBEAT_TIMEOUT * time.Second)
+		for len(cs.Topologies) > 0 && len(d.Offers("")) == 0 {
+			log.Println("No supervisor to rebuild the restored topologies")
+			time.Sleep(utils.HEARTBEAT_TIMEOUT * time.Second)
+		}
+		d.LockTopo.Lock()
+		defer d.LockTopo.Unlock()
+		for _, ts := range d.Topologies {
+			log.Printf("Rebuild Restored Topology %s From Snapshot Version %d\n", ts.Id, ts.CompletedVersion)
+			ts.setBuilding(true)
+			go d.Rebuild(ts)
+			go d.CheckpointRequest(ts)
+		}
+	}()
+	return nil
+}
+
+// Persist the cluster state, the saves are in order so the latest
+// state is saved last. A deposed leader steps down instead of saving
+// over the state of the newer term
+func (d *Driver) Persist() error {
+	if d.Store == nil {
+		return nil
+	}
+	d.LockStore.Lock()
+	defer d.LockStore.Unlock()
+	stored, err := d.Store.Load()
+	if err != nil {
+		return err
+	}
+	d.stepDown(stored)
+	d.LockTopo.RLock()
+	cs := &ClusterState{
+		Term:        d.Term,
+		TopologySeq: d.TopologySeq,
+		Topologies:  make([]TopologyRecord, 0),
+	}
+	for _, ts := range d.Topologies {
+		ts.LockState.RLock()
+		stateInstNum := make(map[string]int)
+		for name, instNum := range ts.StateInstNum {
+			stateInstNum[name] = instNum
+		}
+		cs.Topologies = append(cs.Topologies, TopologyRecord{
+			Id:               ts.Id,
+			Topo:             ts.Topo,
+			Active:           ts.Active,
+			SnapshotVersion:  ts.SnapshotVersion,
+			CompletedVersion: ts.CompletedVersion,
+			StateInstNum:     stateInstNum,
+		})
+		ts.LockState.RUnlock()
+	}
+	d.LockTopo.RUnlock()
+	return d.Store.Save(cs)
+}
+
+// Persist the cluster state in the background, the error is logged
+func (d *Driver) PersistAsync() {
+	go func() {
+		if err := d.Persist(); err != nil {
+			log.Printf("Persist Cluster State Failed: %v\n", err)
+		}
+	}()
+}
+
+// Step down when a driver of a newer term took over meanwhile, e.g. after
+// a network partition. The process exits and is restarted as a standby
+func (d *Driver) CheckTerm() {
+	for {
+		time.Sleep(utils.HEARTBEAT_TIMEOUT * time.Second)
+		cs, err := d.Store.Load()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		d.stepDown(cs)
+	}
+}
+
+// Exit if the cluster state stored is of a newer term
+func (d *Driver) stepDown(cs *ClusterState) {
+	if cs != nil && cs.Term > d.Term {
+		log.Fatalf("Leader Driver of Term %d Took Over, Driver of Term %d Steps Down\n", cs.Term, d.Term)
+	}
+}
+
+// Answer the ping of a standby driver
+func (d *Driver) RespondPing(connId string) {
+	b, _ := utils.Marshal(utils.LEADER_PONG, d.Term)
+	d.Pub.PublishBoard <- messages.Message{
+		Payload:      b,
+		TargetConnId: connId,
+	}
+}
@@ -0,0 +1,149 @@
+package driver
+
+import (
+	"crane/core/messages"
+	"crane/core/utils"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Answer the query of the client about the cluster
+func (d *Driver) HandleQuery(queryType string, query *utils.TopologyQuery) (interface{}, error) {
+	switch queryType {
+	case utils.TOPO_LIST:
+		d.LockTopo.RLock()
+		defer d.LockTopo.RUnlock()
+		summaries := make([]utils.TopologySummary, 0)
+		for _, ts := range d.Topologies {
+			summaries = append(summaries, summarize(ts))
+		}
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].Id < summaries[j].Id })
+		return summaries, nil
+
+	case utils.TOPO_DESCRIBE:
+		ts := d.GetTopology(query.Topology)
+		if ts == nil {
+			return nil, fmt.Errorf("topology %s is not running", query.Topology)
+		}
+		return d.describe(ts), nil
+
+	case utils.SUPERVISOR_LIST:
+		d.LockSIM.RLock()
+		names := make(map[string]string)
+		for id, name := range d.SupervisorNames {
+			names[id] = name
+		}
+		d.LockSIM.RUnlock()
+		supervisors := make([]utils.SupervisorInfo, 0)
+		for _, offer := range d.Offers("") {
+			d.LockPort.Lock()
+			tasks := len(d.PortMap[offer.Id])
+			last := d.LastHeartbeat[offer.Id]
+			d.LockPort.Unlock()
+			supervisors = append(supervisors, utils.SupervisorInfo{
+				Id:            offer.Id,
+				Name:          names[offer.Id],
+				Tasks:         tasks,
+				FreeSlots:     offer.FreeSlots,
+				Capacity:      offer.Capacity,
+				Available:     offer.Available,
+				Used:          offer.Used,
+				HeartbeatSecs: time.Since(last).Seconds(),
+			})
+		}
+		return supervisors, nil
+	}
+	return nil, fmt.Errorf("unknown query %s", queryType)
+}
+
+// Summary of the running topology
+func summarize(ts *TopologyState) utils.TopologySummary {
+	ts.LockState.RLock()
+	defer ts.LockState.RUnlock()
+	return utils.TopologySummary{
+		Id:              ts.Id,
+		Name:            ts.Topo.Name,
+		Active:          ts.Active,
+		Tasks:           len(ts.Placements),
+		Supervisors:     len(ts.Hosts),
+		SnapshotVersion: ts.CompletedVersion,
+	}
+}
+
+// Components of the running topology and the placements of its tasks,
+// with their status in the latest heartbeats
+func (d *Driver) describe(ts *TopologyState) utils.TopologyDescription {
+	desc := utils.TopologyDescription{
+		TopologySummary: summarize(ts),
+		Components:      make([]utils.ComponentInfo, 0),
+		Tasks:           make([]utils.TaskPlacement, 0),
+	}
+	placements := ts.placements()
+	d.LockPort.Lock()
+	for _, p := range placements {
+		for _, w := range d.Heartbeats[p.Supervisor].Workers {
+			if w.Topology == ts.Id && w.Task == p.Task {
+				p.Status = w
+				break
+			}
+		}
+		desc.Tasks = append(desc.Tasks, p)
+	}
+	d.LockPort.Unlock()
+	for _, s := range ts.Topo.Spouts {
+		desc.Components = append(desc.Components, utils.ComponentInfo{Name: s.Name, Kind: "spout", InstNum: s.InstNum})
+	}
+	for _, b := range ts.Topo.Bolts {
+		desc.Components = append(desc.Components, utils.ComponentInfo{Name: b.Name, Kind: "bolt", InstNum: b.InstNum, PrevTasks: b.PrevTaskNames})
+	}
+	return desc
+}
+
+// Reply the query with its result, or the error failing it
+func (d *Driver) RespondQuery(connId string, requestId uint64, result interface{}, err error) {
+	res := utils.QueryResponse{}
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Content, _ = json.Marshal(result)
+	}
+	b, _ := utils.MarshalRequest(utils.QUERY_RES, requestId, res)
+	d.Pub.PublishBoard <- messages.Message{
+		Payload:      b,
+		TargetConnId: connId,
+	}
+}
+
+// Forward the logs query to the supervisor running the task, its
+// response is replied to the client by the id of the client request
+func (d *Driver) RequestLogs(connId string, requestId uint64, query *utils.TopologyQuery) error {
+	ts := d.GetTopology(query.Topology)
+	if ts == nil {
+		return fmt.Errorf("topology %s is not running", query.Topology)
+	}
+	supervisor := ""
+	for _, p := range ts.placements() {
+		if p.Task == query.Task {
+			supervisor = p.Supervisor
+			break
+		}
+	}
+	if supervisor == "" {
+		return fmt.Errorf("task %s is not placed in topology %s", query.Task, ts.Id)
+	}
+
+	d.LockSIM.Lock()
+	d.RequestSeq++
+	id := d.RequestSeq
+	d.PendingLogs[id] = pendingRequest{connId: connId, requestId: requestId}
+	d.LockSIM.Unlock()
+
+	b, _ := utils.MarshalRequest(utils.LOGS_REQUEST, id, query)
+	d.Pub.PublishBoard <- messages.Message{
+		Payload:      b,
+		TargetConnId: supervisor,
+	}
+	return nil
+}
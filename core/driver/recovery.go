@@ -0,0 +1,304 @@
+package driver
+
+import (
+	"crane/bolt"
+	"crane/core/messages"
+	"crane/core/utils"
+	"crane/spout"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Recover the topology from the supervisors failed. Only the tasks of the
+// failed supervisors are moved to the others, the whole topology is rebuilt
+// if it asks so, if it is being built, or if its acker is lost. Without
+// acking the tuples lost are not replayed, the tasks alive would keep the
+// states past the snapshot, so the topology is rebuilt too
+func (d *Driver) Recover(ts *TopologyState) {
+	ts.LockRecover.Lock()
+	failed := ts.FailedHosts
+	ts.FailedHosts = make([]string, 0)
+	ts.RestoreTimer = nil
+	ts.LockRecover.Unlock()
+	// the topology is killed meanwhile
+	if d.GetTopology(ts.Id) == nil {
+		return
+	}
+
+	ts.LockState.RLock()
+	rebuild := ts.Topo.Recovery == utils.RECOVERY_TOPOLOGY || ts.Topo.AckTimeout == 0 ||
+		ts.Building || len(ts.Placements) == 0
+	ackerHost := ts.AckerHost
+	ts.LockState.RUnlock()
+	for _, supervisor := range failed {
+		if supervisor == ackerHost {
+			log.Printf("Acker of Topology %s Is Lost\n", ts.Id)
+			rebuild = true
+		}
+	}
+	if !rebuild {
+		err := d.Reassign(ts, failed)
+		if err == nil {
+			return
+		}
+		log.Printf("Reassign Tasks of Topology %s Failed: %v\n", ts.Id, err)
+	}
+	log.Printf("Rebuild Topology %s\n", ts.Id)
+	d.Rebuild(ts)
+}
+
+// Move the tasks of the failed supervisors to the others. The moved tasks
+// restore their states from the last completed snapshot, and the bolts
+// subscribing to them are rewired to their new addresses. The other tasks
+// keep running, the tuples lost are replayed by the acking
+func (d *Driver) Reassign(ts *TopologyState, failed []string) error {
+	ts.setBuilding(true)
+	defer ts.setBuilding(false)
+
+	isFailed := make(map[string]bool)
+	for _, supervisor := range failed {
+		isFailed[supervisor] = true
+	}
+	lost := make([]int, 0)
+	tasks := make([]interface{}, 0)
+	requests := make([]TaskRequest, 0)
+	placements := ts.placements()
+	for index, p := range placements {
+		if !isFailed[p.Supervisor] {
+			continue
+		}
+		task := ts.component(p.Component)
+		if task == nil {
+			return fmt.Errorf("topology %s has no component %s", ts.Id, p.Component)
+		}
+		lost = append(lost, index)
+		tasks = append(tasks, task)
+		switch c := task.(type) {
+		case *spout.SpoutInst:
+			requests = append(requests, TaskRequest{Component: c.Name, Resources: utils.TaskResources(c.CPU, c.Memory)})
+		case *bolt.BoltInst:
+			requests = append(requests, TaskRequest{Component: c.Name, Resources: utils.TaskResources(c.CPU, c.Memory)})
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+	offers := d.Offers("")
+	if len(offers) == 0 {
+		return fmt.Errorf("no supervisor left")
+	}
+	assignment, err := NewScheduler(ts.Topo.Scheduler).Schedule(requests, offers)
+	if err != nil {
+		return err
+	}
+
+	abandoned := d.AbandonSnapshot(ts)
+
+	// The moved tasks have their new addresses before any task message,
+	// as they may subscribe to each other
+	ports := make([]int, len(lost))
+	moved := make(map[string]string)
+	hosts := make(map[string][]interface{})
+	for i, index := range lost {
+		p := placements[index]
+		supervisor := offers[assignment[i]].Id
+		if ports[i], err = d.AllocatePort(supervisor, ts.Id, requests[i].Resources); err != nil {
+			return err
+		}
+		placements[index] = d.placement(p.Component, p.Task, supervisor, fmt.Sprintf("%d", ports[i]))
+		moved[p.Addr] = placements[index].Addr
+		replaceAddr(tasks[i], p.Addr, placements[index].Addr)
+		hosts[supervisor] = append(hosts[supervisor], tasks[i])
+		log.Printf("Move Task %s of Topology %s From %s to %s\n", p.Task, ts.Id, p.Supervisor, supervisor)
+	}
+	ts.LockState.Lock()
+	ts.Placements = placements
+	ts.LockState.Unlock()
+
+	// Stage 1 : Pull the plugin files on the supervisors of the moved tasks
+	for supervisor, hostTasks := range hosts {
+		for _, file := range pluginFiles(hostTasks) {
+			b, _ := utils.Marshal(utils.FILE_PULL, utils.FilePull{Filename: file})
+			d.Pub.PublishBoard <- messages.Message{
+				Payload:      b,
+				TargetConnId: supervisor,
+			}
+		}
+	}
+	time.Sleep(2 * time.Second)
+
+	// Stage 2 : Send the task messages of the moved tasks
+	for i, index := range lost {
+		p := placements[index]
+		msgType, msg := d.taskMessage(ts, tasks[i], p.Task, ports[i])
+		b, _ := utils.Marshal(msgType, msg)
+		d.Pub.PublishBoard <- messages.Message{
+			Payload:      b,
+			TargetConnId: p.Supervisor,
+		}
+	}
+
+	alive := make([]string, 0)
+	for _, host := range ts.hosts() {
+		if !isFailed[host] {
+			alive = append(alive, host)
+		}
+	}
+	for supervisor := range hosts {
+		if !contains(alive, supervisor) {
+			alive = append(alive, supervisor)
+		}
+	}
+	ts.LockState.Lock()
+	ts.Hosts = alive
+	ts.TaskHostSum = len(alive)
+	ts.LockState.Unlock()
+	time.Sleep(2 * time.Second)
+
+	// Stage 3 : Start the moved tasks, the supervisors start
+	// only the workers not started yet
+	for supervisor := range hosts {
+		b, _ := utils.Marshal(utils.TASK_ALL_DISPATCHED, ts.Id)
+		d.Pub.PublishBoard <- messages.Message{
+			Payload:      b,
+			TargetConnId: supervisor,
+		}
+		if !ts.active() {
+			b, _ = utils.Marshal(utils.SUSPEND_REQUEST, ts.Id)
+			d.Pub.PublishBoard <- messages.Message{
+				Payload:      b,
+				TargetConnId: supervisor,
+			}
+		}
+	}
+	// Wait for the moved tasks to accept the subscribers
+	time.Sleep(5 * time.Second)
+
+	// Stage 4 : Rewire the bolts subscribing to the moved tasks
+	d.SendToHosts(ts, utils.REWIRE_REQUEST, utils.RewireMessage{
+		Topology:  ts.Id,
+		Addrs:     moved,
+		Abandoned: abandoned,
+	})
+	log.Printf("Topology %s Reassigned %d Tasks\n", ts.Id, len(lost))
+	return nil
+}
+
+// The worker crashed is restarted by its supervisor from the last completed
+// snapshot. The snapshot in flight is abandoned, and the bolts subscribing
+// to the worker connect to it again when it runs
+func (d *Driver) HandleWorkerFailure(failureType string, failure *utils.WorkerFailure) {
+	ts := d.GetTopology(failure.Topology)
+	if ts == nil {
+		return
+	}
+	d.AbandonSnapshot(ts)
+	if failureType == utils.WORKER_FAILURE {
+		log.Printf("Worker %s of Topology %s Crashed After %d Restarts: %s\n", failure.Task, ts.Id, failure.Restarts, failure.Reason)
+		return
+	}
+	log.Printf("Worker %s of Topology %s Restarted\n", failure.Task, ts.Id)
+	addr := ""
+	for _, p := range ts.placements() {
+		if p.Task == failure.Task {
+			addr = p.Addr
+		}
+	}
+	if addr == "" {
+		return
+	}
+	go func() {
+		// Wait for the worker to accept the subscribers
+		time.Sleep(5 * time.Second)
+		// The versions before the one in flight are completed or abandoned
+		ts.LockState.RLock()
+		abandoned := ts.SnapshotVersion - 1
+		ts.LockState.RUnlock()
+		d.SendToHosts(ts, utils.REWIRE_REQUEST, utils.RewireMessage{
+			Topology:  ts.Id,
+			Addrs:     map[string]string{addr: addr},
+			Abandoned: abandoned,
+		})
+	}()
+}
+
+// Abandon the snapshot in flight, its barriers are lost with the workers
+// failed. The version is skipped as the workers alive may have passed it
+func (d *Driver) AbandonSnapshot(ts *TopologyState) int {
+	ts.LockState.Lock()
+	if !ts.SnapshotInFlight {
+		ts.LockState.Unlock()
+		return 0
+	}
+	abandoned := ts.SnapshotVersion
+	log.Printf("Topology %s Snapshot Version %d Abandoned\n", ts.Id, abandoned)
+	ts.SnapshotVersion++
+	ts.SnapshotResponseCount = 0
+	ts.SnapshotInFlight = false
+	ts.LockState.Unlock()
+	d.PersistAsync()
+	return abandoned
+}
+
+// Spout or bolt component of the topology by its name, nil if there is none
+func (ts *TopologyState) component(name string) interface{} {
+	for i := range ts.Topo.Spouts {
+		if ts.Topo.Spouts[i].Name == name {
+			return &ts.Topo.Spouts[i]
+		}
+	}
+	for i := range ts.Topo.Bolts {
+		if ts.Topo.Bolts[i].Name == name {
+			return &ts.Topo.Bolts[i]
+		}
+	}
+	return nil
+}
+
+// Whether the tuples of every input of the bolt are routed to its tasks
+// by fields, by the fields it groups by or the grouping of the input
+func (ts *TopologyState) groupedByFields(b *bolt.BoltInst) bool {
+	for _, prev := range b.PrevTaskNames {
+		if _, ok := b.GroupingFields[prev]; ok {
+			continue
+		}
+		grouping := ""
+		switch c := ts.component(prev).(type) {
+		case *spout.SpoutInst:
+			grouping = c.GroupingHint
+		case *bolt.BoltInst:
+			grouping = c.GroupingHint
+		}
+		if grouping != utils.GROUPING_BY_FIELD {
+			return false
+		}
+	}
+	return true
+}
+
+// Replace the task address of the component in place, the copies of the
+// component in the topology graph share the addresses
+func replaceAddr(task interface{}, old string, addr string) {
+	var addrs []string
+	switch c := task.(type) {
+	case *spout.SpoutInst:
+		addrs = c.TaskAddrs
+	case *bolt.BoltInst:
+		addrs = c.TaskAddrs
+	}
+	for i := range addrs {
+		if addrs[i] == old {
+			addrs[i] = addr
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"crane/core/utils"
+	"fmt"
+	"math"
+)
+
+// Task instance to place, the tasks are in the DFS order of the
+// topology so that the chatty neighbour components are next to each other
+type TaskRequest struct {
+	Component string
+	Resources utils.Resources
+}
+
+// Supervisor the tasks are placed on, with the slots and the resources
+// not used by the other topologies. Used is the load measured on the
+// supervisor by its latest heartbeat
+type SupervisorOffer struct {
+	Id        string
+	FreeSlots int
+	Capacity  utils.Resources
+	Available utils.Resources
+	Used      utils.Resources
+}
+
+// Scheduler places the tasks of a topology on the supervisors
+type Scheduler interface {
+	// Index of the supervisor offer of every task, or the error
+	// if the tasks do not fit
+	Schedule(tasks []TaskRequest, offers []SupervisorOffer) ([]int, error)
+}
+
+// Scheduler of the name, round robin by default
+func NewScheduler(name string) Scheduler {
+	switch name {
+	case utils.SCHEDULER_RESOURCE_AWARE:
+		return &ResourceAwareScheduler{}
+	}
+	return &RoundRobinScheduler{}
+}
+
+// RoundRobinScheduler places the tasks on the supervisors in turn,
+// skipping the supervisors without free slots
+type RoundRobinScheduler struct{}
+
+func (s *RoundRobinScheduler) Schedule(tasks []TaskRequest, offers []SupervisorOffer) ([]int, error) {
+	slots := make([]int, len(offers))
+	for i, offer := range offers {
+		slots[i] = offer.FreeSlots
+	}
+	assignment := make([]int, len(tasks))
+	next := 0
+	for i, task := range tasks {
+		placed := false
+		for tried := 0; tried < len(offers); tried++ {
+			id := next % len(offers)
+			next++
+			if slots[id] > 0 {
+				slots[id]--
+				assignment[i] = id
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			return nil, fmt.Errorf("no free slot for a task of %s", task.Component)
+		}
+	}
+	return assignment, nil
+}
+
+// ResourceAwareScheduler keeps placing the tasks on the same supervisor
+// while its CPU, memory and slots fit them, so that the neighbour components
+// are co-located. Then it moves on to the supervisor with the most resources
+// available in proportion to its capacity, also by the load it reports
+type ResourceAwareScheduler struct{}
+
+func (s *ResourceAwareScheduler) Schedule(tasks []TaskRequest, offers []SupervisorOffer) ([]int, error) {
+	free := make([]SupervisorOffer, len(offers))
+	copy(free, offers)
+	fits := func(id int, task TaskRequest) bool {
+		return free[id].FreeSlots > 0 && free[id].Available.Fits(task.Resources)
+	}
+
+	assignment := make([]int, len(tasks))
+	current := -1
+	for i, task := range tasks {
+		if current < 0 || !fits(current, task) {
+			current = -1
+			best := -1.0
+			for id := range free {
+				if !fits(id, task) {
+					continue
+				}
+				if score := availability(free[id]); score > best {
+					current, best = id, score
+				}
+			}
+			if current < 0 {
+				return nil, fmt.Errorf("no supervisor has %.0f%% CPU and %d MB memory for a task of %s",
+					task.Resources.CPU, task.Resources.Memory, task.Component)
+			}
+		}
+		free[current].FreeSlots--
+		free[current].Available = free[current].Available.Sub(task.Resources)
+		assignment[i] = current
+	}
+	return assignment, nil
+}
+
+// Sum of the fractions of the CPU and memory available, the measured
+// load counts when it is higher than the resources allocated
+func availability(offer SupervisorOffer) float64 {
+	idle := offer.Capacity.Sub(offer.Used)
+	cpu := math.Min(offer.Available.CPU, idle.CPU)
+	memory := offer.Available.Memory
+	if idle.Memory < memory {
+		memory = idle.Memory
+	}
+	score := 0.0
+	if offer.Capacity.CPU > 0 {
+		score += cpu / offer.Capacity.CPU
+	}
+	if offer.Capacity.Memory > 0 {
+		score += float64(memory) / float64(offer.Capacity.Memory)
+	}
+	return score
+}
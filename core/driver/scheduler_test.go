@@ -0,0 +1,92 @@
+package driver
+
+import (
+	"crane/core/utils"
+	"reflect"
+	"testing"
+)
+
+func taskRequests(n int, cpu float64, memory int) []TaskRequest {
+	tasks := make([]TaskRequest, n)
+	for i := range tasks {
+		tasks[i] = TaskRequest{Component: "CountBolt", Resources: utils.Resources{CPU: cpu, Memory: memory}}
+	}
+	return tasks
+}
+
+func TestRoundRobinScheduler(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks int
+		slots []int
+		want  []int
+	}{
+		{"in turn", 4, []int{2, 2}, []int{0, 1, 0, 1}},
+		{"skips full supervisors", 4, []int{1, 3, 0}, []int{0, 1, 1, 1}},
+		{"no free slot", 5, []int{1, 3, 0}, nil},
+		{"no supervisor", 1, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offers := make([]SupervisorOffer, len(tt.slots))
+			for i, slots := range tt.slots {
+				offers[i] = SupervisorOffer{FreeSlots: slots}
+			}
+			got, err := NewScheduler(utils.SCHEDULER_ROUND_ROBIN).Schedule(taskRequests(tt.tasks, 0, 0), offers)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("placed %v, expected an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("placed %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceAwareScheduler(t *testing.T) {
+	capacity := utils.Resources{CPU: 400, Memory: 4096}
+	offer := func(slots int, used utils.Resources) SupervisorOffer {
+		return SupervisorOffer{FreeSlots: slots, Capacity: capacity, Available: capacity, Used: used}
+	}
+	tests := []struct {
+		name   string
+		tasks  []TaskRequest
+		offers []SupervisorOffer
+		want   []int
+	}{
+		// The second supervisor reports a higher load, the tasks fill the first one
+		{"co-locates on the idle supervisor", taskRequests(5, 100, 1024),
+			[]SupervisorOffer{offer(8, utils.Resources{}), offer(8, utils.Resources{CPU: 300})}, []int{0, 0, 0, 0, 1}},
+		{"least loaded first", taskRequests(2, 100, 1024),
+			[]SupervisorOffer{offer(8, utils.Resources{Memory: 2048}), offer(8, utils.Resources{})}, []int{1, 1}},
+		{"moves on when out of slots", taskRequests(3, 100, 1024),
+			[]SupervisorOffer{offer(2, utils.Resources{}), offer(8, utils.Resources{CPU: 100})}, []int{0, 0, 1}},
+		{"task too large", taskRequests(1, 500, 1024),
+			[]SupervisorOffer{offer(8, utils.Resources{}), offer(8, utils.Resources{})}, nil},
+		{"out of memory", taskRequests(9, 10, 1024),
+			[]SupervisorOffer{offer(8, utils.Resources{}), offer(8, utils.Resources{})}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewScheduler(utils.SCHEDULER_RESOURCE_AWARE).Schedule(tt.tasks, tt.offers)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("placed %v, expected an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("placed %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package driver
+
+import (
+	"context"
+	"crane/core/utils"
+	sdfs "crane/simpledfs/client"
+	"crane/topology"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// State of the cluster persisted by the leader driver, a standby driver
+// taking over restores the topologies from it. The supervisors are not
+// persisted, they join the new leader again
+type ClusterState struct {
+	// Term of the leader, every driver taking over increments it
+	Term        int
+	TopologySeq int
+	Topologies  []TopologyRecord
+}
+
+// Running topology persisted with its snapshot versions
+type TopologyRecord struct {
+	Id               string
+	Topo             *topology.Topology
+	Active           bool
+	SnapshotVersion  int
+	CompletedVersion int
+	StateInstNum     map[string]int
+}
+
+// StateStore persists the cluster state of the leader driver, it is shared
+// by all the drivers of the peers
+type StateStore interface {
+	Save(state *ClusterState) error
+	// The cluster state saved last, nil if none is saved yet
+	Load() (*ClusterState, error)
+}
+
+// Store of the kind, the local file or the SDFS file of the name
+func NewStore(kind string, name string, sdfsMasterAddr string) (StateStore, error) {
+	switch kind {
+	case utils.STATE_BACKEND_LOCAL, "":
+		return &LocalStore{Path: name}, nil
+	case utils.STATE_BACKEND_SDFS:
+		return &SdfsStore{Client: sdfs.NewClient(sdfsMasterAddr), Name: filepath.Base(name)}, nil
+	}
+	return nil, fmt.Errorf("unknown store %q", kind)
+}
+
+// LocalStore keeps the cluster state in a local file, the standby drivers
+// share it only on the same machine or a shared file system
+type LocalStore struct {
+	Path string
+}
+
+func (ls *LocalStore) Save(state *ClusterState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	// The file is replaced at once, a crash never leaves half of it
+	if err := os.WriteFile(ls.Path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(ls.Path+".tmp", ls.Path)
+}
+
+func (ls *LocalStore) Load() (*ClusterState, error) {
+	b, err := os.ReadFile(ls.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &ClusterState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// SdfsStore keeps the cluster state in a SDFS file, so that the standby
+// drivers on any machine restore it
+type SdfsStore struct {
+	Client *sdfs.Client
+	Name   string
+}
+
+func (ss *SdfsStore) Save(state *ClusterState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp("", ss.Name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(b)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return ss.Client.Put(context.Background(), file.Name(), ss.Name)
+}
+
+func (ss *SdfsStore) Load() (*ClusterState, error) {
+	file, err := os.CreateTemp("", ss.Name)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	err = ss.Client.Get(context.Background(), ss.Name, file.Name())
+	if errors.Is(err, sdfs.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(file.Name())
+	if err != nil {
+		return nil, err
+	}
+	state := &ClusterState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,390 @@
+package driver
+
+import (
+	"crane/bolt"
+	"crane/core/utils"
+	"crane/spout"
+	"crane/topology"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Running topology, the driver schedules, snapshots and restores
+// every topology separately
+type TopologyState struct {
+	Id                    string
+	Topo                  *topology.Topology
+	TopologyGraph         map[string][]interface{}
+	SpoutMap              map[string]spout.SpoutInst
+	BoltMap               map[string]bolt.BoltInst
+	Hosts                 []string
+	SnapshotResponseCount int
+	TaskSum               int
+	TaskHostSum           int
+	SnapshotVersion       int
+	CompletedVersion      int
+	SnapshotInterval      int
+	SnapshotInFlight      bool
+	Active                bool
+	Building              bool
+	StateInstNum          map[string]int
+	Placements            []utils.TaskPlacement
+	// Streams and grouping fields subscribed by the successors of every
+	// component, and the acker, kept for the tasks moved on failures
+	SuccStreams map[string]map[string][]string
+	SuccIndexes map[string]map[string]map[string][]int
+	AckerAddr   string
+	AckerHost   string
+	// Supervisors failed since the last recovery, and its timer
+	FailedHosts  []string
+	RestoreTimer *time.Timer
+	LockRecover  sync.Mutex
+	// Guards the placements, hosts, activity and snapshot progress, the
+	// driver serves the topology from several goroutines at once
+	LockState sync.RWMutex
+}
+
+// Factory mode to return the TopologyState instance
+func NewTopologyState(id string, topo *topology.Topology) *TopologyState {
+	ts := &TopologyState{}
+	ts.Id = id
+	ts.Topo = topo
+	ts.Hosts = make([]string, 0)
+	ts.Placements = make([]utils.TaskPlacement, 0)
+	ts.FailedHosts = make([]string, 0)
+	ts.SnapshotResponseCount = 0
+	ts.SnapshotVersion = 0
+	ts.CompletedVersion = 0
+	ts.SnapshotInterval = 30
+	ts.Active = true
+	// the number of tasks of every bolt when the last snapshot was taken
+	ts.StateInstNum = make(map[string]int)
+	for _, b := range topo.Bolts {
+		ts.StateInstNum[b.Name] = b.InstNum
+	}
+	return ts
+}
+
+// Placements of the tasks, copied under the lock
+func (ts *TopologyState) placements() []utils.TaskPlacement {
+	ts.LockState.RLock()
+	defer ts.LockState.RUnlock()
+	return append([]utils.TaskPlacement{}, ts.Placements...)
+}
+
+// Supervisors with tasks of the topology, copied under the lock
+func (ts *TopologyState) hosts() []string {
+	ts.LockState.RLock()
+	defer ts.LockState.RUnlock()
+	return append([]string{}, ts.Hosts...)
+}
+
+// Whether the spouts of the topology run
+func (ts *TopologyState) active() bool {
+	ts.LockState.RLock()
+	defer ts.LockState.RUnlock()
+	return ts.Active
+}
+
+// Whether the workers of the topology are being built or moved
+func (ts *TopologyState) building() bool {
+	ts.LockState.RLock()
+	defer ts.LockState.RUnlock()
+	return ts.Building
+}
+
+func (ts *TopologyState) setBuilding(building bool) {
+	ts.LockState.Lock()
+	defer ts.LockState.Unlock()
+	ts.Building = building
+}
+
+// Start building the topology, false if it is being built already
+func (ts *TopologyState) startBuilding() bool {
+	ts.LockState.Lock()
+	defer ts.LockState.Unlock()
+	if ts.Building {
+		return false
+	}
+	ts.Building = true
+	return true
+}
+
+// Tasks placed on a supervisor, with the ports allocated to them
+type placement struct {
+	supervisor string
+	tasks      []interface{}
+	ports      []int
+}
+
+// Register the topology with a new id made of its name
+// and a sequence number, e.g. wordcount-1
+func (d *Driver) AddTopology(topo *topology.Topology) *TopologyState {
+	d.LockTopo.Lock()
+	defer d.LockTopo.Unlock()
+	name := topo.Name
+	if name == "" {
+		name = "topology"
+	}
+	d.TopologySeq++
+	ts := NewTopologyState(fmt.Sprintf("%s-%d", name, d.TopologySeq), topo)
+	d.Topologies[ts.Id] = ts
+	return ts
+}
+
+// Get the running topology of the id, nil if there is none
+func (d *Driver) GetTopology(id string) *TopologyState {
+	d.LockTopo.RLock()
+	defer d.LockTopo.RUnlock()
+	return d.Topologies[id]
+}
+
+// Remove the topology, it is not restored any more
+func (d *Driver) RemoveTopology(id string) {
+	d.LockTopo.Lock()
+	defer d.LockTopo.Unlock()
+	delete(d.Topologies, id)
+}
+
+// Allocate the lowest port of the supervisor's range free of other tasks,
+// the tasks of all topologies on a supervisor have distinct ports. The
+// resources required by the task are used until the port is released
+func (d *Driver) AllocatePort(supervisor string, topologyId string, resources utils.Resources) (int, error) {
+	d.LockPort.Lock()
+	defer d.LockPort.Unlock()
+	if d.PortMap[supervisor] == nil {
+		d.PortMap[supervisor] = make(map[int]string)
+		d.PortResources[supervisor] = make(map[int]utils.Resources)
+	}
+	first, last := d.portRange(supervisor)
+	for port := first; port <= last; port++ {
+		if d.portUsed(supervisor, port, "") {
+			continue
+		}
+		d.PortMap[supervisor][port] = topologyId
+		d.PortResources[supervisor][port] = resources
+		return port, nil
+	}
+	return 0, fmt.Errorf("supervisor %s has no free port in %d-%d", supervisor, first, last)
+}
+
+// First and last ports the workers of the supervisor listen on
+func (d *Driver) portRange(supervisor string) (int, int) {
+	if ports, ok := d.PortRanges[supervisor]; ok {
+		return ports[0], ports[1]
+	}
+	return utils.CONTRACTOR_BASE_PORT, utils.CONTRACTOR_BASE_PORT + utils.SUPERVISOR_PORTS - 1
+}
+
+// Whether the port is allocated on the host of the supervisor to another
+// topology than the excluded one, several supervisors may run on the same
+// host, e.g. in a local cluster
+func (d *Driver) portUsed(supervisor string, port int, excluded string) bool {
+	host := d.host(supervisor)
+	for id, ports := range d.PortMap {
+		if owner := ports[port]; owner != "" && owner != excluded && d.host(id) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Release the ports allocated to the tasks of the topology
+func (d *Driver) ReleasePorts(topologyId string) {
+	d.LockPort.Lock()
+	defer d.LockPort.Unlock()
+	for supervisor, ports := range d.PortMap {
+		for port, id := range ports {
+			if id == topologyId {
+				delete(ports, port)
+				delete(d.PortResources[supervisor], port)
+			}
+		}
+	}
+}
+
+// Record the capacity reported by the supervisor joining, the supervisors
+// not reporting it run the default number of the default tasks. The host
+// and the ports it advertises are recorded too
+func (d *Driver) SetCapacity(supervisor string, join *utils.JoinRequest) {
+	d.LockPort.Lock()
+	defer d.LockPort.Unlock()
+	d.setCapacity(supervisor, join)
+	if join.Host != "" {
+		d.Hosts[supervisor] = join.Host
+	}
+	if join.PortMin > 0 && join.PortMax >= join.PortMin {
+		d.PortRanges[supervisor] = [2]int{join.PortMin, join.PortMax}
+	}
+	// The failure detector counts the timeout from the join
+	if _, ok := d.LastHeartbeat[supervisor]; !ok {
+		d.LastHeartbeat[supervisor] = time.Now()
+	}
+}
+
+func (d *Driver) setCapacity(supervisor string, join *utils.JoinRequest) {
+	slots := join.Slots
+	if slots <= 0 {
+		slots = utils.SUPERVISOR_SLOTS
+	}
+	capacity := join.Capacity
+	if capacity.CPU <= 0 || capacity.Memory <= 0 {
+		capacity = utils.TaskResources(0, 0)
+		capacity.CPU *= float64(slots)
+		capacity.Memory *= slots
+	}
+	d.Slots[supervisor] = slots
+	d.Capacity[supervisor] = capacity
+}
+
+// Host the workers of the supervisor listen on, the one it advertises
+// or the host of its connection
+func (d *Driver) Host(supervisor string) string {
+	d.LockPort.Lock()
+	defer d.LockPort.Unlock()
+	return d.host(supervisor)
+}
+
+func (d *Driver) host(supervisor string) string {
+	if host, ok := d.Hosts[supervisor]; ok {
+		return host
+	}
+	host, _, _ := net.SplitHostPort(supervisor)
+	return host
+}
+
+// Number of the task slots free on the supervisor
+func (d *Driver) FreeSlots(supervisor string) int {
+	d.LockPort.Lock()
+	defer d.LockPort.Unlock()
+	return d.freeSlots(supervisor, "")
+}
+
+func (d *Driver) freeSlots(supervisor string, excluded string) int {
+	slots, ok := d.Slots[supervisor]
+	if !ok {
+		slots = utils.SUPERVISOR_SLOTS
+	}
+	for _, id := range d.PortMap[supervisor] {
+		if id != excluded {
+			slots--
+		}
+	}
+	// Every task needs a free port of the range
+	first, last := d.portRange(supervisor)
+	ports := 0
+	for port := first; port <= last; port++ {
+		if !d.portUsed(supervisor, port, excluded) {
+			ports++
+		}
+	}
+	if ports < slots {
+		slots = ports
+	}
+	return slots
+}
+
+// Resources of the supervisor not used by the tasks, the tasks of the
+// excluded topology are not counted as it is to be rescheduled
+func (d *Driver) available(supervisor string, excluded string) utils.Resources {
+	available := d.Capacity[supervisor]
+	for port, res := range d.PortResources[supervisor] {
+		if d.PortMap[supervisor][port] != excluded {
+			available = available.Sub(res)
+		}
+	}
+	return available
+}
+
+// Supervisors joined, copied under the lock
+func (d *Driver) supervisors() []string {
+	d.LockSIM.RLock()
+	defer d.LockSIM.RUnlock()
+	return append([]string{}, d.SupervisorIdMap...)
+}
+
+// Offers of all the supervisors joined to the scheduler
+func (d *Driver) Offers(excluded string) []SupervisorOffer {
+	supervisors := d.supervisors()
+	d.LockPort.Lock()
+	defer d.LockPort.Unlock()
+	offers := make([]SupervisorOffer, 0)
+	for _, supervisor := range supervisors {
+		offers = append(offers, SupervisorOffer{
+			Id:        supervisor,
+			FreeSlots: d.freeSlots(supervisor, excluded),
+			Capacity:  d.Capacity[supervisor],
+			Available: d.available(supervisor, excluded),
+			Used:      d.Heartbeats[supervisor].Used,
+		})
+	}
+	return offers
+}
+
+// Task instances of the topology in the DFS order from the spouts,
+// every spout or bolt once per instance
+func taskInstances(topo *topology.Topology) []interface{} {
+	succs := make(map[string][]*bolt.BoltInst)
+	for i := range topo.Bolts {
+		subscribed := make(map[string]bool)
+		for _, prev := range topo.Bolts[i].PrevTaskNames {
+			if !subscribed[prev] {
+				subscribed[prev] = true
+				succs[prev] = append(succs[prev], &topo.Bolts[i])
+			}
+		}
+	}
+	visited := make(map[string]bool)
+	instances := make([]interface{}, 0)
+	var visit func(name string)
+	visit = func(name string) {
+		for _, b := range succs[name] {
+			if visited[b.Name] {
+				continue
+			}
+			visited[b.Name] = true
+			for i := 0; i < b.InstNum; i++ {
+				instances = append(instances, b)
+			}
+			visit(b.Name)
+		}
+	}
+	for i := range topo.Spouts {
+		s := &topo.Spouts[i]
+		if visited[s.Name] {
+			continue
+		}
+		visited[s.Name] = true
+		for j := 0; j < s.InstNum; j++ {
+			instances = append(instances, s)
+		}
+		visit(s.Name)
+	}
+	return instances
+}
+
+// Schedule the tasks of the topology on the supervisors with its scheduler,
+// the index of the offer of every task instance is returned
+func (d *Driver) Schedule(topo *topology.Topology, topologyId string) ([]interface{}, []int, []SupervisorOffer, error) {
+	offers := d.Offers(topologyId)
+	if len(offers) == 0 {
+		return nil, nil, nil, fmt.Errorf("no supervisor joined the cluster")
+	}
+	instances := taskInstances(topo)
+	requests := make([]TaskRequest, 0)
+	for _, inst := range instances {
+		switch c := inst.(type) {
+		case *spout.SpoutInst:
+			requests = append(requests, TaskRequest{Component: c.Name, Resources: utils.TaskResources(c.CPU, c.Memory)})
+		case *bolt.BoltInst:
+			requests = append(requests, TaskRequest{Component: c.Name, Resources: utils.TaskResources(c.CPU, c.Memory)})
+		}
+	}
+	assignment, err := NewScheduler(topo.Scheduler).Schedule(requests, offers)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return instances, assignment, offers, nil
+}
@@ -0,0 +1,129 @@
+package local
+
+import (
+	"crane/core/client"
+	"crane/core/driver"
+	"crane/core/supervisor"
+	"crane/core/utils"
+	"crane/topology"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Time for the supervisors to join the driver, and for the tasks of
+// a topology to run
+const (
+	JOIN_TIMEOUT  = 10 * time.Second
+	START_TIMEOUT = 60 * time.Second
+)
+
+// LocalCluster runs a driver and its supervisors in one process, to try
+// topologies and to run integration tests. The spouts and bolts are Go
+// values registered in the process instead of plugins, and the states
+// are kept in memory, so neither the VMs nor SDFS are needed
+type LocalCluster struct {
+	Driver      *driver.Driver
+	Supervisors []*supervisor.Supervisor
+	Client      *client.Client
+	Addr        string
+}
+
+// Factory mode to return the LocalCluster instance with n supervisors,
+// the driver listens on a free local port
+func NewLocalCluster(n int) (*LocalCluster, error) {
+	lc := &LocalCluster{}
+	lc.Driver = driver.NewDriver("127.0.0.1:0")
+	if lc.Driver.Pub == nil {
+		return nil, errors.New("driver failed to listen")
+	}
+	lc.Addr = lc.Driver.Pub.Listener.Addr().String()
+	go lc.Driver.StartDaemon()
+
+	lc.Supervisors = make([]*supervisor.Supervisor, 0)
+	for i := 0; i < n; i++ {
+		s := supervisor.NewSupervisor([]string{lc.Addr}, "")
+		if s == nil {
+			lc.Shutdown()
+			return nil, fmt.Errorf("supervisor %d failed to connect the driver", i)
+		}
+		// Only the slots limit the tasks of a local supervisor
+		s.Slots = utils.SUPERVISOR_SLOTS
+		s.Capacity = utils.Resources{
+			CPU:    utils.SUPERVISOR_SLOTS * utils.DEFAULT_TASK_CPU,
+			Memory: utils.SUPERVISOR_SLOTS * utils.DEFAULT_TASK_MEMORY,
+		}
+		lc.Supervisors = append(lc.Supervisors, s)
+		go s.StartDaemon()
+	}
+	deadline := time.Now().Add(JOIN_TIMEOUT)
+	for len(lc.Driver.Offers("")) < n {
+		if time.Now().After(deadline) {
+			lc.Shutdown()
+			return nil, fmt.Errorf("supervisors did not join in %v", JOIN_TIMEOUT)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	lc.Client = client.NewClient(lc.Addr)
+	if lc.Client == nil {
+		lc.Shutdown()
+		return nil, errors.New("client failed to connect the driver")
+	}
+	lc.Client.Start()
+	return lc, nil
+}
+
+// Submit the topology and wait for all its tasks to run. Its states are
+// kept in memory unless it selects the local backend, as there is no SDFS
+func (lc *LocalCluster) Submit(topo *topology.Topology) (string, error) {
+	if topo.StateBackend == utils.STATE_BACKEND_SDFS || topo.StateBackend == "" {
+		topo.StateBackend = utils.STATE_BACKEND_MEMORY
+	}
+	id, err := lc.Client.Submit(*topo)
+	if err != nil {
+		return "", err
+	}
+	return id, lc.WaitRunning(id, START_TIMEOUT)
+}
+
+// Wait for all the tasks of the topology to run, by the heartbeats
+// of the supervisors
+func (lc *LocalCluster) WaitRunning(id string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		desc, err := lc.Client.DescribeTopology(id)
+		if err != nil {
+			return err
+		}
+		running := len(desc.Tasks) > 0
+		for _, task := range desc.Tasks {
+			if task.Status.State != utils.WORKER_RUNNING {
+				running = false
+			}
+		}
+		if running {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("tasks of topology %s are not running in %v", id, timeout)
+		}
+		time.Sleep(utils.HEARTBEAT_INTERVAL * time.Second)
+	}
+}
+
+// Kill the topology
+func (lc *LocalCluster) Kill(id string) error {
+	return lc.Client.Kill(id)
+}
+
+// Stop the supervisors with their workers and the driver
+func (lc *LocalCluster) Shutdown() {
+	for _, s := range lc.Supervisors {
+		s.Stop()
+	}
+	lc.Driver.Stop()
+	if lc.Client != nil {
+		lc.Client.Sub.Conn.Close()
+	}
+}
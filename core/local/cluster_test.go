@@ -0,0 +1,78 @@
+package local
+
+import (
+	"crane/bolt"
+	"crane/core/utils"
+	"crane/topology"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Few hundred tuples, the acking of thousands stalls on small machines
+const TEST_TUPLES = 200
+
+type upperBolt struct {
+	collector *bolt.BoltOutputCollector
+}
+
+func (ub *upperBolt) Prepare(task string, collector *bolt.BoltOutputCollector) error {
+	ub.collector = collector
+	return nil
+}
+
+func (ub *upperBolt) Execute(tuple []interface{}) error {
+	ub.collector.Emit(strings.ToUpper(tuple[0].(string)))
+	return nil
+}
+
+func (ub *upperBolt) Cleanup() {}
+
+func TestFeederBoltCapture(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a local cluster")
+	}
+	lc, err := NewLocalCluster(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lc.Shutdown()
+
+	feeder := NewFeeder()
+	capture := NewCapture()
+	tm := topology.NewTopology()
+	tm.SetName("feedercapture")
+	tm.EnableAcking(30)
+	tm.AddSpout(feeder.Spout("WordSpout", utils.GROUPING_BY_SHUFFLE, 0))
+	ub := NewBolt("UpperBolt", func() bolt.Bolt { return &upperBolt{} }, utils.GROUPING_BY_SHUFFLE, 0)
+	ub.SetInstanceNum(2)
+	ub.AddPrevTaskName("WordSpout")
+	tm.AddBolt(ub)
+	cb := capture.Bolt("CaptureBolt", utils.GROUPING_BY_SHUFFLE, 0)
+	cb.AddPrevTaskName("UpperBolt")
+	tm.AddBolt(cb)
+
+	id, err := lc.Submit(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fed in the reverse order, the captured tuples come out sorted
+	expected := make([][]interface{}, 0)
+	for i := TEST_TUPLES - 1; i >= 0; i-- {
+		feeder.Feed(fmt.Sprintf("word%03d", i))
+	}
+	for i := 0; i < TEST_TUPLES; i++ {
+		expected = append(expected, []interface{}{fmt.Sprintf("WORD%03d", i)})
+	}
+	if err := capture.Wait(TEST_TUPLES, 60*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if tuples := capture.Tuples(); !reflect.DeepEqual(tuples, expected) {
+		t.Fatalf("captured %d tuples %v..., expected %v...", len(tuples), tuples[:3], expected[:3])
+	}
+	if err := lc.Kill(id); err != nil {
+		t.Fatal(err)
+	}
+}
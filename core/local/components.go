@@ -0,0 +1,171 @@
+package local
+
+import (
+	"crane/bolt"
+	"crane/core/utils"
+	"crane/spout"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Time the spout of a feeder waits for a tuple in a NextTuple call
+const FEED_POLL_INTERVAL = 10 * time.Millisecond
+
+// Sequence of the registered symbols, a symbol is registered per
+// component so that the clusters of a process do not clash
+var symbolSeq int64
+
+func register(name string, symbol interface{}) string {
+	symbolName := fmt.Sprintf("%s#%d", name, atomic.AddInt64(&symbolSeq, 1))
+	utils.RegisterSymbol(symbolName, symbol)
+	return symbolName
+}
+
+// Spout component run from the factory in this process, every task gets
+// its own instance as with a plugin
+func NewSpout(name string, newSpout func() spout.Spout, grouping string, mainField int) *spout.SpoutInst {
+	return spout.NewSpoutInst(name, utils.LOCAL_PLUGIN, register(name, newSpout), grouping, mainField)
+}
+
+// Bolt component run from the factory in this process
+func NewBolt(name string, newBolt func() bolt.Bolt, grouping string, mainField int) *bolt.BoltInst {
+	return bolt.NewBoltInst(name, utils.LOCAL_PLUGIN, register(name, newBolt), grouping, mainField)
+}
+
+// Sink component run from the factory in this process
+func NewSink(name string, newSink func() bolt.Sink, grouping string, mainField int) *bolt.BoltInst {
+	return bolt.NewBoltInst(name, utils.LOCAL_PLUGIN, register(name, newSink), grouping, mainField)
+}
+
+// Feeder feeds the tuples of a test into the topology, through the tasks
+// of its spout
+type Feeder struct {
+	tuples chan []interface{}
+}
+
+// Factory mode to return the Feeder instance
+func NewFeeder() *Feeder {
+	return &Feeder{tuples: make(chan []interface{}, 1024)}
+}
+
+// Feed a tuple, the spout emits it once a task of it runs
+func (f *Feeder) Feed(values ...interface{}) {
+	f.tuples <- values
+}
+
+// Spout component emitting the fed tuples, the tasks share them
+func (f *Feeder) Spout(name string, grouping string, mainField int) *spout.SpoutInst {
+	return NewSpout(name, func() spout.Spout {
+		return &feederSpout{tuples: f.tuples}
+	}, grouping, mainField)
+}
+
+type feederSpout struct {
+	tuples    chan []interface{}
+	collector *spout.SpoutOutputCollector
+}
+
+func (fs *feederSpout) Open(task string, collector *spout.SpoutOutputCollector) error {
+	fs.collector = collector
+	return nil
+}
+
+func (fs *feederSpout) NextTuple() error {
+	select {
+	case values := <-fs.tuples:
+		fs.collector.Emit(values...)
+		return nil
+	case <-time.After(FEED_POLL_INTERVAL):
+		return errors.New("no tuple fed")
+	}
+}
+
+func (fs *feederSpout) Close() {}
+
+// Capture records the tuples its bolt receives, for a test to assert the
+// outputs of the topology. The tuples arrive encoded as JSON by default,
+// so the numbers are float64 unless the bolt selects the binary codec.
+// A tuple replayed by the acking arrives again
+type Capture struct {
+	tuples [][]interface{}
+	mutex  sync.Mutex
+}
+
+// Factory mode to return the Capture instance
+func NewCapture() *Capture {
+	return &Capture{tuples: make([][]interface{}, 0)}
+}
+
+// Bolt component recording the tuples into the capture
+func (c *Capture) Bolt(name string, grouping string, mainField int) *bolt.BoltInst {
+	return NewBolt(name, func() bolt.Bolt {
+		return &captureBolt{capture: c}
+	}, grouping, mainField)
+}
+
+// The tuples received so far, sorted by their JSON encoding so that
+// the order does not depend on the scheduling of the tasks
+func (c *Capture) Tuples() [][]interface{} {
+	c.mutex.Lock()
+	tuples := make([][]interface{}, len(c.tuples))
+	copy(tuples, c.tuples)
+	c.mutex.Unlock()
+	keys := make([]string, len(tuples))
+	for i, tuple := range tuples {
+		b, _ := json.Marshal(tuple)
+		keys[i] = string(b)
+	}
+	sort.Sort(byKey{tuples, keys})
+	return tuples
+}
+
+type byKey struct {
+	tuples [][]interface{}
+	keys   []string
+}
+
+func (bk byKey) Len() int           { return len(bk.tuples) }
+func (bk byKey) Less(i, j int) bool { return bk.keys[i] < bk.keys[j] }
+func (bk byKey) Swap(i, j int) {
+	bk.tuples[i], bk.tuples[j] = bk.tuples[j], bk.tuples[i]
+	bk.keys[i], bk.keys[j] = bk.keys[j], bk.keys[i]
+}
+
+// Wait until n tuples are received, the error tells how many arrived
+func (c *Capture) Wait(n int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		c.mutex.Lock()
+		received := len(c.tuples)
+		c.mutex.Unlock()
+		if received >= n {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("received %d of %d tuples in %v", received, n, timeout)
+		}
+		time.Sleep(FEED_POLL_INTERVAL)
+	}
+}
+
+type captureBolt struct {
+	capture *Capture
+}
+
+func (cb *captureBolt) Prepare(task string, collector *bolt.BoltOutputCollector) error {
+	return nil
+}
+
+func (cb *captureBolt) Execute(tuple []interface{}) error {
+	cb.capture.mutex.Lock()
+	defer cb.capture.mutex.Unlock()
+	cb.capture.tuples = append(cb.capture.tuples, tuple)
+	return nil
+}
+
+func (cb *captureBolt) Cleanup() {}
@@ -0,0 +1,154 @@
+package messages
+
+import (
+	"crane/core/utils"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// First byte of a batch of messages, no JSON document or tuple of the
+// codecs starts with it. The messages follow, each framed by its length
+const BATCH_MAGIC = 0xBA
+
+var errShortBatch = errors.New("batch is truncated")
+
+// Batcher gathers the messages to every connection into batches, a batch
+// is published as one frame, so a busy edge takes one write for many
+// tuples. It is used by the goroutine sending the tuples only
+type Batcher struct {
+	board   chan Message
+	config  utils.BatchConfig
+	batches map[string]*batch
+	timer   *time.Timer
+	// Whether the linger timer runs for the batches pending
+	lingering bool
+}
+
+// Messages waiting for the connection
+type batch struct {
+	payload []byte
+	count   int
+	// The message alone, sent as is if no other joins it
+	first []byte
+}
+
+// Factory mode to return the Batcher instance, publishing the batches
+// to the publish board
+func NewBatcher(board chan Message, config utils.BatchConfig) *Batcher {
+	return &Batcher{
+		board:   board,
+		config:  config,
+		batches: make(map[string]*batch),
+	}
+}
+
+// Add the message to the batch of its connection. The batch is published
+// once it is full, and a message is published alone without batching
+func (b *Batcher) Add(message Message) {
+	if b.config.MaxTuples <= 1 {
+		b.board <- message
+		return
+	}
+	maxBytes := b.config.MaxBytes
+	if maxBytes <= 0 || maxBytes > MAX_FRAME_SIZE {
+		maxBytes = MAX_FRAME_SIZE
+	}
+	bt := b.batches[message.TargetConnId]
+	if bt != nil && len(bt.payload)+4+len(message.Payload) > maxBytes {
+		b.publish(message.TargetConnId, bt)
+		bt = nil
+	}
+	if bt == nil {
+		bt = &batch{payload: []byte{BATCH_MAGIC}, first: message.Payload}
+		b.batches[message.TargetConnId] = bt
+	}
+	bt.payload = binary.BigEndian.AppendUint32(bt.payload, uint32(len(message.Payload)))
+	bt.payload = append(bt.payload, message.Payload...)
+	bt.count++
+	if bt.count >= b.config.MaxTuples {
+		b.publish(message.TargetConnId, bt)
+	}
+	if len(b.batches) > 0 && !b.lingering && b.config.Linger > 0 {
+		b.lingering = true
+		linger := time.Duration(b.config.Linger) * time.Millisecond
+		if b.timer == nil {
+			b.timer = time.NewTimer(linger)
+		} else {
+			b.timer.Reset(linger)
+		}
+	}
+}
+
+// Publish the batches of all the connections
+func (b *Batcher) Flush() {
+	for connId, bt := range b.batches {
+		b.publish(connId, bt)
+	}
+	if b.lingering {
+		b.lingering = false
+		if !b.timer.Stop() {
+			select {
+			case <-b.timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// Publish the batches if they do not wait for more messages, called when
+// the sender has no more messages queued
+func (b *Batcher) Idle() {
+	if b.config.Linger <= 0 {
+		b.Flush()
+	}
+}
+
+// Channel receiving once the linger of the oldest batch passed, the sender
+// flushes the batches then. Nil while no batch lingers
+func (b *Batcher) Expired() <-chan time.Time {
+	if !b.lingering {
+		return nil
+	}
+	return b.timer.C
+}
+
+// Called by the sender receiving from Expired
+func (b *Batcher) Expire() {
+	b.lingering = false
+	b.Flush()
+}
+
+func (b *Batcher) publish(connId string, bt *batch) {
+	delete(b.batches, connId)
+	payload := bt.payload
+	if bt.count == 1 {
+		payload = bt.first
+	}
+	b.board <- Message{
+		Payload:      payload,
+		TargetConnId: connId,
+	}
+}
+
+// The messages of the payload published by a batcher, in their order.
+// A payload of a single message is returned as is
+func Unbatch(payload []byte) ([][]byte, error) {
+	if len(payload) == 0 || payload[0] != BATCH_MAGIC {
+		return [][]byte{payload}, nil
+	}
+	messages := make([][]byte, 0)
+	b := payload[1:]
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errShortBatch
+		}
+		size := binary.BigEndian.Uint32(b)
+		if uint64(size) > uint64(len(b)-4) {
+			return nil, errShortBatch
+		}
+		messages = append(messages, b[4:4+size])
+		b = b[4+size:]
+	}
+	return messages, nil
+}
@@ -0,0 +1,273 @@
+package messages
+
+import (
+	"crane/core/codec"
+	"crane/core/utils"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Messages published to the board so far
+func published(board chan Message) []Message {
+	messages := make([]Message, 0)
+	for {
+		select {
+		case message := <-board:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+func payloadOf(i int) []byte {
+	return []byte(fmt.Sprintf("tuple%05d", i))
+}
+
+// Messages of the batches published, in order
+func unbatchAll(t *testing.T, batches []Message) []string {
+	all := make([]string, 0)
+	for _, batch := range batches {
+		messages, err := Unbatch(batch.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, message := range messages {
+			all = append(all, string(message))
+		}
+	}
+	return all
+}
+
+func TestBatcherMaxTuples(t *testing.T) {
+	board := make(chan Message, CHANNEL_SIZE)
+	b := NewBatcher(board, utils.BatchConfig{MaxTuples: 3})
+	for i := 0; i < 7; i++ {
+		b.Add(Message{Payload: payloadOf(i), TargetConnId: "a"})
+	}
+	batches := published(board)
+	if len(batches) != 2 {
+		t.Fatalf("published %d batches of 6 tuples, expected 2", len(batches))
+	}
+	b.Flush()
+	batches = append(batches, published(board)...)
+	// The last tuple alone goes as is
+	if len(batches) != 3 || string(batches[2].Payload) != string(payloadOf(6)) {
+		t.Fatalf("flushed %v, expected the last tuple alone", batches[2:])
+	}
+	got := unbatchAll(t, batches)
+	for i := range got {
+		if got[i] != string(payloadOf(i)) {
+			t.Fatalf("tuple %d is %s", i, got[i])
+		}
+	}
+}
+
+func TestBatcherMaxBytes(t *testing.T) {
+	board := make(chan Message, CHANNEL_SIZE)
+	// The magic byte and two framed payloads of 10 bytes
+	b := NewBatcher(board, utils.BatchConfig{MaxTuples: 100, MaxBytes: 1 + 2*(4+10)})
+	for i := 0; i < 5; i++ {
+		b.Add(Message{Payload: payloadOf(i), TargetConnId: "a"})
+	}
+	batches := published(board)
+	if len(batches) != 2 {
+		t.Fatalf("published %d batches, expected 2 of 2 tuples", len(batches))
+	}
+	for _, batch := range batches {
+		if len(batch.Payload) > 1+2*(4+10) {
+			t.Fatalf("batch of %d bytes over the max", len(batch.Payload))
+		}
+	}
+	b.Flush()
+	if got := unbatchAll(t, append(batches, published(board)...)); len(got) != 5 {
+		t.Fatalf("unbatched %d tuples, expected 5", len(got))
+	}
+}
+
+func TestBatcherLinger(t *testing.T) {
+	board := make(chan Message, CHANNEL_SIZE)
+	b := NewBatcher(board, utils.BatchConfig{MaxTuples: 100, Linger: 20})
+	if b.Expired() != nil {
+		t.Fatal("lingers without a batch")
+	}
+	started := time.Now()
+	b.Add(Message{Payload: payloadOf(0), TargetConnId: "a"})
+	b.Add(Message{Payload: payloadOf(1), TargetConnId: "b"})
+	// Idle does not send the batches waiting for more tuples
+	b.Idle()
+	if batches := published(board); len(batches) != 0 {
+		t.Fatalf("published %d batches before the linger", len(batches))
+	}
+	select {
+	case <-b.Expired():
+		b.Expire()
+	case <-time.After(time.Second):
+		t.Fatal("linger did not expire")
+	}
+	if waited := time.Since(started); waited < 20*time.Millisecond {
+		t.Fatalf("linger expired after %v", waited)
+	}
+	batches := published(board)
+	if len(batches) != 2 || b.Expired() != nil {
+		t.Fatalf("published %d batches after the linger, expected one per connection", len(batches))
+	}
+
+	// No linger, the batches go once the sender is idle
+	b = NewBatcher(board, utils.BatchConfig{MaxTuples: 100})
+	b.Add(Message{Payload: payloadOf(0), TargetConnId: "a"})
+	if b.Expired() != nil {
+		t.Fatal("lingers with no linger")
+	}
+	b.Idle()
+	if batches := published(board); len(batches) != 1 {
+		t.Fatalf("published %d batches when idle, expected 1", len(batches))
+	}
+}
+
+func TestBatcherDisabled(t *testing.T) {
+	board := make(chan Message, CHANNEL_SIZE)
+	b := NewBatcher(board, utils.BatchConfig{})
+	b.Add(Message{Payload: payloadOf(0), TargetConnId: "a"})
+	batches := published(board)
+	if len(batches) != 1 || string(batches[0].Payload) != string(payloadOf(0)) {
+		t.Fatalf("published %v, expected the tuple as is", batches)
+	}
+}
+
+// The barrier of a snapshot follows the tuples batched before it and
+// precedes the ones after it, the way the workers send it
+func TestUnbatchBarrierOrder(t *testing.T) {
+	board := make(chan Message, CHANNEL_SIZE)
+	b := NewBatcher(board, utils.BatchConfig{MaxTuples: 100, Linger: 1000})
+	c := codec.BinaryCodec{}
+	send := func(tuple utils.TupleMessage) {
+		payload, err := c.Encode(tuple)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Add(Message{Payload: payload, TargetConnId: "a"})
+	}
+	for i := 0; i < 5; i++ {
+		send(utils.TupleMessage{Values: []interface{}{int64(i)}})
+	}
+	send(utils.TupleMessage{Barrier: 1})
+	b.Flush()
+	for i := 5; i < 8; i++ {
+		send(utils.TupleMessage{Values: []interface{}{int64(i)}})
+	}
+	b.Flush()
+
+	batches := published(board)
+	if len(batches) != 2 {
+		t.Fatalf("published %d batches, expected the one closed by the barrier and the next", len(batches))
+	}
+	next := int64(0)
+	for n, batch := range batches {
+		messages, err := Unbatch(batch.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, message := range messages {
+			var tuple utils.TupleMessage
+			if err := codec.Decode(message, &tuple); err != nil {
+				t.Fatal(err)
+			}
+			if tuple.Barrier > 0 {
+				if n != 0 || i != len(messages)-1 || next != 5 {
+					t.Fatalf("barrier at %d of batch %d after %d tuples", i, n, next)
+				}
+				continue
+			}
+			if tuple.Values[0] != next {
+				t.Fatalf("tuple %v, expected %d", tuple.Values, next)
+			}
+			next++
+		}
+	}
+	if next != 8 {
+		t.Fatalf("unbatched %d tuples, expected 8", next)
+	}
+}
+
+func TestUnbatchInvalid(t *testing.T) {
+	single := []byte(`{"Values":[1]}`)
+	if messages, err := Unbatch(single); err != nil || len(messages) != 1 || string(messages[0]) != string(single) {
+		t.Fatalf("unbatched a single message into %q: %v", messages, err)
+	}
+	truncated := []byte{BATCH_MAGIC, 0, 0, 0, 5, 'a', 'b'}
+	if _, err := Unbatch(truncated); err == nil {
+		t.Fatal("unbatched a truncated batch")
+	}
+	if _, err := Unbatch([]byte{BATCH_MAGIC, 0, 0}); err == nil {
+		t.Fatal("unbatched a truncated length")
+	}
+}
+
+// Throughput of a link between two workers, a publisher sending the
+// tuples to a subscriber over TCP on this machine, for every batch size.
+// tools/linkbench reports the same for a fixed number of tuples
+func BenchmarkLink(b *testing.B) {
+	// The subscribers log every frame they receive
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, c := range []codec.Codec{codec.JsonCodec{}, codec.BinaryCodec{}} {
+		for _, n := range []int{1, 10, 100, 1000} {
+			b.Run(fmt.Sprintf("%s/batch=%d", c.Name(), n), func(b *testing.B) {
+				benchmarkLink(b, c, utils.BatchConfig{MaxTuples: n})
+			})
+		}
+	}
+}
+
+func benchmarkLink(b *testing.B, c codec.Codec, batch utils.BatchConfig) {
+	pub := NewPublisher("127.0.0.1:0")
+	if pub == nil {
+		b.Fatal("listen failed")
+	}
+	defer pub.Close()
+	go pub.AcceptConns()
+	go pub.PublishMessage(pub.PublishBoard)
+	sub := NewSubscriber(pub.Listener.Addr().String())
+	if sub == nil {
+		b.Fatal("connect failed")
+	}
+	defer sub.Conn.Close()
+	go sub.ReadMessage()
+	connId := sub.Conn.LocalAddr().String()
+	for pub.Pool.Get(connId) == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	value := strings.Repeat("x", 64)
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			var tuple utils.TupleMessage
+			if err := codec.Decode((<-sub.PublishBoard).Payload, &tuple); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	b.ResetTimer()
+	batcher := NewBatcher(pub.PublishBoard, batch)
+	for i := 0; i < b.N; i++ {
+		payload, err := c.Encode(utils.TupleMessage{Values: []interface{}{value, int64(i)}})
+		if err != nil {
+			b.Fatal(err)
+		}
+		batcher.Add(Message{Payload: payload, TargetConnId: connId})
+	}
+	batcher.Flush()
+	if err := <-done; err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,107 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Every message is framed by the length of its payload, a 4 byte big
+// endian prefix, so a payload of any bytes and any size up to the max
+// arrives whole. The peers agree on the protocol version first
+const (
+	// Versions of the protocol this process speaks
+	PROTOCOL_VERSION_MIN = 1
+	PROTOCOL_VERSION     = 1
+	// Max size of a payload, a larger frame breaks the connection
+	MAX_FRAME_SIZE    = 64 << 20
+	HANDSHAKE_TIMEOUT = 5 * time.Second
+)
+
+// Magic bytes opening the handshake, a peer of the newline delimited
+// protocol sends JSON instead
+var HANDSHAKE_MAGIC = []byte("CRNF")
+
+var (
+	ErrFrameTooLarge = errors.New("frame exceeds the max size")
+	ErrNotFramed     = errors.New("peer does not speak the framed protocol")
+)
+
+// Exchange the versions with the peer over the connection just opened,
+// both sides send the magic bytes and the versions they speak and take the
+// highest version both speak. The error tells why the peer is refused
+func Handshake(conn net.Conn) (int, error) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+	hello := make([]byte, len(HANDSHAKE_MAGIC)+4)
+	copy(hello, HANDSHAKE_MAGIC)
+	binary.BigEndian.PutUint16(hello[len(HANDSHAKE_MAGIC):], PROTOCOL_VERSION_MIN)
+	binary.BigEndian.PutUint16(hello[len(HANDSHAKE_MAGIC)+2:], PROTOCOL_VERSION)
+	// Sent while reading the hello of the peer, so that the handshake
+	// does not wait on a connection without buffers
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(hello)
+		written <- err
+	}()
+	peer := make([]byte, len(hello))
+	_, err := io.ReadFull(conn, peer)
+	if werr := <-written; err == nil {
+		err = werr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("handshake: %v", err)
+	}
+	if !bytes.Equal(peer[:len(HANDSHAKE_MAGIC)], HANDSHAKE_MAGIC) {
+		return 0, ErrNotFramed
+	}
+	peerMin := int(binary.BigEndian.Uint16(peer[len(HANDSHAKE_MAGIC):]))
+	peerMax := int(binary.BigEndian.Uint16(peer[len(HANDSHAKE_MAGIC)+2:]))
+	version := PROTOCOL_VERSION
+	if peerMax < version {
+		version = peerMax
+	}
+	if version < PROTOCOL_VERSION_MIN || version < peerMin {
+		return 0, fmt.Errorf("handshake: peer speaks protocol versions %d-%d, this process %d-%d",
+			peerMin, peerMax, PROTOCOL_VERSION_MIN, PROTOCOL_VERSION)
+	}
+	return version, nil
+}
+
+// Write the payload as one frame, in a single write so that the frames
+// of concurrent writers do not interleave
+func WriteFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MAX_FRAME_SIZE {
+		return fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(payload))
+	}
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// Read the payload of the next frame. The connection is to be closed
+// after an error, the frames after a broken one are not found any more
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(prefix[:])
+	if size > MAX_FRAME_SIZE {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,143 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	payloads := [][]byte{[]byte("tuple"), []byte("with\nnewline\n"), {}, bytes.Repeat([]byte{0xB1}, 1<<20)}
+	go func() {
+		for _, payload := range payloads {
+			if err := WriteFrame(client, payload); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i, want := range payloads {
+		got, err := ReadFrame(server)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("frame %d is %d bytes, expected %d", i, len(got), len(want))
+		}
+	}
+}
+
+func TestFrameLengthPrefix(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go WriteFrame(client, []byte("abc"))
+	raw := make([]byte, 7)
+	if _, err := io.ReadFull(server, raw); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, []byte{0, 0, 0, 3, 'a', 'b', 'c'}) {
+		t.Fatalf("frame is %v, expected a 4 byte big endian length and the payload", raw)
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	if err := WriteFrame(io.Discard, make([]byte, MAX_FRAME_SIZE+1)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("wrote a frame over the max size: %v", err)
+	}
+
+	// The reader refuses the prefix before reading the payload
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		prefix := binary.BigEndian.AppendUint32(nil, MAX_FRAME_SIZE+1)
+		client.Write(prefix)
+	}()
+	if _, err := ReadFrame(server); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("read a frame over the max size: %v", err)
+	}
+}
+
+func TestFrameTruncated(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write(binary.BigEndian.AppendUint32(nil, 10))
+		client.Write([]byte("abc"))
+		client.Close()
+	}()
+	if _, err := ReadFrame(server); err != io.ErrUnexpectedEOF {
+		t.Fatalf("read a truncated frame: %v", err)
+	}
+
+	// Closed between two frames
+	client, server = net.Pipe()
+	defer server.Close()
+	client.Close()
+	if _, err := ReadFrame(server); err != io.EOF {
+		t.Fatalf("read a frame from a closed connection: %v", err)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := Handshake(client)
+		errc <- err
+	}()
+	version, err := Handshake(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if version != PROTOCOL_VERSION {
+		t.Fatalf("agreed on version %d, expected %d", version, PROTOCOL_VERSION)
+	}
+}
+
+// Run the handshake against a peer sending the hello
+func handshakeWith(t *testing.T, hello []byte) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		io.ReadFull(client, make([]byte, len(HANDSHAKE_MAGIC)+4))
+		client.Write(hello)
+	}()
+	_, err := Handshake(server)
+	return err
+}
+
+func TestHandshakeVersionMismatch(t *testing.T) {
+	hello := append([]byte{}, HANDSHAKE_MAGIC...)
+	hello = binary.BigEndian.AppendUint16(hello, PROTOCOL_VERSION+1)
+	hello = binary.BigEndian.AppendUint16(hello, PROTOCOL_VERSION+2)
+	if err := handshakeWith(t, hello); err == nil {
+		t.Fatal("agreed with a peer of newer versions only")
+	}
+
+	hello = append([]byte{}, HANDSHAKE_MAGIC...)
+	hello = binary.BigEndian.AppendUint16(hello, 0)
+	hello = binary.BigEndian.AppendUint16(hello, PROTOCOL_VERSION_MIN-1)
+	if err := handshakeWith(t, hello); err == nil {
+		t.Fatal("agreed with a peer of older versions only")
+	}
+}
+
+func TestHandshakeNotFramed(t *testing.T) {
+	if err := handshakeWith(t, []byte(`{"Header":{}}`)[:len(HANDSHAKE_MAGIC)+4]); err != ErrNotFramed {
+		t.Fatalf("handshake with a newline delimited peer: %v", err)
+	}
+}
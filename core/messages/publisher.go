@@ -55,11 +55,11 @@ func (pub *Publisher) PublishMessage(msgChan chan Message) {
 		targetConn := pub.Pool.Get(message.TargetConnId)
 		if targetConn == nil {
 			log.Printf("Lost link %s and not publish now\n", message.TargetConnId)
-			break
+			continue
+		}
+		if err := WriteFrame(targetConn, message.Payload); err != nil {
+			log.Printf("Can't publish message to %s: %v\n", message.TargetConnId, err)
 		}
-		writer := bufio.NewWriter(targetConn)
-		writer.Write(append(message.Payload, '\n'))
-		writer.Flush()
 	}
 }
 
@@ -79,36 +79,47 @@ func (pub *Publisher) AcceptConns() {
 			break
 		}
 
-		connId := conn.RemoteAddr().String()
-		log.Println(connId)
-		connChan := make(chan Message, CHANNEL_SIZE)
-		pub.RWLock.Lock()
-		pub.Channels[connId] = connChan
-		pub.RWLock.Unlock()
-
-		// add connection to pool
-		pub.Pool.Insert(connId, conn)
+		// handle request once the peer agrees on the protocol
+		go pub.handleConn(conn)
+	}
 
-		// log about connection status
-		log.Printf("Connection accepted. Connections in pool: %d", pub.Pool.Size())
+}
 
-		// handle request
-		go pub.WaitMessage(
-			connChan,
-			connId,
-		)
+// Add the connection to the pool after the handshake, a peer not speaking
+// the protocol version of this publisher is refused
+func (pub *Publisher) handleConn(conn net.Conn) {
+	connId := conn.RemoteAddr().String()
+	log.Println(connId)
+	if _, err := Handshake(conn); err != nil {
+		log.Printf("Connection %s refused: %v\n", connId, err)
+		conn.Close()
+		return
 	}
+	connChan := make(chan Message, CHANNEL_SIZE)
+	pub.RWLock.Lock()
+	pub.Channels[connId] = connChan
+	pub.RWLock.Unlock()
+
+	// add connection to pool
+	pub.Pool.Insert(connId, conn)
+
+	// log about connection status
+	log.Printf("Connection accepted. Connections in pool: %d", pub.Pool.Size())
 
+	pub.WaitMessage(connChan, connId)
 }
 
 // Publisher would wait new message from subscribers comming
 func (pub *Publisher) WaitMessage(msgChan chan Message, connId string) {
 	// create new reader instance
-	reader := bufio.NewReader(pub.Pool.Get(connId))
+	conn := pub.Pool.Get(connId)
+	reader := bufio.NewReader(conn)
 	for {
 		// read request
-		request, _, err := reader.ReadLine()
+		request, err := ReadFrame(reader)
 		if err != nil {
+			// a broken frame leaves the connection open otherwise
+			conn.Close()
 			// stop reading buffer and exit goroutine
 			pub.Pool.Delete(connId)
 			pub.RWLock.Lock()
@@ -119,7 +130,7 @@ func (pub *Publisher) WaitMessage(msgChan chan Message, connId string) {
 			}
 			pub.RWLock.Unlock()
 
-			log.Printf("Can't read line from socket: %s. Connections in pool: %d\n", err, pub.Pool.Size())
+			log.Printf("Can't read frame from socket: %s. Connections in pool: %d\n", err, pub.Pool.Size())
 			return
 		} else {
 			// check request before pushing into channel
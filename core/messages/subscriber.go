@@ -23,22 +23,59 @@ func NewSubscriber(addr string) *Subscriber {
 		utils.PrintError(err)
 		return nil
 	}
+	if _, err := Handshake(conn); err != nil {
+		log.Printf("Connection to %s refused: %v\n", addr, err)
+		conn.Close()
+		return nil
+	}
 	sub.Conn = conn
 	sub.PublishBoard = make(chan Message, CHANNEL_SIZE)
 	sub.Request = make(chan Message, CHANNEL_SIZE)
 	return sub
 }
 
+// Factory method to create a subscriber of the first publisher accepting
+// the connection, e.g. the leader of the drivers
+func NewSubscriberOfAny(addrs []string) *Subscriber {
+	for _, addr := range addrs {
+		if sub := NewSubscriber(addr); sub != nil {
+			return sub
+		}
+	}
+	return nil
+}
+
+// Connect to the first publisher accepting the connection again, the
+// messages are read and requested on the new connection
+func (sub *Subscriber) Redial(addrs []string) bool {
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			continue
+		}
+		if _, err := Handshake(conn); err != nil {
+			log.Printf("Connection to %s refused: %v\n", addr, err)
+			conn.Close()
+			continue
+		}
+		sub.Conn.Close()
+		sub.Conn = conn
+		return true
+	}
+	return false
+}
+
 // Subscriber would subscribe the messages from publisher
 func (sub *Subscriber) ReadMessage() {
 	// create new reader instance
 	reader := bufio.NewReader(sub.Conn)
 	for {
 		// read message
-		request, _, err := reader.ReadLine()
+		request, err := ReadFrame(reader)
 		if err != nil {
 			// stop reading buffer and exit goroutine
-			log.Println("Can't read line from socket:", err)
+			log.Println("Can't read frame from socket:", err)
+			sub.Conn.Close()
 			break
 		} else {
 			// check request before pushing into channel
@@ -49,9 +86,18 @@ func (sub *Subscriber) ReadMessage() {
 			connId := sub.Conn.RemoteAddr().String()
 			// push message from subscriber to message channel
 			log.Printf("Received message %s on socket %s", request, connId)
-			sub.PublishBoard <- Message{
-				Payload:      request,
-				SourceConnId: connId,
+			// The messages of a batch arrive one by one
+			messages, err := Unbatch(request)
+			if err != nil {
+				log.Println("Can't unbatch frame from socket:", err)
+				sub.Conn.Close()
+				break
+			}
+			for _, message := range messages {
+				sub.PublishBoard <- Message{
+					Payload:      message,
+					SourceConnId: connId,
+				}
 			}
 		}
 	}
@@ -66,8 +112,8 @@ func (sub *Subscriber) RequestMessage() {
 		//log.Println("Going to send message on socket %s", message.TargetConnId)
 
 		// send message to targetConn
-		writer := bufio.NewWriter(sub.Conn)
-		writer.Write(append(message.Payload, '\n'))
-		writer.Flush()
+		if err := WriteFrame(sub.Conn, message.Payload); err != nil {
+			log.Printf("Can't send message to %s: %v\n", message.TargetConnId, err)
+		}
 	}
 }
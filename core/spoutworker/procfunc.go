@@ -0,0 +1,68 @@
+package spoutworker
+
+import (
+	"crane/spout"
+	"encoding/json"
+)
+
+// Spout driving the raw process function and the optional callbacks
+// exported by the plugins written before spout.Spout, its variables
+// are checkpointed as JSON
+type procFuncSpout struct {
+	procFunc  func([]interface{}, *[]interface{}, *[]interface{}) error
+	ackFunc   func([]interface{}, *[]interface{})
+	failFunc  func([]interface{}, *[]interface{})
+	variables []interface{}
+	collector *spout.SpoutOutputCollector
+}
+
+func newProcFuncSpout(procFunc func([]interface{}, *[]interface{}, *[]interface{}) error,
+	ackFunc func([]interface{}, *[]interface{}), failFunc func([]interface{}, *[]interface{})) *procFuncSpout {
+	return &procFuncSpout{
+		procFunc:  procFunc,
+		ackFunc:   ackFunc,
+		failFunc:  failFunc,
+		variables: make([]interface{}, 0), // Store spout's global variables
+	}
+}
+
+func (ps *procFuncSpout) Open(task string, collector *spout.SpoutOutputCollector) error {
+	ps.collector = collector
+	return nil
+}
+
+func (ps *procFuncSpout) NextTuple() error {
+	var empty []interface{}
+	var tuple []interface{}
+	err := ps.procFunc(empty, &tuple, &ps.variables)
+	if err != nil {
+		return err
+	}
+	if len(tuple) > 0 {
+		ps.collector.Emit(tuple...)
+	}
+	return nil
+}
+
+func (ps *procFuncSpout) Close() {
+}
+
+func (ps *procFuncSpout) Ack(tuple []interface{}) {
+	if ps.ackFunc != nil {
+		ps.ackFunc(tuple, &ps.variables)
+	}
+}
+
+func (ps *procFuncSpout) Fail(tuple []interface{}) {
+	if ps.failFunc != nil {
+		ps.failFunc(tuple, &ps.variables)
+	}
+}
+
+func (ps *procFuncSpout) Snapshot() ([]byte, error) {
+	return json.Marshal(ps.variables)
+}
+
+func (ps *procFuncSpout) Restore(data []byte) error {
+	return json.Unmarshal(data, &ps.variables)
+}
@@ -1,85 +1,168 @@
 package spoutworker
 
 import (
+	"crane/core/codec"
 	"crane/core/messages"
+	"crane/core/state"
 	"crane/core/utils"
+	"crane/spout"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	BUFLEN = 1024
+	BUFLEN            = 1024
+	STATE_RETENTION   = 3
+	MAX_SPOUT_PENDING = 1024
 )
 
+// Tuple emitted by the spout and waiting for its tree to be acked
+type pendingTuple struct {
+	tuple   utils.TupleMessage
+	emitted time.Time
+}
+
+// Completed or failed tuple to notify the spout
+type ackNotify struct {
+	ackType string
+	values  []interface{}
+}
+
+// Checkpointed state of a spout task
+type spoutState struct {
+	State  []byte
+	Failed []utils.TupleMessage
+}
+
 type SpoutWorker struct {
 	Name        string
-	procFunc    func([]interface{}, *[]interface{}, *[]interface{}) error
+	spout       spout.Spout
+	collector   *spout.SpoutOutputCollector
 	port        string
-	tuples      chan []interface{}
-	variables   []interface{}
+	tuples      chan utils.TupleMessage
 	publisher   *messages.Publisher
 	sucGrouping string
 	sucField    int
-	sucIndexMap map[string]map[int]string
+	sucStreams  map[string][]string
+	sucIndexes  map[string]map[string][]int
+	// Connections of the successor tasks and the codecs of their edges,
+	// a *successors replaced whenever a successor subscribes
+	sucRoutes   atomic.Value
+	// Batches of the tuples to the successors
+	batch       utils.BatchConfig
+	batcher     *messages.Batcher
+	state       state.StateBackend
+	ackerAddr   string
+	ackerSub    *messages.Subscriber
+	ackTimeout  time.Duration
+	pending     map[uint64]pendingTuple
+	replays     []utils.TupleMessage
+	notifies    []ackNotify
+	barriers    []int
 	rwmutex     sync.RWMutex
 	wg          sync.WaitGroup
 	SupervisorC chan string
 	WorkerC     chan string
-	suspend     bool
-	suspendWg   sync.WaitGroup
+	// Set by the supervisor, the tuple receiving loop waits on resumeC
+	suspended   int32
+	resumeC     chan struct{}
 	Version     string
+	// Counters and state reported by the supervisor heartbeats
+	emitted    uint64
+	acked      uint64
+	failed     uint64
+	terminated int32
+	// Closed when the worker is killed or crashes, with the reason
+	// of the crash for the supervisor to restart it
+	done     chan struct{}
+	stopOnce sync.Once
+	failure  atomic.Value
 }
 
 func NewSpoutWorker(name string, pluginFilename string, pluginSymbol string, port string,
-	sucGrouping string, sucField int, supervisorC chan string, workerC chan string, version int) *SpoutWorker {
+	sucGrouping string, sucField int, sucStreams map[string][]string, sucIndexes map[string]map[string][]int,
+	supervisorC chan string, workerC chan string, version int,
+	stateBackend state.StateBackend, ackerAddr string, ackTimeout int, batch utils.BatchConfig) (sw *SpoutWorker, err error) {
+	// A panic of the plugin fails the task, not the supervisor
+	defer func() {
+		if r := recover(); r != nil {
+			sw, err = nil, fmt.Errorf("spout %s panics starting: %v", name, r)
+		}
+	}()
 
-	procFunc := utils.LookupProcFunc(pluginFilename, pluginSymbol)
+	// Lookup the factory of the spout, or the ProcFunc with its callbacks
+	var sp spout.Spout
+	symbol, err := utils.LookupSymbol(pluginFilename, pluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	switch symbol := symbol.(type) {
+	case func() spout.Spout:
+		sp = symbol()
+	case func([]interface{}, *[]interface{}, *[]interface{}) error:
+		sp = newProcFuncSpout(symbol,
+			utils.LookupCallback(pluginFilename, pluginSymbol+"Ack"),
+			utils.LookupCallback(pluginFilename, pluginSymbol+"Fail"))
+	default:
+		return nil, fmt.Errorf("unexpected type %T of symbol %s", symbol, pluginSymbol)
+	}
 
-	tuples := make(chan []interface{}, BUFLEN)
-	variables := make([]interface{}, 0) // Store spout's global variables
+	tuples := make(chan utils.TupleMessage, BUFLEN)
 
 	// Create publisher
 	var publisher *messages.Publisher
 
-	// A map to record the index of successor
-	sucIndexMap := make(map[string]map[int]string)
-
-	sw := &SpoutWorker{
+	sw = &SpoutWorker{
 		Name:        name,
-		procFunc:    procFunc,
+		spout:       sp,
 		port:        port,
 		tuples:      tuples,
-		variables:   variables,
 		publisher:   publisher,
 		sucGrouping: sucGrouping,
 		sucField:    sucField,
-		sucIndexMap: sucIndexMap,
+		sucStreams:  sucStreams,
+		sucIndexes:  sucIndexes,
+		state:       stateBackend,
+		ackerAddr:   ackerAddr,
+		ackTimeout:  time.Duration(ackTimeout) * time.Second,
+		batch:       batch,
+		pending:     make(map[uint64]pendingTuple),
+		replays:     make([]utils.TupleMessage, 0),
+		notifies:    make([]ackNotify, 0),
+		barriers:    make([]int, 0),
 		SupervisorC: supervisorC,
 		WorkerC:     workerC,
-		suspend:     false,
+		resumeC:     make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	sw.collector = spout.NewSpoutOutputCollector(func(stream string, values []interface{}) {
+		sw.tuples <- utils.TupleMessage{Stream: stream, Values: values}
+	})
+	if err := sw.spout.Open(name, sw.collector); err != nil {
+		log.Println(err)
 	}
 
-	// Start from restore, read state file to get variables
+	// Start from restore, load state to get variables
 	if version > 0 {
 		sw.DeserializeVariables(strconv.Itoa(version))
 	}
 	sw.Version = strconv.Itoa(version)
 
-	return sw
+	sw.sucRoutes.Store(&successors{indexes: make(map[string]map[int]string), codecs: make(map[string]codec.Codec)})
+	return sw, nil
 }
 
 func (sw *SpoutWorker) Start() {
+	sw.wg.Add(1)
 	defer close(sw.tuples)
-	defer close(sw.SupervisorC)
-	defer close(sw.WorkerC)
+	defer sw.spout.Close()
 
 	log.Printf("Spout Worker %s Start\n", sw.Name)
 
@@ -90,44 +173,71 @@ func (sw *SpoutWorker) Start() {
 	sw.publisher = messages.NewPublisher(":" + sw.port)
 	go sw.publisher.AcceptConns()
 	go sw.publisher.PublishMessage(sw.publisher.PublishBoard)
+	sw.batcher = messages.NewBatcher(sw.publisher.PublishBoard, sw.batch)
+
+	// Subscribe the acker to track the emitted tuples
+	if sw.ackerAddr != "" {
+		sw.ackerSub = messages.NewSubscriber(sw.ackerAddr)
+		if sw.ackerSub == nil {
+			log.Printf("%s fails to connect acker %s, tuples are not tracked\n", sw.Name, sw.ackerAddr)
+		} else {
+			go sw.ackerSub.ReadMessage()
+			go sw.ackerSub.RequestMessage()
+			go sw.receiveAcks()
+			go sw.expirePending()
+		}
+	}
 	time.Sleep(2 * time.Second) // Wait for all subscribers to join
 
 	// Listen to subscriber, they will tell who they are
 	go sw.listenToSubscribers()
 	time.Sleep(2 * time.Second) // Wait for spout to establish suc index map
-	fmt.Printf("Map: %v\n", sw.sucIndexMap)
+	fmt.Printf("Map: %v\n", sw.successors().indexes)
 
 	go sw.receiveTuple()
 	go sw.outputTuple()
 
-	sw.wg.Add(1)
 	sw.wg.Wait()
 	sw.publisher.Close()
+	if sw.ackerSub != nil {
+		sw.ackerSub.Conn.Close()
+	}
+	atomic.StoreInt32(&sw.terminated, 1)
 	log.Printf("Spout Worker %s Terminates\n", sw.Name)
 }
 
 func (sw *SpoutWorker) listenToSubscribers() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("listenToSubscribers panic and recovered", r)
+			sw.crash(fmt.Sprintf("listenToSubscribers panics: %v", r))
 		}
 	}()
 	for {
+		select {
+		case <-sw.done:
+			return
+		default:
+		}
 		for connId, channel := range sw.publisher.Channels {
 			sw.publisher.RWLock.RLock()
 			select {
-			case message := <- channel:
+			case message := <-channel:
 				log.Println(message)
-				var workerName string
-				json.Unmarshal(message.Payload, &workerName)
+				// The successor is gone, its index is taken over by the
+				// task replacing it when it subscribes
+				if utils.CheckType(message.Payload).Header.Type == utils.CONN_NOTIFY {
+					break
+				}
+				workerName, c, err := codec.ParseHello(message.Payload)
+				if err != nil {
+					log.Printf("%s Receives Invalid Hello From %s: %v\n", sw.Name, connId, err)
+					break
+				}
 				words := strings.Split(workerName, "_")
 				boltType := words[0]
 				boltIndex := words[1]
-				if (sw.sucIndexMap[boltType] == nil) {
-					sw.sucIndexMap[boltType] = make(map[int]string)
-				}
 				index, _ := strconv.Atoi(boltIndex)
-				sw.sucIndexMap[boltType][index] = connId
+				sw.addSuccessor(boltType, index, connId, c)
 			default:
 			}
 			sw.publisher.RWLock.RUnlock()
@@ -139,108 +249,383 @@ func (sw *SpoutWorker) listenToSubscribers() {
 func (sw *SpoutWorker) receiveTuple() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("receiveTuple panic and recovered", r)
+			sw.crash(fmt.Sprintf("receiveTuple panics: %v", r))
 		}
 	}()
 	for {
-		sw.suspendWg.Wait()
-		var empty []interface{}
-		var tuple []interface{}
-		err := sw.procFunc(empty, &tuple, &sw.variables)
-		if err != nil {
+		if atomic.LoadInt32(&sw.suspended) == 1 {
+			select {
+			case <-sw.resumeC:
+			case <-sw.done:
+				return
+			}
+			continue
+		}
+		select {
+		case <-sw.done:
+			return
+		default:
+		}
+
+		// Notify the spout between two NextTuple calls
+		sw.rwmutex.Lock()
+		notifies := sw.notifies
+		sw.notifies = make([]ackNotify, 0)
+		sw.rwmutex.Unlock()
+		sw.notify(notifies)
+
+		// Checkpoint barriers are injected between two tuples, so the
+		// variables serialized match the tuples emitted before the barrier
+		sw.rwmutex.Lock()
+		if len(sw.barriers) > 0 {
+			version := sw.barriers[0]
+			sw.barriers = sw.barriers[1:]
+			sw.rwmutex.Unlock()
+			sw.checkpoint(version)
+			continue
+		}
+
+		// Failed tuples are replayed before new ones
+		if len(sw.replays) > 0 {
+			tuple := sw.replays[0]
+			sw.replays = sw.replays[1:]
+			sw.rwmutex.Unlock()
+			sw.tuples <- tuple
 			continue
 		}
-		sw.tuples <- tuple
+		numPending := len(sw.pending)
+		sw.rwmutex.Unlock()
+
+		// Back pressure on the tuples not acked yet
+		if sw.ackerSub != nil && numPending >= MAX_SPOUT_PENDING {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		// The spout emits the tuples with the collector
+		sw.spout.NextTuple()
+	}
+}
+
+// Call the callbacks of the reliable spouts
+func (sw *SpoutWorker) notify(notifies []ackNotify) {
+	reliable, ok := sw.spout.(spout.ReliableSpout)
+	if !ok {
+		return
+	}
+	for _, n := range notifies {
+		switch n.ackType {
+		case utils.TUPLE_ACK:
+			reliable.Ack(n.values)
+		case utils.TUPLE_FAIL:
+			reliable.Fail(n.values)
+		}
 	}
 }
 
+// Serialize the variables and send the barrier of the version downstream
+func (sw *SpoutWorker) checkpoint(version int) {
+	v := strconv.Itoa(version)
+	sw.SerializeVariables(v)
+	sw.Version = v
+	sw.tuples <- utils.TupleMessage{Barrier: version}
+	// Notify the supervisor it serialized the variables
+	sw.WorkerC <- fmt.Sprintf("1. %s Serialized Variables With Version %s", sw.Name, v)
+}
+
 func (sw *SpoutWorker) outputTuple() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("outputTuple panic and recovered", r)
+			sw.crash(fmt.Sprintf("outputTuple panics: %v", r))
 		}
 	}()
-	switch sw.sucGrouping {
-	case utils.GROUPING_BY_SHUFFLE:
-		count := 0
-		for tuple := range sw.tuples {
-			bin, _ := json.Marshal(tuple)
-			for _, v := range sw.sucIndexMap {
-				sucid := count % len(v)
-				sucConnId := v[sucid+1]
-				sw.publisher.PublishBoard <- messages.Message{
-					Payload:      bin,
-					TargetConnId: sucConnId,
-				}
-			}
-			count++
-		}
-
-	case utils.GROUPING_BY_FIELD:
-		for tuple := range sw.tuples {
-			bin, _ := json.Marshal(tuple)
-			for _, v := range sw.sucIndexMap {
-				sucid := utils.Hash(tuple[sw.sucField]) % len(v)
-				sucConnId := v[sucid+1]
-				sw.publisher.PublishBoard <- messages.Message{
-					Payload:      bin,
-					TargetConnId: sucConnId,
-				}
-			}
+	count := 0
+	for {
+		message, ok := sw.nextTuple()
+		if !ok {
+			return
 		}
-
-	case utils.GROUPING_BY_ALL:
-		for tuple := range sw.tuples {
-			bin, _ := json.Marshal(tuple)
+		// Barriers go to every successor, after the tuples batched
+		if message.Barrier > 0 {
 			sw.publisher.Pool.Range(func(id string, conn net.Conn) {
-				sw.publisher.PublishBoard <- messages.Message{
-					Payload:      bin,
+				sw.batcher.Add(messages.Message{
+					Payload:      sw.encode(id, message),
 					TargetConnId: id,
-				}
+				})
 			})
+			sw.batcher.Flush()
+			continue
+		}
+
+		sw.emit(message, sw.targets(message, count))
+		atomic.AddUint64(&sw.emitted, 1)
+		count++
+	}
+}
+
+// Next tuple to output. The batches are sent meanwhile once no tuple
+// is queued or their linger passed, false after the last tuple
+func (sw *SpoutWorker) nextTuple() (utils.TupleMessage, bool) {
+	for {
+		if len(sw.tuples) == 0 {
+			sw.batcher.Idle()
+		}
+		select {
+		case tuple, ok := <-sw.tuples:
+			if !ok {
+				sw.batcher.Flush()
+			}
+			return tuple, ok
+		case <-sw.batcher.Expired():
+			sw.batcher.Expire()
+		}
+	}
+}
+
+// Successor tasks of the tuple, by the grouping of the components
+// subscribing the stream the tuple is emitted to
+func (sw *SpoutWorker) targets(tuple utils.TupleMessage, count int) []string {
+	targets := make([]string, 0)
+	for component, v := range sw.successors().indexes {
+		if !utils.Subscribed(sw.sucStreams, component, tuple.Stream) {
+			continue
+		}
+		// The successor groups by the named fields
+		if indexes, ok := sw.sucIndexes[component][tuple.Stream]; ok {
+			sucid := utils.Hash(utils.SelectFields(tuple.Values, indexes)) % len(v)
+			targets = append(targets, v[sucid+1])
+			continue
+		}
+		switch sw.sucGrouping {
+		case utils.GROUPING_BY_SHUFFLE:
+			sucid := count % len(v)
+			targets = append(targets, v[sucid+1])
+		case utils.GROUPING_BY_FIELD:
+			sucid := utils.Hash(tuple.Values[sw.sucField]) % len(v)
+			targets = append(targets, v[sucid+1])
+		case utils.GROUPING_BY_ALL:
+			for _, connId := range v {
+				targets = append(targets, connId)
+			}
+		}
+	}
+	return targets
+}
+
+// Send the tuple to the targets, and register its tree to the acker
+// with one edge id for each target
+func (sw *SpoutWorker) emit(tuple utils.TupleMessage, targets []string) {
+	var root uint64
+	if sw.ackerSub != nil {
+		root = utils.NewTupleId()
+		sw.rwmutex.Lock()
+		sw.pending[root] = pendingTuple{tuple: tuple, emitted: time.Now()}
+		sw.rwmutex.Unlock()
+	}
+
+	var xor uint64
+	for _, target := range targets {
+		var id uint64
+		if root != 0 {
+			id = utils.NewTupleId()
+			xor ^= id
+		}
+		bin := sw.encode(target, utils.TupleMessage{Id: id, Root: root, Stream: tuple.Stream, Values: tuple.Values})
+		if bin == nil {
+			continue
+		}
+		sw.batcher.Add(messages.Message{
+			Payload:      bin,
+			TargetConnId: target,
+		})
+	}
+
+	if root == 0 {
+		return
+	}
+	if xor == 0 {
+		// Nobody to process the tuple, the tree is completed
+		sw.handleAck(utils.AckMessage{Type: utils.TUPLE_ACK, Root: root})
+		return
+	}
+	bin, _ := json.Marshal(utils.AckMessage{Type: utils.TUPLE_ACK_INIT, Root: root, Xor: xor})
+	sw.ackerSub.Request <- messages.Message{
+		Payload: bin,
+	}
+}
+
+// Routes to the successor tasks, the connection of every task by its
+// component and index, and the codec of the edge by the connection. It is
+// never modified, a subscribing task replaces it with a copy
+type successors struct {
+	indexes map[string]map[int]string
+	codecs  map[string]codec.Codec
+}
+
+func (sw *SpoutWorker) successors() *successors {
+	return sw.sucRoutes.Load().(*successors)
+}
+
+// Route the task of the component and index to the connection, the task
+// and its codec replace the previous ones at once for the output path.
+// Called by listenToSubscribers only
+func (sw *SpoutWorker) addSuccessor(component string, index int, connId string, c codec.Codec) {
+	old := sw.successors()
+	s := &successors{
+		indexes: make(map[string]map[int]string, len(old.indexes)+1),
+		codecs:  make(map[string]codec.Codec, len(old.codecs)+1),
+	}
+	for name, tasks := range old.indexes {
+		s.indexes[name] = make(map[int]string, len(tasks)+1)
+		for i, id := range tasks {
+			s.indexes[name][i] = id
+		}
+	}
+	for id, oc := range old.codecs {
+		s.codecs[id] = oc
+	}
+	if s.indexes[component] == nil {
+		s.indexes[component] = make(map[int]string)
+	}
+	s.indexes[component][index] = connId
+	s.codecs[connId] = c
+	sw.sucRoutes.Store(s)
+}
+
+// Encode the tuple with the codec of the successor, nil if the codec
+// fails to encode it
+func (sw *SpoutWorker) encode(connId string, tuple utils.TupleMessage) []byte {
+	c, ok := sw.successors().codecs[connId]
+	if !ok {
+		c = codec.JsonCodec{}
+	}
+	bin, err := c.Encode(tuple)
+	if err != nil {
+		log.Printf("%s Fails to Encode Tuple With %s Codec: %v\n", sw.Name, c.Name(), err)
+		return nil
+	}
+	return bin
+}
+
+// Receive the completed or failed tuple trees from the acker
+func (sw *SpoutWorker) receiveAcks() {
+	defer func() {
+		if r := recover(); r != nil {
+			sw.crash(fmt.Sprintf("receiveAcks panics: %v", r))
+		}
+	}()
+	for message := range sw.ackerSub.PublishBoard {
+		ack := utils.AckMessage{}
+		if err := json.Unmarshal(message.Payload, &ack); err != nil {
+			continue
+		}
+		sw.handleAck(ack)
+	}
+}
+
+func (sw *SpoutWorker) handleAck(ack utils.AckMessage) {
+	sw.rwmutex.Lock()
+	defer sw.rwmutex.Unlock()
+	tuple, ok := sw.pending[ack.Root]
+	if !ok {
+		return
+	}
+	delete(sw.pending, ack.Root)
+	if ack.Type == utils.TUPLE_ACK {
+		atomic.AddUint64(&sw.acked, 1)
+	}
+	if ack.Type == utils.TUPLE_FAIL {
+		atomic.AddUint64(&sw.failed, 1)
+		log.Printf("%s Replay Tuple %v\n", sw.Name, tuple.tuple.Values)
+		sw.replays = append(sw.replays, tuple.tuple)
+	}
+	sw.notifies = append(sw.notifies, ackNotify{ackType: ack.Type, values: tuple.tuple.Values})
+}
+
+// Fail the tuples whose trees are not completed in the ack timeout
+func (sw *SpoutWorker) expirePending() {
+	for {
+		select {
+		case <-sw.done:
+			return
+		case <-time.After(time.Second):
+		}
+		expired := make([]uint64, 0)
+		sw.rwmutex.RLock()
+		for root, tuple := range sw.pending {
+			if time.Since(tuple.emitted) > sw.ackTimeout {
+				expired = append(expired, root)
+			}
+		}
+		sw.rwmutex.RUnlock()
+		for _, root := range expired {
+			sw.handleAck(utils.AckMessage{Type: utils.TUPLE_FAIL, Root: root})
 		}
 	}
 }
 
-// Serialize and store variables into local file
+// Serialize and save variables into the state backend
 func (sw *SpoutWorker) SerializeVariables(version string) {
 	log.Printf("%s Start Serializing Variables With Version %s\n", sw.Name, version)
 
-	var bins []interface{}
-	bins = append(bins, sw.variables)
+	// The pending tuples are emitted before the barrier and covered by the
+	// successors' snapshots, only the failed ones waiting for replay are saved
+	bins := spoutState{}
+	sw.rwmutex.RLock()
+	bins.Failed = append(bins.Failed, sw.replays...)
+	sw.rwmutex.RUnlock()
+	if stateful, ok := sw.spout.(state.Stateful); ok {
+		snapshot, err := stateful.Snapshot()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		bins.State = snapshot
+	}
 
-	// Create file to store
-	filename := fmt.Sprintf("%s_%s", sw.Name, version)
-	file, err := os.Create(filename)
+	// Save the spout state's binary value as the state of this version
+	b, err := json.Marshal(bins)
 	if err != nil {
-		fmt.Println(err)
-		// os.Exit(1)
+		log.Println(err)
+		return
+	}
+	v, _ := strconv.Atoi(version)
+	if err := sw.state.Save(sw.Name, v, b); err != nil {
+		log.Println(err)
+		return
+	}
+	// Only keep the latest versions for restoring
+	if err := state.Prune(sw.state, sw.Name, STATE_RETENTION); err != nil {
+		log.Println(err)
 	}
-	defer file.Close()
-
-	// Store variable's binary value into the file
-	b, _ := json.Marshal(bins)
-	file.Write(b)
 }
 
-// Deserialize variables from local file
+// Deserialize variables from the state backend
 func (sw *SpoutWorker) DeserializeVariables(version string) {
 	log.Printf("%s Start Deserializing Variables With Version %s\n", sw.Name, version)
-	// Open the local file that stores the variables' binary value
-	filename := fmt.Sprintf("%s_%s", sw.Name, version)
-	b, err := ioutil.ReadFile("./" + filename)
+	v, _ := strconv.Atoi(version)
+	b, err := sw.state.Load(sw.Name, v)
 	if err != nil {
-		fmt.Println(err)
-		// os.Exit(1)
+		log.Println(err)
+		return
 	}
 
 	// Unmarshal the binary value
-	var bins []interface{}
-	json.Unmarshal(b, &bins)
+	bins := spoutState{}
+	if err := json.Unmarshal(b, &bins); err != nil {
+		log.Println(err)
+		return
+	}
 
-	// Deserialize to get variables
-	sw.variables = bins[0].([]interface{})
-	log.Printf("%s Deserialize Variables %v\n", sw.Name, sw.variables)
+	// Restore the spout state
+	if stateful, ok := sw.spout.(state.Stateful); ok && bins.State != nil {
+		if err := stateful.Restore(bins.State); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// Replay the tuples failed at the snapshot
+	sw.replays = append(sw.replays, bins.Failed...)
 }
 
 // The channel to communicate with the supervisor
@@ -256,37 +641,91 @@ func (sw *SpoutWorker) TalkWithSupervisor() {
 	// 2. W Suspended                                  Worker -> Supervisor
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("TalkWithSupervisor panic and recovered", r)
+			sw.crash(fmt.Sprintf("TalkWithSupervisor panics: %v", r))
 		}
 	}()
 
 	for {
 		select {
+		case <-sw.done:
+			return
 		case message := <-sw.SupervisorC:
 			switch string(message[0]) {
 			case "1":
 				words := strings.Fields(message)
-				version := words[len(words)-1]
-				sw.SerializeVariables(version)
-				sw.Version = version
-				// Notify the supervisor it serialized the variables
-				sw.WorkerC <- fmt.Sprintf("1. %s Serialized Variables With Version %s", sw.Name, version)
+				version, _ := strconv.Atoi(words[len(words)-1])
+				// The barrier is injected by the tuple receiving loop
+				sw.rwmutex.Lock()
+				sw.barriers = append(sw.barriers, version)
+				sw.rwmutex.Unlock()
 
 			case "2":
-				sw.wg.Done()
+				sw.stop("")
 
 			case "3":
-				sw.suspend = true
-				sw.suspendWg.Add(1)
+				if !atomic.CompareAndSwapInt32(&sw.suspended, 0, 1) {
+					break
+				}
 				log.Printf("%s Suspended\n", sw.Name)
 				sw.WorkerC <- fmt.Sprintf("2. %s Suspended", sw.Name)
 
 			case "4":
-				sw.suspend = false
-				sw.suspendWg.Done()
+				if !atomic.CompareAndSwapInt32(&sw.suspended, 1, 0) {
+					break
+				}
+				select {
+				case sw.resumeC <- struct{}{}:
+				default:
+				}
 				log.Printf("%s Resumeed\n", sw.Name)
 			}
 		default:
 		}
 	}
 }
+
+// Stop the worker, with the reason if it crashed. Only the first
+// stop counts, the panics of the goroutines stopping with it do not
+func (sw *SpoutWorker) stop(reason string) {
+	sw.stopOnce.Do(func() {
+		if reason != "" {
+			sw.failure.Store(reason)
+		}
+		close(sw.done)
+		sw.wg.Done()
+	})
+}
+
+// Crash the worker, its supervisor restarts it
+func (sw *SpoutWorker) crash(reason string) {
+	log.Printf("Spout Worker %s Crashes: %s\n", sw.Name, reason)
+	sw.stop(reason)
+}
+
+// Reason of the crash, empty if the worker is running or killed
+func (sw *SpoutWorker) Failure() string {
+	reason, _ := sw.failure.Load().(string)
+	return reason
+}
+
+// Status of the worker for the supervisor heartbeats
+func (sw *SpoutWorker) Status() utils.WorkerStatus {
+	status := utils.WorkerStatus{
+		Task:    sw.Name,
+		Kind:    "spout",
+		State:   utils.WORKER_RUNNING,
+		Emitted: atomic.LoadUint64(&sw.emitted),
+		Acked:   atomic.LoadUint64(&sw.acked),
+		Failed:  atomic.LoadUint64(&sw.failed),
+	}
+	if atomic.LoadInt32(&sw.suspended) == 1 {
+		status.State = utils.WORKER_SUSPENDED
+	}
+	if atomic.LoadInt32(&sw.terminated) == 1 {
+		status.State = utils.WORKER_DEAD
+	}
+	if status.Error = sw.Failure(); status.Error != "" {
+		status.State = utils.WORKER_FAILED
+	}
+	return status
+}
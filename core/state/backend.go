@@ -0,0 +1,58 @@
+package state
+
+import (
+	"crane/core/utils"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrStateNotFound  = errors.New("state does not exist")
+	ErrUnknownBackend = errors.New("unknown state backend")
+)
+
+// StateBackend stores the checkpointed state of bolt and spout tasks.
+// A state is addressed by the task name (e.g. WordCountBolt_1) and
+// the snapshot version it belongs to
+type StateBackend interface {
+	Save(task string, version int, data []byte) error
+	Load(task string, version int) ([]byte, error)
+	List(task string) ([]int, error)
+	Delete(task string, version int) error
+}
+
+// Create the state backend of the kind selected by the topology, SDFS by default.
+// dir is used by the local backend, sdfsMasterAddr by the SDFS backend
+func NewBackend(kind string, dir string, sdfsMasterAddr string) (StateBackend, error) {
+	switch kind {
+	case utils.STATE_BACKEND_LOCAL:
+		return NewLocalBackend(dir)
+	case utils.STATE_BACKEND_SDFS, "":
+		return NewSdfsBackend(sdfsMasterAddr), nil
+	case utils.STATE_BACKEND_MEMORY:
+		return SharedMemoryBackend, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownBackend, kind)
+}
+
+// Delete the versions of the task older than the latest keep ones
+func Prune(backend StateBackend, task string, keep int) error {
+	versions, err := backend.List(task)
+	if err != nil {
+		return err
+	}
+	if len(versions) <= keep {
+		return nil
+	}
+	for _, version := range versions[:len(versions)-keep] {
+		if err := backend.Delete(task, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name of the state of a task version, e.g. WordCountBolt_1_3
+func StateName(task string, version int) string {
+	return fmt.Sprintf("%s_%d", task, version)
+}
@@ -0,0 +1,94 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBackendRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend func(t *testing.T) StateBackend
+	}{
+		{"local", func(t *testing.T) StateBackend {
+			lb, err := NewLocalBackend(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return lb
+		}},
+		{"memory", func(t *testing.T) StateBackend {
+			return NewMemoryBackend()
+		}},
+		{"namespace", func(t *testing.T) StateBackend {
+			shared := NewMemoryBackend()
+			// A state of another topology must not be seen through the namespace
+			if err := NewNamespacedBackend(shared, "other").Save("WordCountBolt_1", 9, []byte("other")); err != nil {
+				t.Fatal(err)
+			}
+			return NewNamespacedBackend(shared, "wordcount-1")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := tt.backend(t)
+			task := "WordCountBolt_1"
+			states := map[int][]byte{
+				1: []byte("one"),
+				2: {},
+				3: []byte{0, 0xB1, 0xFF},
+			}
+
+			if versions, err := backend.List(task); err != nil || len(versions) != 0 {
+				t.Fatalf("List before Save = %v, %v, expected none", versions, err)
+			}
+			for _, version := range []int{3, 1, 2} {
+				if err := backend.Save(task, version, states[version]); err != nil {
+					t.Fatalf("Save %d: %v", version, err)
+				}
+			}
+			for version, want := range states {
+				got, err := backend.Load(task, version)
+				if err != nil {
+					t.Fatalf("Load %d: %v", version, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("Load %d = %v, expected %v", version, got, want)
+				}
+			}
+			versions, err := backend.List(task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(versions, []int{1, 2, 3}) {
+				t.Fatalf("List = %v, expected [1 2 3]", versions)
+			}
+
+			if _, err := backend.Load(task, 4); !errors.Is(err, ErrStateNotFound) {
+				t.Fatalf("Load missing version: %v, expected %v", err, ErrStateNotFound)
+			}
+			if _, err := backend.Load("WordCountBolt_2", 1); !errors.Is(err, ErrStateNotFound) {
+				t.Fatalf("Load missing task: %v, expected %v", err, ErrStateNotFound)
+			}
+
+			if err := backend.Delete(task, 2); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := backend.Load(task, 2); !errors.Is(err, ErrStateNotFound) {
+				t.Fatalf("Load deleted version: %v, expected %v", err, ErrStateNotFound)
+			}
+			if err := backend.Delete(task, 2); !errors.Is(err, ErrStateNotFound) {
+				t.Fatalf("Delete missing version: %v, expected %v", err, ErrStateNotFound)
+			}
+			versions, err = backend.List(task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(versions, []int{1, 3}) {
+				t.Fatalf("List after Delete = %v, expected [1 3]", versions)
+			}
+		})
+	}
+}
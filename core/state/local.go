@@ -0,0 +1,65 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LocalBackend keeps the states as files in a local directory,
+// which is enough for development on a single machine
+type LocalBackend struct {
+	Dir string
+}
+
+// Factory mode to return the LocalBackend instance
+func NewLocalBackend(dir string) (*LocalBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalBackend{Dir: dir}, nil
+}
+
+func (lb *LocalBackend) Save(task string, version int, data []byte) error {
+	path := filepath.Join(lb.Dir, StateName(task, version))
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (lb *LocalBackend) Load(task string, version int) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(lb.Dir, StateName(task, version)))
+	if os.IsNotExist(err) {
+		return nil, ErrStateNotFound
+	}
+	return b, err
+}
+
+func (lb *LocalBackend) List(task string) ([]int, error) {
+	files, err := filepath.Glob(filepath.Join(lb.Dir, task+"_*"))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int, 0)
+	for _, f := range files {
+		suffix := strings.TrimPrefix(filepath.Base(f), task+"_")
+		version, err := strconv.Atoi(suffix)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func (lb *LocalBackend) Delete(task string, version int) error {
+	err := os.Remove(filepath.Join(lb.Dir, StateName(task, version)))
+	if os.IsNotExist(err) {
+		return ErrStateNotFound
+	}
+	return err
+}
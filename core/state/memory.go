@@ -0,0 +1,63 @@
+package state
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory backend shared by all the tasks of this process
+var SharedMemoryBackend = NewMemoryBackend()
+
+// MemoryBackend keeps the states in memory, it is meant for tests
+type MemoryBackend struct {
+	states  map[string]map[int][]byte
+	rwmutex sync.RWMutex
+}
+
+// Factory mode to return the MemoryBackend instance
+func NewMemoryBackend() *MemoryBackend {
+	mb := &MemoryBackend{}
+	mb.states = make(map[string]map[int][]byte)
+	return mb
+}
+
+func (mb *MemoryBackend) Save(task string, version int, data []byte) error {
+	mb.rwmutex.Lock()
+	defer mb.rwmutex.Unlock()
+	if mb.states[task] == nil {
+		mb.states[task] = make(map[int][]byte)
+	}
+	mb.states[task][version] = append([]byte(nil), data...)
+	return nil
+}
+
+func (mb *MemoryBackend) Load(task string, version int) ([]byte, error) {
+	mb.rwmutex.RLock()
+	defer mb.rwmutex.RUnlock()
+	data, ok := mb.states[task][version]
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (mb *MemoryBackend) List(task string) ([]int, error) {
+	mb.rwmutex.RLock()
+	defer mb.rwmutex.RUnlock()
+	versions := make([]int, 0)
+	for version := range mb.states[task] {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func (mb *MemoryBackend) Delete(task string, version int) error {
+	mb.rwmutex.Lock()
+	defer mb.rwmutex.Unlock()
+	if _, ok := mb.states[task][version]; !ok {
+		return ErrStateNotFound
+	}
+	delete(mb.states[task], version)
+	return nil
+}
@@ -0,0 +1,37 @@
+package state
+
+// NamespacedBackend isolates the states of a topology in a backend shared
+// with other topologies, the task names are prefixed with the topology id
+type NamespacedBackend struct {
+	Backend   StateBackend
+	Namespace string
+}
+
+// Factory mode to return the NamespacedBackend instance
+func NewNamespacedBackend(backend StateBackend, namespace string) *NamespacedBackend {
+	return &NamespacedBackend{Backend: backend, Namespace: namespace}
+}
+
+func (nb *NamespacedBackend) Save(task string, version int, data []byte) error {
+	return nb.Backend.Save(nb.name(task), version, data)
+}
+
+func (nb *NamespacedBackend) Load(task string, version int) ([]byte, error) {
+	return nb.Backend.Load(nb.name(task), version)
+}
+
+func (nb *NamespacedBackend) List(task string) ([]int, error) {
+	return nb.Backend.List(nb.name(task))
+}
+
+func (nb *NamespacedBackend) Delete(task string, version int) error {
+	return nb.Backend.Delete(nb.name(task), version)
+}
+
+// Name of the task in the shared backend, e.g. wordcount-1_WordCountBolt_1
+func (nb *NamespacedBackend) name(task string) string {
+	if nb.Namespace == "" {
+		return task
+	}
+	return nb.Namespace + "_" + task
+}
@@ -0,0 +1,141 @@
+package state
+
+import (
+	"context"
+	sdfs "crane/simpledfs/client"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+)
+
+// SdfsBackend keeps the states in SDFS so that a task can be restored
+// on any machine. Since SDFS can not list files by prefix, the versions
+// of a task are tracked in an index file named <task>_versions. The index
+// is read from SDFS every time, the task may have saved it on another
+// machine before it moved
+type SdfsBackend struct {
+	Client *sdfs.Client
+	mutex  sync.Mutex
+}
+
+// Factory mode to return the SdfsBackend instance
+func NewSdfsBackend(masterAddr string) *SdfsBackend {
+	sb := &SdfsBackend{}
+	sb.Client = sdfs.NewClient(masterAddr)
+	return sb
+}
+
+func (sb *SdfsBackend) Save(task string, version int, data []byte) error {
+	if err := sb.putBytes(StateName(task, version), data); err != nil {
+		return err
+	}
+
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	versions, err := sb.loadIndex(task)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if v == version {
+			return nil
+		}
+	}
+	versions = append(versions, version)
+	sort.Ints(versions)
+	return sb.storeIndex(task, versions)
+}
+
+func (sb *SdfsBackend) Load(task string, version int) ([]byte, error) {
+	data, err := sb.getBytes(StateName(task, version))
+	if errors.Is(err, sdfs.ErrNotFound) {
+		return nil, ErrStateNotFound
+	}
+	return data, err
+}
+
+func (sb *SdfsBackend) List(task string) ([]int, error) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	versions, err := sb.loadIndex(task)
+	if err != nil {
+		return nil, err
+	}
+	return append([]int(nil), versions...), nil
+}
+
+func (sb *SdfsBackend) Delete(task string, version int) error {
+	err := sb.Client.Delete(context.Background(), StateName(task, version))
+	if errors.Is(err, sdfs.ErrNotFound) {
+		err = ErrStateNotFound
+	}
+
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	versions, indexErr := sb.loadIndex(task)
+	if indexErr != nil {
+		return indexErr
+	}
+	for i, v := range versions {
+		if v == version {
+			versions = append(versions[:i], versions[i+1:]...)
+			if indexErr := sb.storeIndex(task, versions); indexErr != nil {
+				return indexErr
+			}
+			break
+		}
+	}
+	return err
+}
+
+// Must be called with the mutex held, so that the saves of this process
+// do not lose each other's versions
+func (sb *SdfsBackend) loadIndex(task string) ([]int, error) {
+	versions := make([]int, 0)
+	data, err := sb.getBytes(task + "_versions")
+	if errors.Is(err, sdfs.ErrNotFound) {
+		return versions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Must be called with the mutex held
+func (sb *SdfsBackend) storeIndex(task string, versions []int) error {
+	data, _ := json.Marshal(versions)
+	return sb.putBytes(task+"_versions", data)
+}
+
+func (sb *SdfsBackend) putBytes(remoteName string, data []byte) error {
+	file, err := os.CreateTemp("", remoteName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	return sb.Client.Put(context.Background(), file.Name(), remoteName)
+}
+
+func (sb *SdfsBackend) getBytes(remoteName string) ([]byte, error) {
+	file, err := os.CreateTemp("", remoteName)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	if err := sb.Client.Get(context.Background(), remoteName, file.Name()); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file.Name())
+}
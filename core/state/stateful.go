@@ -0,0 +1,24 @@
+package state
+
+// Stateful is implemented by the bolts and spouts keeping state across
+// snapshots. The state is checkpointed as bytes encoded by the component
+// itself, so it is restored into its own types
+type Stateful interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// KeyedStateful is implemented by the bolts whose state is partitioned by
+// the key they are grouped by. When the bolt is rebalanced, the state of
+// every key moves to the task the key is routed to
+type KeyedStateful interface {
+	SnapshotKeys() ([]KeyedState, error)
+	RestoreKeys(states []KeyedState) error
+}
+
+// State of a key, the key is the value of the grouping field, or the
+// []interface{} of the values of the fields grouped by with GroupByFields
+type KeyedState struct {
+	Key   interface{}
+	State []byte
+}
@@ -1,59 +1,134 @@
-package main
+package supervisor
 
 import (
-	"crane/core/boltworker"
+	"context"
+	"crane/core/acker"
 	"crane/core/messages"
-	"crane/core/spoutworker"
+	"crane/core/state"
 	"crane/core/utils"
-	"flag"
+	sdfs "crane/simpledfs/client"
 	"fmt"
 	"log"
-	"os"
-	"os/exec"
-	"os/user"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// Directory of the local state backend in the data directory
+	STATE_DIR = "state"
+	// Number of the latest log lines kept for the logs requests
+	LOG_BUFFER_LINES = 10000
+)
+
 // Supervisor, the slave node for accepting the schedule from the master node
 // and execute the task, spouts or bolts
 type Supervisor struct {
-	Sub                      *messages.Subscriber
-	BoltWorkers              []*boltworker.BoltWorker
-	SpoutWorkers             []*spoutworker.SpoutWorker
-	VmIndexMap               map[int]string
-	FilePathMap              map[string]string
-	SerializeResponseCounter int
-	Mutex                    sync.Mutex
+	Sub           *messages.Subscriber
+	DriverAddrs   []string
+	Sdfs          *sdfs.Client
+	Topologies    map[string]*TopologyWorkers
+	FilePathMap   map[string]string
+	StateBackends map[string]state.StateBackend
+	Logs          *utils.LogBuffer
+	Capacity      utils.Resources
+	Slots         int
+	// Host and range of the ports the workers listen on, advertised to
+	// the driver, and the directory of the plugin files and the states
+	Host    string
+	PortMin int
+	PortMax int
+	DataDir string
+	// crane-worker binary running every worker in its own process,
+	// the workers run in the supervisor process if it is empty
+	WorkerBinary string
+	// Guards Topologies and their workers, read by the heartbeats
+	Mutex sync.Mutex
+	// Guards StateBackends, the crashed workers restart meanwhile
+	LockBackend sync.Mutex
+	closed      chan struct{}
+}
+
+// Workers of a topology running on the supervisor, every topology
+// is snapshotted, restored and killed separately
+type TopologyWorkers struct {
+	Id                       string
+	BoltWorkers              []*Worker
+	SpoutWorkers             []*Worker
+	Ackers                   []*acker.Acker
+	SerializeResponseCounter map[string]int
 	ControlC                 chan string
+	Listening                bool
+	// Workers started by name, the tasks moved here start later
+	Started map[string]bool
+	// Task messages to restart the crashed workers, the crashes of every
+	// task, and the latest snapshot version completed to restore from
+	BoltTasks        map[string]utils.BoltTaskMessage
+	SpoutTasks       map[string]utils.SpoutTaskMessage
+	Restarts         map[string]int
+	Failures         map[string]string
+	CompletedVersion int
+}
+
+// Factory mode to return the TopologyWorkers instance
+func NewTopologyWorkers(id string) *TopologyWorkers {
+	tw := &TopologyWorkers{}
+	tw.Id = id
+	tw.BoltWorkers = make([]*Worker, 0)
+	tw.SpoutWorkers = make([]*Worker, 0)
+	tw.Ackers = make([]*acker.Acker, 0)
+	tw.SerializeResponseCounter = make(map[string]int)
+	tw.ControlC = make(chan string)
+	tw.Started = make(map[string]bool)
+	tw.BoltTasks = make(map[string]utils.BoltTaskMessage)
+	tw.SpoutTasks = make(map[string]utils.SpoutTaskMessage)
+	tw.Restarts = make(map[string]int)
+	tw.Failures = make(map[string]string)
+	return tw
 }
 
 // Factory mode to return the Supervisor instance
-func NewSupervisor(driverAddr string) *Supervisor {
+func NewSupervisor(driverAddrs []string, sdfsMasterAddr string) *Supervisor {
 	supervisor := &Supervisor{}
-	supervisor.Sub = messages.NewSubscriber(driverAddr)
+	supervisor.Sub = messages.NewSubscriberOfAny(driverAddrs)
 	if supervisor.Sub == nil {
 		return nil
 	}
-	supervisor.BoltWorkers = make([]*boltworker.BoltWorker, 0)
-	supervisor.SpoutWorkers = make([]*spoutworker.SpoutWorker, 0)
-	supervisor.VmIndexMap = make(map[int]string)
+	supervisor.DriverAddrs = driverAddrs
+	supervisor.Sdfs = sdfs.NewClient(sdfsMasterAddr)
+	supervisor.Topologies = make(map[string]*TopologyWorkers)
+	supervisor.DataDir = "."
 	supervisor.FilePathMap = make(map[string]string)
-	supervisor.SerializeResponseCounter = 0
-	supervisor.ControlC = make(chan string)
+	supervisor.StateBackends = make(map[string]state.StateBackend)
+	supervisor.Logs = utils.NewLogBuffer(LOG_BUFFER_LINES)
+	supervisor.closed = make(chan struct{})
 	return supervisor
 }
 
+// Get the workers of the topology, created at its first task
+func (s *Supervisor) GetTopology(id string) *TopologyWorkers {
+	tw, ok := s.Topologies[id]
+	if !ok {
+		tw = NewTopologyWorkers(id)
+		s.Topologies[id] = tw
+	}
+	return tw
+}
+
 // Daemon function for supervisor service
 func (s *Supervisor) StartDaemon() {
 	go s.Sub.RequestMessage()
-	go s.Sub.ReadMessage()
+	go s.KeepConnected()
 	s.SendJoinRequest()
+	go s.SendHeartbeats()
 
 	for {
 		select {
+		case <-s.closed:
+			return
 		case rcvMsg := <-s.Sub.PublishBoard:
 			payload := utils.CheckType(rcvMsg.Payload)
 
@@ -62,58 +137,151 @@ func (s *Supervisor) StartDaemon() {
 				filePull := &utils.FilePull{}
 				utils.Unmarshal(payload.Content, filePull)
 				log.Printf("Receive File Pull with Filename %s\n", filePull.Filename)
-				if filePull.Filename != "None" {
+				if filePull.Filename != "None" && filePull.Filename != utils.LOCAL_PLUGIN {
 					s.GetFile(filePull.Filename)
 				}
 
 			case utils.BOLT_TASK:
 				task := &utils.BoltTaskMessage{}
 				utils.Unmarshal(payload.Content, task)
-				log.Printf("Receive Bolt Dispatch %s with Port %s, Previous workers %v\n", task.Name, task.Port, task.PrevBoltAddr)
-				supervisorC := make(chan string) // Channel to talk to the worker
-				workerC := make(chan string)     // Channel to listen to the worker
-				bw := boltworker.NewBoltWorker(1, task.Name, "./"+task.PluginFile, task.PluginSymbol,
-					task.Port, task.PrevBoltAddr, task.PrevBoltGroupingHint, task.PrevBoltFieldIndex,
-					task.SuccBoltGroupingHint, task.SuccBoltFieldIndex, supervisorC, workerC, task.SnapshotVersion)
-				s.BoltWorkers = append(s.BoltWorkers, bw)
+				log.Printf("Receive Bolt Dispatch %s of %s with Port %s, Previous workers %v\n", task.Name, task.Topology, task.Port, task.PrevBoltAddr)
+				// The worker failing to be created is restarted like a crashed one
+				bw, err := s.NewBoltWorker(task)
+				s.Mutex.Lock()
+				tw := s.GetTopology(task.Topology)
+				tw.BoltTasks[task.Name] = *task
+				if task.SnapshotVersion > tw.CompletedVersion {
+					tw.CompletedVersion = task.SnapshotVersion
+				}
+				if err != nil {
+					log.Println(err)
+					tw.Failures[task.Name] = err.Error()
+				} else {
+					tw.BoltWorkers = append(tw.BoltWorkers, bw)
+				}
+				s.Mutex.Unlock()
 
 			case utils.SPOUT_TASK:
 				task := &utils.SpoutTaskMessage{}
 				utils.Unmarshal(payload.Content, task)
-				log.Printf("Receive Spout Dispatch %s with Port %s\n", task.Name, task.Port)
-				supervisorC := make(chan string)
-				workerC := make(chan string)
-				sw := spoutworker.NewSpoutWorker(task.Name, "./"+task.PluginFile, task.PluginSymbol, task.Port,
-					task.GroupingHint, task.FieldIndex, supervisorC, workerC, task.SnapshotVersion)
-				s.SpoutWorkers = append(s.SpoutWorkers, sw)
+				log.Printf("Receive Spout Dispatch %s of %s with Port %s\n", task.Name, task.Topology, task.Port)
+				sw, err := s.NewSpoutWorker(task)
+				s.Mutex.Lock()
+				tw := s.GetTopology(task.Topology)
+				tw.SpoutTasks[task.Name] = *task
+				if task.SnapshotVersion > tw.CompletedVersion {
+					tw.CompletedVersion = task.SnapshotVersion
+				}
+				if err != nil {
+					log.Println(err)
+					tw.Failures[task.Name] = err.Error()
+				} else {
+					tw.SpoutWorkers = append(tw.SpoutWorkers, sw)
+				}
+				s.Mutex.Unlock()
+
+			case utils.ACKER_TASK:
+				task := &utils.AckerTaskMessage{}
+				utils.Unmarshal(payload.Content, task)
+				log.Printf("Receive Acker Dispatch %s of %s with Port %s\n", task.Name, task.Topology, task.Port)
+				// The acker starts at once, workers connect it when they start
+				a := acker.NewAcker(task.Name, task.Port, make(chan string), make(chan string))
+				s.Mutex.Lock()
+				tw := s.GetTopology(task.Topology)
+				tw.Ackers = append(tw.Ackers, a)
+				s.Mutex.Unlock()
+				go a.Start()
 
 			case utils.TASK_ALL_DISPATCHED:
-				log.Printf("Receive Task All Dispatched, Worker Start...\n")
-				for _, sw := range s.SpoutWorkers {
-					go sw.Start()
+				var id string
+				utils.Unmarshal(payload.Content, &id)
+				log.Printf("Receive Task All Dispatched of %s, Worker Start...\n", id)
+				s.Mutex.Lock()
+				tw := s.GetTopology(id)
+				for name := range tw.SpoutTasks {
+					if !tw.Started[name] {
+						tw.Started[name] = true
+						go s.RunWorker(tw, name, tw.worker(name))
+					}
+				}
+				for name := range tw.BoltTasks {
+					if !tw.Started[name] {
+						tw.Started[name] = true
+						go s.RunWorker(tw, name, tw.worker(name))
+					}
 				}
-				for _, bw := range s.BoltWorkers {
-					go bw.Start()
+				s.Mutex.Unlock()
+				if !tw.Listening {
+					time.Sleep(100 * time.Millisecond)
+					tw.Listening = true
+					go s.ListenToWorkers(tw)
+				}
+
+			case utils.REWIRE_REQUEST:
+				msg := &utils.RewireMessage{}
+				utils.Unmarshal(payload.Content, msg)
+				log.Printf("Receive Rewire Request of %s, Moved Tasks %v\n", msg.Topology, msg.Addrs)
+				if tw, ok := s.Topologies[msg.Topology]; ok && tw.Listening {
+					s.SendRewireRequestToWorkers(tw, msg)
 				}
-				time.Sleep(100 * time.Millisecond)
-				go s.ListenToWorkers()
 
 			case utils.SUSPEND_REQUEST:
-				log.Printf("Receive Suspend Request\n")
-				s.SendSuspendRequestToWorkers()
+				var id string
+				utils.Unmarshal(payload.Content, &id)
+				log.Printf("Receive Suspend Request of %s\n", id)
+				if tw, ok := s.Topologies[id]; ok && tw.Listening {
+					s.SendSuspendRequestToWorkers(tw)
+				}
+
+			case utils.RESUME_REQUEST:
+				var id string
+				utils.Unmarshal(payload.Content, &id)
+				log.Printf("Receive Resume Request of %s\n", id)
+				if tw, ok := s.Topologies[id]; ok && tw.Listening {
+					s.SendResumeRequestToWorkers(tw)
+				}
 
 			case utils.SNAPSHOT_REQUEST:
-				var version int
-				utils.Unmarshal(payload.Content, &version)
-				log.Printf("Receive Snapshot Request With Version %d\n", version)
-				s.SendSerializeRequestToWorkers(strconv.Itoa(version))
+				msg := &utils.SnapshotMessage{}
+				utils.Unmarshal(payload.Content, msg)
+				log.Printf("Receive Snapshot Request of %s With Version %d\n", msg.Topology, msg.Version)
+				if tw, ok := s.Topologies[msg.Topology]; ok && tw.Listening {
+					s.SendSerializeRequestToWorkers(tw, strconv.Itoa(msg.Version))
+				}
+
+			case utils.SNAPSHOT_COMMIT:
+				msg := &utils.SnapshotMessage{}
+				utils.Unmarshal(payload.Content, msg)
+				log.Printf("Receive Snapshot Commit of %s With Version %d\n", msg.Topology, msg.Version)
+				s.Mutex.Lock()
+				if tw, ok := s.Topologies[msg.Topology]; ok && msg.Version > tw.CompletedVersion {
+					tw.CompletedVersion = msg.Version
+				}
+				s.Mutex.Unlock()
+				if tw, ok := s.Topologies[msg.Topology]; ok && tw.Listening {
+					s.SendCommitRequestToWorkers(tw, strconv.Itoa(msg.Version))
+				}
 
 			case utils.RESTORE_REQUEST:
-				s.ControlC <- "Close"
-				log.Printf("Receive Restore Request")
-				// Clear supervisor's worker map
-				s.BoltWorkers = make([]*boltworker.BoltWorker, 0)
-				s.SpoutWorkers = make([]*spoutworker.SpoutWorker, 0)
+				var id string
+				utils.Unmarshal(payload.Content, &id)
+				log.Printf("Receive Restore Request of %s", id)
+				s.StopTopology(id)
+
+			case utils.KILL_REQUEST:
+				var id string
+				utils.Unmarshal(payload.Content, &id)
+				log.Printf("Receive Kill Request of %s", id)
+				s.StopTopology(id)
+
+			case utils.LOGS_REQUEST:
+				query := &utils.TopologyQuery{}
+				utils.Unmarshal(payload.Content, query)
+				b, _ := utils.MarshalRequest(utils.LOGS_RESPONSE, payload.Header.RequestId, s.Logs.Tail(query.Task, query.Lines))
+				s.Sub.Request <- messages.Message{
+					Payload:      b,
+					TargetConnId: s.Sub.Conn.RemoteAddr().String(),
+				}
 			}
 			/*default:*/
 			/*time.Sleep(10 * time.Millisecond)*/
@@ -122,10 +290,82 @@ func (s *Supervisor) StartDaemon() {
 
 }
 
+// Keep connected to the leader of the drivers. When the connection breaks,
+// the supervisor joins the driver taking over, and stops the workers of
+// all the topologies, as the driver rebuilds them from their snapshots
+func (s *Supervisor) KeepConnected() {
+	for {
+		s.Sub.ReadMessage()
+		select {
+		case <-s.closed:
+			return
+		default:
+		}
+		log.Println("Lost Connection To Driver, Reconnecting...")
+		for !s.Sub.Redial(s.DriverAddrs) {
+			time.Sleep(utils.HEARTBEAT_INTERVAL * time.Second)
+		}
+		log.Printf("Reconnected To Driver %s\n", s.Sub.Conn.RemoteAddr())
+		s.StopTopologies()
+		s.SendJoinRequest()
+	}
+}
+
+// Stop the supervisor and the workers of all the topologies, it does
+// not reconnect to the driver
+func (s *Supervisor) Stop() {
+	close(s.closed)
+	s.StopTopologies()
+	s.Sub.Conn.Close()
+}
+
+// Stop the workers of all the topologies on the supervisor
+func (s *Supervisor) StopTopologies() {
+	s.Mutex.Lock()
+	ids := make([]string, 0)
+	for id := range s.Topologies {
+		ids = append(ids, id)
+	}
+	s.Mutex.Unlock()
+	for _, id := range ids {
+		s.StopTopology(id)
+	}
+}
+
+// Workers of the topology at the moment, the crashed ones are
+// replaced by the restarted ones meanwhile
+func (s *Supervisor) Workers(tw *TopologyWorkers) ([]*Worker, []*Worker) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return append([]*Worker{}, tw.BoltWorkers...), append([]*Worker{}, tw.SpoutWorkers...)
+}
+
+// Worker of the task, nil if it failed to be created
+func (tw *TopologyWorkers) worker(name string) *Worker {
+	for _, sw := range tw.SpoutWorkers {
+		if sw.Name == name {
+			return sw
+		}
+	}
+	for _, bw := range tw.BoltWorkers {
+		if bw.Name == name {
+			return bw
+		}
+	}
+	return nil
+}
+
 // Send join request to join the cluster
 func (s *Supervisor) SendJoinRequest() {
 	log.Printf("Send Join Request")
-	join := utils.JoinRequest{Name: "vm [" + s.Sub.Conn.LocalAddr().String() + "]"}
+	join := utils.JoinRequest{
+		Name:     "vm [" + s.Sub.Conn.LocalAddr().String() + "]",
+		Host:     s.Host,
+		PortMin:  s.PortMin,
+		PortMax:  s.PortMax,
+		Capacity: s.Capacity,
+		Slots:    s.Slots,
+	}
 	b, err := utils.Marshal(utils.JOIN_REQUEST, join)
 	if err != nil {
 		log.Println(err)
@@ -137,9 +377,97 @@ func (s *Supervisor) SendJoinRequest() {
 	}
 }
 
-// Listen workers reply through channels
+// Send the heartbeats to the driver periodically
+func (s *Supervisor) SendHeartbeats() {
+	for {
+		select {
+		case <-s.closed:
+			return
+		default:
+		}
+		b, _ := utils.Marshal(utils.HEARTBEAT, s.Heartbeat())
+		s.Sub.Request <- messages.Message{
+			Payload:      b,
+			TargetConnId: s.Sub.Conn.RemoteAddr().String(),
+		}
+		time.Sleep(utils.HEARTBEAT_INTERVAL * time.Second)
+	}
+}
+
+// Heartbeat with the capacity of the supervisor, the resources used
+// and the status of all the workers
+func (s *Supervisor) Heartbeat() utils.Heartbeat {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	hb := utils.Heartbeat{
+		Capacity: s.Capacity,
+		Slots:    s.Slots,
+		Used:     utils.Resources{CPU: utils.GetLoadAverage(), Memory: int(mem.Sys >> 20)},
+		Workers:  make([]utils.WorkerStatus, 0),
+	}
+	s.Mutex.Lock()
+	for _, tw := range s.Topologies {
+		statuses := make([]utils.WorkerStatus, 0)
+		for _, sw := range tw.SpoutWorkers {
+			statuses = append(statuses, sw.Status())
+		}
+		for _, bw := range tw.BoltWorkers {
+			statuses = append(statuses, bw.Status())
+		}
+		for _, a := range tw.Ackers {
+			statuses = append(statuses, a.Status())
+		}
+		// The tasks whose workers failed to be created
+		reported := make(map[string]bool)
+		for _, status := range statuses {
+			reported[status.Task] = true
+		}
+		for name, task := range tw.BoltTasks {
+			if !reported[name] {
+				statuses = append(statuses, utils.WorkerStatus{Task: task.Name, Kind: "bolt", State: utils.WORKER_FAILED})
+			}
+		}
+		for name, task := range tw.SpoutTasks {
+			if !reported[name] {
+				statuses = append(statuses, utils.WorkerStatus{Task: task.Name, Kind: "spout", State: utils.WORKER_FAILED})
+			}
+		}
+		for _, status := range statuses {
+			status.Topology = tw.Id
+			status.Restarts = tw.Restarts[status.Task]
+			if status.Error == "" {
+				status.Error = tw.Failures[status.Task]
+			}
+			hb.Workers = append(hb.Workers, status)
+		}
+	}
+	s.Mutex.Unlock()
+	hb.FreeSlots = s.Slots - len(hb.Workers)
+	return hb
+}
+
+// Stop the workers of the topology and clear them
+func (s *Supervisor) StopTopology(id string) {
+	s.Mutex.Lock()
+	tw, ok := s.Topologies[id]
+	delete(s.Topologies, id)
+	s.Mutex.Unlock()
+	if !ok {
+		return
+	}
+	if tw.Listening {
+		tw.ControlC <- "Close"
+	} else {
+		// The workers are not started yet, only the acker is
+		for _, a := range tw.Ackers {
+			tell(a.SupervisorC, fmt.Sprintf("2. Please Kill Yourself"))
+		}
+	}
+}
+
+// Listen workers of the topology reply through channels
 // Should be closed when receive restore request
-func (s *Supervisor) ListenToWorkers() {
+func (s *Supervisor) ListenToWorkers(tw *TopologyWorkers) {
 	// Message Type:
 	// Superviosr -> Worker
 	// 1. Please Serialize Variables With Version X    Superviosr -> Worker
@@ -154,45 +482,37 @@ func (s *Supervisor) ListenToWorkers() {
 	for {
 		select {
 		// Channel to close this goroutine
-		case signal := <-s.ControlC:
-			s.SendKillRequestToWorkers()
+		case signal := <-tw.ControlC:
+			s.SendKillRequestToWorkers(tw)
 			time.Sleep(120 * time.Millisecond)
 			log.Printf("Receive Signal %s, Listen To Workers Return\n", signal)
 			return
 		default:
 		}
 
-		for _, bw := range s.BoltWorkers {
+		// The workers of the tasks moved here are added meanwhile,
+		// and the crashed ones are replaced
+		boltWorkers, spoutWorkers := s.Workers(tw)
+
+		for _, bw := range boltWorkers {
 			select {
 			case message := <-bw.WorkerC:
 				switch string(message[0]) {
 				case "1":
-					s.PutFile("./"+bw.Name+"_"+bw.Version, bw.Name+"_"+bw.Version)
-					s.SerializeResponseCounter += 1
-					if s.SerializeResponseCounter == (len(s.BoltWorkers) + len(s.SpoutWorkers)) {
-						s.SerializeResponseCounter = 0
-						s.SendSerializeResponseToDriver()
-						s.SendResumeRequestToWorkers()
-					}
+					s.CountSerializeResponse(tw, message)
 				}
 			default:
 			}
 		}
 
-		for _, sw := range s.SpoutWorkers {
+		for _, sw := range spoutWorkers {
 			select {
 			case message := <-sw.WorkerC:
 				switch string(message[0]) {
 				case "1":
-					go s.PutFile("./"+sw.Name+"_"+sw.Version, sw.Name+"_"+sw.Version)
-					s.SerializeResponseCounter += 1
-					if s.SerializeResponseCounter == (len(s.BoltWorkers) + len(s.SpoutWorkers)) {
-						s.SerializeResponseCounter = 0
-						s.SendResumeRequestToWorkers()
-						s.SendSerializeResponseToDriver()
-					}
+					s.CountSerializeResponse(tw, message)
 				case "2":
-					s.SendSuspendResponseToDriver()
+					s.SendSuspendResponseToDriver(tw)
 				}
 			default:
 			}
@@ -205,153 +525,149 @@ func (s *Supervisor) ListenToWorkers() {
 	// wg.Wait()
 }
 
-// Notify the driver that the spout is suspended
-func (s *Supervisor) SendSuspendResponseToDriver() {
-	log.Println("Send Suspend Reponse To Driver")
-	b, _ := utils.Marshal(utils.SUSPEND_RESPONSE, "OK")
+// Notify the driver that the spout of the topology is suspended
+func (s *Supervisor) SendSuspendResponseToDriver(tw *TopologyWorkers) {
+	log.Printf("Send Suspend Reponse of %s To Driver\n", tw.Id)
+	b, _ := utils.Marshal(utils.SUSPEND_RESPONSE, tw.Id)
 	s.Sub.Request <- messages.Message{
 		Payload:      b,
 		TargetConnId: s.Sub.Conn.RemoteAddr().String(),
 	}
 }
 
-// Notify the driver that the serialize is finished
-func (s *Supervisor) SendSerializeResponseToDriver() {
-	log.Println("Send Serialize Reponse To Driver")
-	b, _ := utils.Marshal(utils.SNAPSHOT_RESPONSE, "OK")
+// Count the workers serialized their variables for the snapshot version,
+// the supervisor has completed the version when all its workers did
+func (s *Supervisor) CountSerializeResponse(tw *TopologyWorkers, message string) {
+	words := strings.Fields(message)
+	version := words[len(words)-1]
+	tw.SerializeResponseCounter[version] += 1
+	boltWorkers, spoutWorkers := s.Workers(tw)
+	if tw.SerializeResponseCounter[version] == (len(boltWorkers) + len(spoutWorkers)) {
+		delete(tw.SerializeResponseCounter, version)
+		s.SendSerializeResponseToDriver(tw, version)
+	}
+}
+
+// Notify the driver that the serialize of the topology is finished
+func (s *Supervisor) SendSerializeResponseToDriver(tw *TopologyWorkers, version string) {
+	log.Printf("Send Serialize Reponse of %s With Version %s To Driver\n", tw.Id, version)
+	v, _ := strconv.Atoi(version)
+	b, _ := utils.Marshal(utils.SNAPSHOT_RESPONSE, utils.SnapshotMessage{Topology: tw.Id, Version: v})
 	s.Sub.Request <- messages.Message{
 		Payload:      b,
 		TargetConnId: s.Sub.Conn.RemoteAddr().String(),
 	}
 }
 
-// Notify all workers to serialize their variables
-func (s *Supervisor) SendSerializeRequestToWorkers(version string) {
+// Notify spout workers to serialize their variables and inject the
+// checkpoint barrier, bolt workers serialize when the barriers arrive
+func (s *Supervisor) SendSerializeRequestToWorkers(tw *TopologyWorkers, version string) {
 	// Message Type:
 	// Superviosr -> Worker
-	// 1. Please Serialize Variables With Version X    Superviosr -> Worker
+	// 1. Please Serialize Variables With Version X    Superviosr -> Spout Worker
 	// 2. Please Kill Yourself                         Superviosr -> Worker
 	// 3. Please Suspend                               Superviosr -> Spout Worker
 	// 4. Please Resume                                Superviosr -> Spout Worker
+	// 5. Please Commit Version X                      Superviosr -> Bolt Worker
+	// 6. Please Rewire old>new ... Abandoning X       Superviosr -> Bolt Worker
 	// Worker -> Supervisor
 	// 1. Serialized Variables With Version X          Worker -> Supervisor
 	// 2. W Suspended                                  Worker -> Supervisor
 
-	log.Println("Send Serialize Request to Workers")
-	for _, bw := range s.BoltWorkers {
-		bw.SupervisorC <- fmt.Sprintf("1. Please Serialize Variables With Version %s", version)
-	}
-	for _, sw := range s.SpoutWorkers {
-		sw.SupervisorC <- fmt.Sprintf("1. Please Serialize Variables With Version %s", version)
+	log.Println("Send Serialize Request to Spout Workers")
+	_, spoutWorkers := s.Workers(tw)
+	for _, sw := range spoutWorkers {
+		tell(sw.SupervisorC, fmt.Sprintf("1. Please Serialize Variables With Version %s", version))
 	}
 }
 
 // Notify all workers to kill themselves
-func (s *Supervisor) SendKillRequestToWorkers() {
+func (s *Supervisor) SendKillRequestToWorkers(tw *TopologyWorkers) {
 	log.Println("Send Kill Request to Workers")
-	for _, bw := range s.BoltWorkers {
-		bw.SupervisorC <- fmt.Sprintf("2. Please Kill Yourself")
+	boltWorkers, spoutWorkers := s.Workers(tw)
+	for _, bw := range boltWorkers {
+		tell(bw.SupervisorC, fmt.Sprintf("2. Please Kill Yourself"))
+	}
+	for _, sw := range spoutWorkers {
+		tell(sw.SupervisorC, fmt.Sprintf("2. Please Kill Yourself"))
+	}
+	for _, a := range tw.Ackers {
+		tell(a.SupervisorC, fmt.Sprintf("2. Please Kill Yourself"))
 	}
-	for _, sw := range s.SpoutWorkers {
-		sw.SupervisorC <- fmt.Sprintf("2. Please Kill Yourself")
+}
+
+// Notify bolt workers the snapshot version is completed
+func (s *Supervisor) SendCommitRequestToWorkers(tw *TopologyWorkers, version string) {
+	log.Println("Send Commit Request to Bolt Workers")
+	boltWorkers, _ := s.Workers(tw)
+	for _, bw := range boltWorkers {
+		tell(bw.SupervisorC, fmt.Sprintf("5. Please Commit Version %s", version))
+	}
+}
+
+// Ask bolt workers to subscribe to the new addresses of the moved tasks
+// and drop the barriers of the abandoned snapshot version
+func (s *Supervisor) SendRewireRequestToWorkers(tw *TopologyWorkers, msg *utils.RewireMessage) {
+	log.Println("Send Rewire Request to Bolt Workers")
+	pairs := make([]string, 0)
+	for old, addr := range msg.Addrs {
+		pairs = append(pairs, old+">"+addr)
+	}
+	boltWorkers, _ := s.Workers(tw)
+	for _, bw := range boltWorkers {
+		tell(bw.SupervisorC, fmt.Sprintf("6. Please Rewire %s Abandoning %d", strings.Join(pairs, " "), msg.Abandoned))
 	}
 }
 
 // Ask spout to suspend
-func (s *Supervisor) SendSuspendRequestToWorkers() {
+func (s *Supervisor) SendSuspendRequestToWorkers(tw *TopologyWorkers) {
 	log.Println("Send Suspend Request to Spout Workers")
-	for _, sw := range s.SpoutWorkers {
-		sw.SupervisorC <- fmt.Sprintf("3. Please Suspend")
+	_, spoutWorkers := s.Workers(tw)
+	for _, sw := range spoutWorkers {
+		tell(sw.SupervisorC, fmt.Sprintf("3. Please Suspend"))
 	}
 }
 
 // Ask spout to resume
-func (s *Supervisor) SendResumeRequestToWorkers() {
+func (s *Supervisor) SendResumeRequestToWorkers(tw *TopologyWorkers) {
 	log.Println("Send Resume Request to Spout Workers")
-	for _, sw := range s.SpoutWorkers {
-		sw.SupervisorC <- fmt.Sprintf("4. Please Resume")
+	_, spoutWorkers := s.Workers(tw)
+	for _, sw := range spoutWorkers {
+		tell(sw.SupervisorC, fmt.Sprintf("4. Please Resume"))
 	}
 }
 
 // Get the plugin file from distributed file system
-func (s *Supervisor) GetFile(remoteName string) {
-	time.Sleep(1 * time.Second)
+func (s *Supervisor) GetFile(remoteName string) error {
 	_, ok := s.FilePathMap[remoteName]
 	if ok {
-		return
+		return nil
 	}
-	// Execute the sdfs client to get the remote file
-	usr, _ := user.Current()
-	usrHome := usr.HomeDir
-	// exec.Command(usrHome+"/go/src/crane/tools/sdfs_client/sdfs_client", "-master", "fa18-cs425-g29-01.cs.illinois.edu:5000", "get", remoteName, "./"+remoteName)
-	cmd := exec.Command(usrHome+"/go/src/crane/tools/sdfs_client/sdfs_client", "-master", "fa18-cs425-g29-01.cs.illinois.edu:5000", "get", remoteName, "./"+remoteName)
-	stdoutStderr, err := cmd.CombinedOutput()
+	localPath := filepath.Join(s.DataDir, remoteName)
+	err := s.Sdfs.Get(context.Background(), remoteName, localPath)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return err
 	}
-	log.Printf("%s\n", stdoutStderr)
-	s.FilePathMap[remoteName] = "./" + remoteName
+	s.FilePathMap[remoteName] = localPath
 	log.Printf("Get File %s", remoteName)
+	return nil
 }
 
-// Put State File into Distributed File System
-func (s *Supervisor) PutFile(localPath, remoteName string) {
-	time.Sleep(1 * time.Second)
-	// Execute the sdfs client to put the local file into remote
-	usr, _ := user.Current()
-	usrHome := usr.HomeDir
-	// exec.Command(usrHome+"/go/src/crane/tools/sdfs_client/sdfs_client", "-master", "fa18-cs425-g29-01.cs.illinois.edu:5000", "put", localPath, remoteName)
-	cmd := exec.Command(usrHome+"/go/src/crane/tools/sdfs_client/sdfs_client", "-master", "fa18-cs425-g29-01.cs.illinois.edu:5000", "put", localPath, remoteName)
-	stdoutStderr, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Printf("%s\n", stdoutStderr)
-	log.Printf("Put File %s", remoteName)
-}
-
-func main() {
-	driverIpPtr := flag.String("h", "127.0.0.1", "Driver's IP address")
-	vmIndexPtr := flag.Int("vm", 0, "VM index in cluster")
-	flag.Parse()
-	vms := utils.GetVmMap()
-	var ip string
-	if *vmIndexPtr <= 0 && *driverIpPtr == "127.0.0.1" {
-		ip = *driverIpPtr
-		log.Println("Enter Local Mode")
-	} else if *vmIndexPtr != 0 {
-		if *vmIndexPtr > 10 {
-			log.Fatal("VM Cluster Index out of range")
-			return
-		} else {
-			ip = vms[*vmIndexPtr]
-			log.Println("Enter Cluster mode")
-		}
-	} else {
-		ip = *driverIpPtr
-		log.Println("Enter Remote Mode")
-	}
-
-	// remove all sdfs files set up before
-	files, err := filepath.Glob("./*_*")
-	if err != nil {
-		log.Println(err)
-	}
-	for _, f := range files {
-		if err := os.Remove(f); err != nil {
-			log.Println(err)
+// Get the state backend of the kind selected by the topology, backends
+// are shared by all the workers of this supervisor, and the states of
+// each topology are isolated in its namespace
+func (s *Supervisor) GetStateBackend(topologyId string, kind string) (state.StateBackend, error) {
+	s.LockBackend.Lock()
+	defer s.LockBackend.Unlock()
+	backend, ok := s.StateBackends[kind]
+	if !ok {
+		var err error
+		backend, err = state.NewBackend(kind, filepath.Join(s.DataDir, STATE_DIR), s.Sdfs.MasterAddr)
+		if err != nil {
+			return nil, err
 		}
+		s.StateBackends[kind] = backend
 	}
-
-	LocalIP := utils.GetLocalIP().String()
-	LocalHostname := utils.GetLocalHostname()
-	log.Printf("Local Machine Info [%s] [%s]\n", LocalIP, LocalHostname)
-
-	supervisor := NewSupervisor(ip + ":" + fmt.Sprintf("%d", utils.DRIVER_PORT))
-	if supervisor == nil {
-		log.Println("Initialize supervisor failed")
-		return
-	}
-	supervisor.VmIndexMap = vms
-	supervisor.StartDaemon()
+	return state.NewNamespacedBackend(backend, topologyId), nil
 }
@@ -0,0 +1,219 @@
+package supervisor
+
+import (
+	"bufio"
+	"crane/core/messages"
+	"crane/core/utils"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Time for a crane-worker process to connect to the socket of its supervisor
+const WORKER_CONNECT_TIMEOUT = 10 * time.Second
+
+// ProcessWorker runs the spout or bolt of a task in a crane-worker process,
+// so that a bad plugin takes down only its own task. The process talks to
+// the supervisor over a unix socket, one JSON payload per frame
+type ProcessWorker struct {
+	Name        string
+	Kind        string
+	Binary      string
+	Socket      string
+	SdfsAddr    string
+	DataDir     string
+	TaskType    string
+	Task        interface{}
+	SupervisorC chan string
+	WorkerC     chan string
+	// Stdout and stderr of the process, the supervisor logs
+	Output   io.Writer
+	ExitCode int32
+	// Set once the supervisor asked the process to exit
+	killed  int32
+	status  atomic.Value
+	failure atomic.Value
+	exited  chan struct{}
+}
+
+// Factory mode to return the Worker instance running the task message in
+// a crane-worker process
+func (s *Supervisor) NewProcessWorker(topologyId string, name string, taskType string, task interface{}) *Worker {
+	pw := &ProcessWorker{}
+	pw.Name = name
+	pw.Kind = "bolt"
+	if taskType == utils.SPOUT_TASK {
+		pw.Kind = "spout"
+	}
+	pw.Binary = s.WorkerBinary
+	pw.Socket = filepath.Join(os.TempDir(), fmt.Sprintf("crane-%d-%s-%s.sock", os.Getpid(), topologyId, name))
+	pw.SdfsAddr = s.Sdfs.MasterAddr
+	pw.DataDir = s.DataDir
+	pw.TaskType = taskType
+	pw.Task = task
+	pw.SupervisorC = make(chan string)
+	pw.WorkerC = make(chan string)
+	pw.Output = log.Writer()
+	pw.exited = make(chan struct{})
+	return &Worker{Name: name, SupervisorC: pw.SupervisorC, WorkerC: pw.WorkerC, Runner: pw}
+}
+
+// Start the process of the worker and relay the messages between it and
+// the supervisor, return when the process exits
+func (pw *ProcessWorker) Start() {
+	defer close(pw.exited)
+	os.Remove(pw.Socket)
+	listener, err := net.Listen("unix", pw.Socket)
+	if err != nil {
+		pw.crash(err.Error())
+		return
+	}
+	defer os.Remove(pw.Socket)
+	defer listener.Close()
+
+	cmd := exec.Command(pw.Binary, "-socket", pw.Socket, "-sdfs", pw.SdfsAddr,
+		"-dir", pw.DataDir, "-state", filepath.Join(pw.DataDir, STATE_DIR))
+	cmd.Stdout = pw.Output
+	cmd.Stderr = pw.Output
+	if err := cmd.Start(); err != nil {
+		pw.crash(err.Error())
+		return
+	}
+	log.Printf("Worker %s Started in Process %d\n", pw.Name, cmd.Process.Pid)
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+
+	listener.(*net.UnixListener).SetDeadline(time.Now().Add(WORKER_CONNECT_TIMEOUT))
+	conn, err := listener.Accept()
+	if err != nil {
+		cmd.Process.Kill()
+		pw.exit(<-waited, "worker process did not connect: "+err.Error())
+		return
+	}
+	defer conn.Close()
+	if _, err := messages.Handshake(conn); err != nil {
+		cmd.Process.Kill()
+		pw.exit(<-waited, err.Error())
+		return
+	}
+	b, _ := utils.Marshal(pw.TaskType, pw.Task)
+	if err := messages.WriteFrame(conn, b); err != nil {
+		cmd.Process.Kill()
+		pw.exit(<-waited, err.Error())
+		return
+	}
+
+	// Relay the messages of the supervisor until the process exits
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		for {
+			select {
+			case message := <-pw.SupervisorC:
+				if strings.HasPrefix(message, "2.") {
+					atomic.StoreInt32(&pw.killed, 1)
+				}
+				b, _ := utils.Marshal(utils.WORKER_MESSAGE, message)
+				if err := messages.WriteFrame(conn, b); err != nil {
+					log.Printf("Send To Worker %s Failed: %v\n", pw.Name, err)
+				}
+			case <-closed:
+				return
+			}
+		}
+	}()
+
+	reason := ""
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := messages.ReadFrame(reader)
+		if err != nil {
+			break
+		}
+		payload := utils.CheckType(line)
+		switch payload.Header.Type {
+		case utils.WORKER_MESSAGE:
+			var message string
+			utils.Unmarshal(payload.Content, &message)
+			// Nobody listens once the topology is stopped
+			select {
+			case pw.WorkerC <- message:
+			case <-time.After(WORKER_SEND_TIMEOUT):
+				log.Printf("Supervisor Does Not Read %q of Worker %s\n", message, pw.Name)
+			}
+		case utils.WORKER_STATUS:
+			status := utils.WorkerStatus{}
+			utils.Unmarshal(payload.Content, &status)
+			pw.status.Store(status)
+		case utils.WORKER_FAILURE:
+			failure := utils.WorkerFailure{}
+			utils.Unmarshal(payload.Content, &failure)
+			reason = failure.Reason
+		}
+	}
+	pw.exit(<-waited, reason)
+}
+
+// Collect the exit code of the process, the worker crashed unless
+// it exited with 0 after being killed by the supervisor. The reason
+// the process reported is kept, even if it exited with 0
+func (pw *ProcessWorker) exit(err error, reason string) {
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		code = -1
+	}
+	atomic.StoreInt32(&pw.ExitCode, int32(code))
+	log.Printf("Worker %s Process Exited With Code %d\n", pw.Name, code)
+	if err == nil && reason == "" && atomic.LoadInt32(&pw.killed) == 1 {
+		return
+	}
+	switch {
+	case err == nil && reason == "":
+		reason = "worker process exited without being killed"
+	case reason == "":
+		reason = err.Error()
+	case err != nil:
+		reason = fmt.Sprintf("%s (%v)", reason, err)
+	}
+	pw.crash(reason)
+}
+
+// Record the reason the worker crashed for
+func (pw *ProcessWorker) crash(reason string) {
+	log.Printf("Worker %s Crashed: %s\n", pw.Name, reason)
+	pw.failure.Store(reason)
+}
+
+// Reason the worker crashed for, empty if it did not crash
+func (pw *ProcessWorker) Failure() string {
+	reason, _ := pw.failure.Load().(string)
+	return reason
+}
+
+// Status of the worker last reported by its process
+func (pw *ProcessWorker) Status() utils.WorkerStatus {
+	status, ok := pw.status.Load().(utils.WorkerStatus)
+	if !ok {
+		status = utils.WorkerStatus{Task: pw.Name, Kind: pw.Kind, State: utils.WORKER_RUNNING}
+	}
+	select {
+	case <-pw.exited:
+		status.State = utils.WORKER_DEAD
+	default:
+	}
+	if status.Error = pw.Failure(); status.Error != "" {
+		status.State = utils.WORKER_FAILED
+	}
+	return status
+}
@@ -0,0 +1,199 @@
+package supervisor
+
+import (
+	"crane/core/boltworker"
+	"crane/core/messages"
+	"crane/core/spoutworker"
+	"crane/core/utils"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// Time to wait for a worker to read a message, a crashed worker
+// does not read its channel any more
+const WORKER_SEND_TIMEOUT = time.Second
+
+// Runs the spout or bolt of a task, in the goroutines of the
+// supervisor or in a crane-worker process
+type Runner interface {
+	Start()
+	Failure() string
+	Status() utils.WorkerStatus
+}
+
+// Worker of a task run and restarted by the supervisor
+type Worker struct {
+	Name        string
+	SupervisorC chan string // Channel to talk to the worker
+	WorkerC     chan string // Channel to listen to the worker
+	Runner
+}
+
+// Create the bolt worker of the task message
+func (s *Supervisor) NewBoltWorker(task *utils.BoltTaskMessage) (*Worker, error) {
+	if s.WorkerBinary != "" {
+		return s.NewProcessWorker(task.Topology, task.Name, utils.BOLT_TASK, task), nil
+	}
+	stateBackend, err := s.GetStateBackend(task.Topology, task.StateBackend)
+	if err != nil {
+		return nil, err
+	}
+	supervisorC := make(chan string)
+	workerC := make(chan string)
+	bw, err := boltworker.NewBoltWorker(1, task.Name, filepath.Join(s.DataDir, task.PluginFile), task.PluginSymbol,
+		task.Port, task.PrevBoltAddr, task.PrevBoltGroupingHint, task.PrevBoltFieldIndex,
+		task.SuccBoltGroupingHint, task.SuccBoltFieldIndex, task.SuccStreams, task.SuccFieldIndexes, supervisorC, workerC, task.SnapshotVersion,
+		stateBackend, task.AckerAddr, task.InstNum, task.StateInstNum, task.Codec, task.Batch)
+	if err != nil {
+		return nil, err
+	}
+	return &Worker{Name: task.Name, SupervisorC: supervisorC, WorkerC: workerC, Runner: bw}, nil
+}
+
+// Create the spout worker of the task message
+func (s *Supervisor) NewSpoutWorker(task *utils.SpoutTaskMessage) (*Worker, error) {
+	if s.WorkerBinary != "" {
+		return s.NewProcessWorker(task.Topology, task.Name, utils.SPOUT_TASK, task), nil
+	}
+	stateBackend, err := s.GetStateBackend(task.Topology, task.StateBackend)
+	if err != nil {
+		return nil, err
+	}
+	supervisorC := make(chan string)
+	workerC := make(chan string)
+	sw, err := spoutworker.NewSpoutWorker(task.Name, filepath.Join(s.DataDir, task.PluginFile), task.PluginSymbol, task.Port,
+		task.GroupingHint, task.FieldIndex, task.SuccStreams, task.SuccFieldIndexes, supervisorC, workerC, task.SnapshotVersion, stateBackend,
+		task.AckerAddr, task.AckTimeout, task.Batch)
+	if err != nil {
+		return nil, err
+	}
+	return &Worker{Name: task.Name, SupervisorC: supervisorC, WorkerC: workerC, Runner: sw}, nil
+}
+
+// Run the worker of the task, and restart it from the latest completed
+// snapshot every time it crashes. The backoff before a restart doubles on
+// every crash in a row, the worker is nil if it failed to be created
+func (s *Supervisor) RunWorker(tw *TopologyWorkers, name string, w *Worker) {
+	backoff := utils.WORKER_RESTART_BACKOFF * time.Second
+	for {
+		var reason string
+		if w != nil {
+			started := time.Now()
+			w.Start()
+			reason = w.Failure()
+			// Killed by the supervisor
+			if reason == "" {
+				return
+			}
+			if time.Since(started) > utils.WORKER_STABLE_TIME*time.Second {
+				backoff = utils.WORKER_RESTART_BACKOFF * time.Second
+			}
+		}
+		s.Mutex.Lock()
+		if w != nil {
+			tw.Failures[name] = reason
+		}
+		reason = tw.Failures[name]
+		restarts := tw.Restarts[name]
+		s.Mutex.Unlock()
+		s.SendWorkerFailureToDriver(utils.WORKER_FAILURE, tw.Id, name, reason, restarts)
+
+		log.Printf("Restart Worker %s of %s in %v\n", name, tw.Id, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > utils.WORKER_RESTART_MAX_BACKOFF*time.Second {
+			backoff = utils.WORKER_RESTART_MAX_BACKOFF * time.Second
+		}
+		// The topology is killed or restored meanwhile
+		if !s.Running(tw) {
+			return
+		}
+		w = s.RestartWorker(tw, name)
+		if w != nil {
+			s.SendWorkerFailureToDriver(utils.WORKER_RESTART, tw.Id, name, reason, restarts+1)
+		}
+	}
+}
+
+// Create the worker of the task again from the latest completed snapshot,
+// replacing the crashed one. Nil if it fails to be created again
+func (s *Supervisor) RestartWorker(tw *TopologyWorkers, name string) *Worker {
+	s.Mutex.Lock()
+	version := tw.CompletedVersion
+	boltTask, isBolt := tw.BoltTasks[name]
+	spoutTask := tw.SpoutTasks[name]
+	tw.Restarts[name]++
+	s.Mutex.Unlock()
+
+	var w *Worker
+	var err error
+	if isBolt {
+		// The states of the snapshots taken since the dispatch
+		// are of the current number of tasks
+		if version != boltTask.SnapshotVersion {
+			boltTask.StateInstNum = boltTask.InstNum
+		}
+		boltTask.SnapshotVersion = version
+		w, err = s.NewBoltWorker(&boltTask)
+	} else {
+		spoutTask.SnapshotVersion = version
+		w, err = s.NewSpoutWorker(&spoutTask)
+	}
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if err != nil {
+		log.Printf("Restart Worker %s of %s Failed: %v\n", name, tw.Id, err)
+		tw.Failures[name] = err.Error()
+		return nil
+	}
+	if isBolt {
+		tw.BoltWorkers = replaceWorker(tw.BoltWorkers, w)
+	} else {
+		tw.SpoutWorkers = replaceWorker(tw.SpoutWorkers, w)
+	}
+	return w
+}
+
+// Replace the worker of the same task, or add it if there is none
+func replaceWorker(workers []*Worker, w *Worker) []*Worker {
+	for i := range workers {
+		if workers[i].Name == w.Name {
+			workers[i] = w
+			return workers
+		}
+	}
+	return append(workers, w)
+}
+
+// Whether the workers of the topology are still running on the supervisor
+func (s *Supervisor) Running(tw *TopologyWorkers) bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return s.Topologies[tw.Id] == tw
+}
+
+// Report the crash or the restart of the worker to the driver
+func (s *Supervisor) SendWorkerFailureToDriver(failureType string, topologyId string, task string, reason string, restarts int) {
+	b, _ := utils.Marshal(failureType, utils.WorkerFailure{
+		Topology: topologyId,
+		Task:     task,
+		Reason:   reason,
+		Restarts: restarts,
+	})
+	s.Sub.Request <- messages.Message{
+		Payload:      b,
+		TargetConnId: s.Sub.Conn.RemoteAddr().String(),
+	}
+}
+
+// Send the message to the worker, the supervisor is not blocked
+// by a crashed worker not reading it
+func tell(c chan string, message string) {
+	select {
+	case c <- message:
+	case <-time.After(WORKER_SEND_TIMEOUT):
+		log.Printf("Worker Does Not Read %q\n", message)
+	}
+}
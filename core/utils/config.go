@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables of the cluster config, they override the config file
+const (
+	ENV_CONFIG      = "CRANE_CONFIG"
+	ENV_DRIVERS     = "CRANE_DRIVERS"
+	ENV_SDFS_MASTER = "CRANE_SDFS_MASTER"
+	ENV_HOST        = "CRANE_HOST"
+	ENV_PORTS       = "CRANE_PORTS"
+	ENV_DATA_DIR    = "CRANE_DATA_DIR"
+)
+
+// Config of the cluster addressing, shared by the driver, the supervisors
+// and the clients. It is loaded from the defaults, the config file, the
+// environment and the flags of the daemons, the later ones overriding
+type Config struct {
+	// IP:Port addresses of the leader and the standby drivers, in the
+	// order they take over
+	Drivers    []string `json:"drivers" yaml:"drivers"`
+	SdfsMaster string   `json:"sdfs_master" yaml:"sdfs_master"`
+	// Host the other machines reach this one at, the address of the
+	// connection to the driver by default
+	Host string `json:"host" yaml:"host"`
+	// Range of the ports of the workers on a supervisor, e.g. "6000-6999"
+	Ports   string `json:"ports" yaml:"ports"`
+	DataDir string `json:"data_dir" yaml:"data_dir"`
+}
+
+// Config of a single machine, the daemons and SDFS running locally
+func DefaultConfig() *Config {
+	return &Config{
+		Drivers:    []string{fmt.Sprintf("127.0.0.1:%d", DRIVER_PORT)},
+		SdfsMaster: DEFAULT_SDFS_MASTER,
+		Ports:      fmt.Sprintf("%d-%d", CONTRACTOR_BASE_PORT, CONTRACTOR_BASE_PORT+SUPERVISOR_PORTS-1),
+		DataDir:    ".",
+	}
+}
+
+// Load the config file, YAML for the .yaml and .yml files and JSON for the
+// others, over the defaults. The file of CRANE_CONFIG is loaded if the path
+// is empty, and none if both are. The environment overrides the file
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		path = os.Getenv(ENV_CONFIG)
+	}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			dec := yaml.NewDecoder(bytes.NewReader(b))
+			dec.KnownFields(true)
+			err = dec.Decode(cfg)
+		default:
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.DisallowUnknownFields()
+			err = dec.Decode(cfg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse config %s: %v", path, err)
+		}
+	}
+	if drivers := os.Getenv(ENV_DRIVERS); drivers != "" {
+		cfg.Drivers = strings.Split(drivers, ",")
+	}
+	if master := os.Getenv(ENV_SDFS_MASTER); master != "" {
+		cfg.SdfsMaster = master
+	}
+	if host := os.Getenv(ENV_HOST); host != "" {
+		cfg.Host = host
+	}
+	if ports := os.Getenv(ENV_PORTS); ports != "" {
+		cfg.Ports = ports
+	}
+	if dir := os.Getenv(ENV_DATA_DIR); dir != "" {
+		cfg.DataDir = dir
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Check the addresses and the port range of the config
+func (c *Config) Validate() error {
+	if len(c.Drivers) == 0 {
+		return errors.New("no driver address in the config")
+	}
+	for _, addr := range c.Drivers {
+		if !strings.Contains(addr, ":") {
+			return fmt.Errorf("driver address %q is not IP:Port", addr)
+		}
+	}
+	if !strings.Contains(c.SdfsMaster, ":") {
+		return fmt.Errorf("SDFS master address %q is not IP:Port", c.SdfsMaster)
+	}
+	if _, _, err := c.PortRange(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// First and last ports of the workers
+func (c *Config) PortRange() (int, int, error) {
+	var min, max int
+	if n, _ := fmt.Sscanf(c.Ports, "%d-%d", &min, &max); n != 2 || min <= 0 || max < min || max > 65535 {
+		return 0, 0, fmt.Errorf("port range %q is not First-Last", c.Ports)
+	}
+	return min, max, nil
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// LogBuffer keeps the latest lines written into it, it is set as an
+// output of the logger so that the logs are queried remotely
+type LogBuffer struct {
+	lines   []string
+	size    int
+	partial []byte
+	mutex   sync.Mutex
+}
+
+// Factory mode to return the LogBuffer instance keeping size lines
+func NewLogBuffer(size int) *LogBuffer {
+	lb := &LogBuffer{}
+	lb.lines = make([]string, 0, size)
+	lb.size = size
+	return lb
+}
+
+func (lb *LogBuffer) Write(p []byte) (int, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	lb.partial = append(lb.partial, p...)
+	for {
+		i := bytes.IndexByte(lb.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if len(lb.lines) == lb.size {
+			lb.lines = lb.lines[1:]
+		}
+		lb.lines = append(lb.lines, string(lb.partial[:i]))
+		lb.partial = lb.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// The latest n lines mentioning the word, all the lines if word is empty.
+// The word must not be followed by a digit, e.g. Bolt_1 does not match Bolt_10
+func (lb *LogBuffer) Tail(word string, n int) []string {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	tail := make([]string, 0)
+	for i := len(lb.lines) - 1; i >= 0 && len(tail) < n; i-- {
+		if word == "" || mentions(lb.lines[i], word) {
+			tail = append(tail, lb.lines[i])
+		}
+	}
+	// In the order they are written
+	for i, j := 0, len(tail)-1; i < j; i, j = i+1, j-1 {
+		tail[i], tail[j] = tail[j], tail[i]
+	}
+	return tail
+}
+
+func mentions(line string, word string) bool {
+	for offset := 0; ; {
+		i := strings.Index(line[offset:], word)
+		if i < 0 {
+			return false
+		}
+		end := offset + i + len(word)
+		if end == len(line) || !unicode.IsDigit(rune(line[end])) {
+			return true
+		}
+		offset = end
+	}
+}
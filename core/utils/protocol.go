@@ -11,23 +11,108 @@ const (
 	SUSPEND_RESPONSE    = "suspend_response"
 	SNAPSHOT_REQUEST    = "snapshot_request"
 	SNAPSHOT_RESPONSE   = "snapshot_response"
+	SNAPSHOT_COMMIT     = "snapshot_commit"
 	RESTORE_REQUEST     = "restore_request"
+	RESUME_REQUEST      = "resume_request"
+	KILL_REQUEST        = "kill_request"
 	TOPO_SUBMISSION     = "topo_submission"
 	TOPO_SUBMISSION_RES = "topo_submission_response"
+	TOPO_KILL           = "topo_kill"
+	TOPO_DEACTIVATE     = "topo_deactivate"
+	TOPO_ACTIVATE       = "topo_activate"
+	TOPO_REBALANCE      = "topo_rebalance"
+	TOPO_COMMAND_RES    = "topo_command_response"
+	TOPO_CHECKPOINT     = "topo_checkpoint"
+	TOPO_LIST           = "topo_list"
+	TOPO_DESCRIBE       = "topo_describe"
+	SUPERVISOR_LIST     = "supervisor_list"
+	TASK_LOGS           = "task_logs"
+	QUERY_RES           = "query_response"
+	LOGS_REQUEST        = "logs_request"
+	LOGS_RESPONSE       = "logs_response"
+	HEARTBEAT           = "heartbeat"
 	BOLT_TASK           = "bolt_task"
 	SPOUT_TASK          = "spout_task"
 	TASK_ALL_DISPATCHED = "task_all_dispatched"
+	REWIRE_REQUEST      = "rewire_request"
+	WORKER_FAILURE      = "worker_failure"
+	WORKER_RESTART      = "worker_restart"
+	WORKER_MESSAGE      = "worker_message"
+	WORKER_STATUS       = "worker_status"
+	LEADER_PING         = "leader_ping"
+	LEADER_PONG         = "leader_pong"
+	ACKER_TASK          = "acker_task"
 	CONN_NOTIFY         = "conn_notify"
 	GROUPING_BY_FIELD   = "grouping_by_field"
 	GROUPING_BY_SHUFFLE = "grouping_by_shuffle"
 	GROUPING_BY_ALL     = "grouping_by_all"
 
+	STATE_BACKEND_LOCAL  = "local"
+	STATE_BACKEND_SDFS   = "sdfs"
+	STATE_BACKEND_MEMORY = "memory"
+
+	// Codecs of the tuples over the edges
+	CODEC_JSON   = "json"
+	CODEC_BINARY = "binary"
+
+	DEFAULT_STREAM = "default"
+	// Plugin file of the spouts and bolts registered in the process
+	// running them, nothing is pulled or loaded for it
+	LOCAL_PLUGIN = "local"
+
+	SCHEDULER_ROUND_ROBIN    = "round_robin"
+	SCHEDULER_RESOURCE_AWARE = "resource_aware"
+
+	// How a topology recovers from a supervisor failure, only the tasks
+	// of the failed supervisor are moved, or the whole topology restarts
+	RECOVERY_TASKS    = "tasks"
+	RECOVERY_TOPOLOGY = "topology"
+	// Resources a task requires unless its component declares them,
+	// CPU in percent of a core and memory in MB
+	DEFAULT_TASK_CPU    = 10
+	DEFAULT_TASK_MEMORY = 128
+	// Memory of a supervisor if it is not found from the system
+	DEFAULT_SUPERVISOR_MEMORY = 4096
+
+	// Seconds between the heartbeats of a supervisor, the driver takes it
+	// as failed if no heartbeat arrives in the timeout
+	HEARTBEAT_INTERVAL = 2
+	HEARTBEAT_TIMEOUT  = 10
+
+	WORKER_RUNNING   = "running"
+	WORKER_SUSPENDED = "suspended"
+	WORKER_DEAD      = "dead"
+	WORKER_FAILED    = "failed"
+
+	// State kept by the tasks of a bolt, reported for the rebalancing,
+	// per key or per task
+	STATE_KEYED = "keyed"
+	STATE_TASK  = "task"
+
+	// Seconds the supervisor waits before restarting a crashed worker,
+	// doubled on every crash in a row up to the max. A worker running
+	// longer than the stable time starts the backoff over
+	WORKER_RESTART_BACKOFF     = 1
+	WORKER_RESTART_MAX_BACKOFF = 60
+	WORKER_STABLE_TIME         = 120
+
+	TUPLE_ACK_INIT = "ack_init"
+	TUPLE_ACK      = "ack"
+	TUPLE_FAIL     = "fail"
+
 	CONTRACTOR_BASE_PORT = 6000
 	DRIVER_PORT          = 5050
+	DEFAULT_SDFS_MASTER  = "127.0.0.1:5000"
+	// Number of the ports of the workers on a supervisor by default
+	SUPERVISOR_PORTS = 1000
+	// Max number of tasks a supervisor runs, one port each
+	SUPERVISOR_SLOTS = 100
 )
 
 type PayloadHeader struct {
 	Type string
+	// Id of the request, echoed by its response
+	RequestId uint64
 }
 
 type PayloadMessage struct {
@@ -37,13 +122,203 @@ type PayloadMessage struct {
 
 type JoinRequest struct {
 	Name string
+	// Host and range of the ports the supervisor's workers listen on,
+	// the host of its connection and the default ports if not set
+	Host    string
+	PortMin int
+	PortMax int
+	// Capacity of the supervisor for the tasks
+	Capacity Resources
+	Slots    int
+}
+
+// Heartbeat of a supervisor with its capacity, the resources used on it
+// and the status of its workers
+type Heartbeat struct {
+	Capacity  Resources
+	Slots     int
+	FreeSlots int
+	Used      Resources
+	Workers   []WorkerStatus
+}
+
+// Status of a spout, bolt or acker worker, with the number of the tuples
+// it emitted, executed, and acked or failed
+type WorkerStatus struct {
+	Topology string
+	Task     string
+	Kind     string
+	State    string
+	Emitted  uint64
+	Executed uint64
+	Acked    uint64
+	Failed   uint64
+	// STATE_KEYED or STATE_TASK for a stateful bolt, empty if
+	// the worker keeps no state
+	StateKind string
+	// Times the worker crashed and was restarted, and the reason of
+	// the latest crash
+	Restarts int
+	Error    string
+}
+
+// Crash of a worker reported by its supervisor, and its restart
+type WorkerFailure struct {
+	Topology string
+	Task     string
+	Reason   string
+	Restarts int
+}
+
+// CPU in percent of a core and memory in MB, of a supervisor
+// or required by a task
+type Resources struct {
+	CPU    float64
+	Memory int
+}
+
+// Add the resources
+func (r Resources) Add(o Resources) Resources {
+	return Resources{CPU: r.CPU + o.CPU, Memory: r.Memory + o.Memory}
+}
+
+// Subtract the resources
+func (r Resources) Sub(o Resources) Resources {
+	return Resources{CPU: r.CPU - o.CPU, Memory: r.Memory - o.Memory}
+}
+
+// Whether the resources are enough for the required ones
+func (r Resources) Fits(required Resources) bool {
+	return r.CPU >= required.CPU && r.Memory >= required.Memory
+}
+
+// The required resources, with the defaults of the ones not declared
+func TaskResources(cpu float64, memory int) Resources {
+	if cpu == 0 {
+		cpu = DEFAULT_TASK_CPU
+	}
+	if memory == 0 {
+		memory = DEFAULT_TASK_MEMORY
+	}
+	return Resources{CPU: cpu, Memory: memory}
 }
 
 type FilePull struct {
 	Filename string
 }
 
+// Response of the driver to a topology submission, the errors are
+// the reasons the topology is rejected
+type TopoSubmissionResponse struct {
+	Id       string
+	Accepted bool
+	Errors   []string
+}
+
+// Lifecycle command of a running topology, the component
+// and its number of instances are for rebalancing only
+type TopologyCommand struct {
+	Topology  string
+	Component string
+	InstNum   int
+}
+
+// Response of the driver to a topology command
+type CommandResponse struct {
+	Ok    bool
+	Error string
+}
+
+// Response of the driver to a query of the client, Content is the
+// JSON of the result if there is no error
+type QueryResponse struct {
+	Error   string
+	Content []byte
+}
+
+// Query of the driver about a topology, or a task of it
+type TopologyQuery struct {
+	Topology string
+	Task     string
+	Lines    int
+}
+
+// Summary of a running topology
+type TopologySummary struct {
+	Id              string
+	Name            string
+	Active          bool
+	Tasks           int
+	Supervisors     int
+	SnapshotVersion int
+}
+
+// Task of a topology placed on a supervisor
+type TaskPlacement struct {
+	Task       string
+	Component  string
+	Supervisor string
+	Addr       string
+	// Status in the latest heartbeat of the supervisor
+	Status WorkerStatus
+}
+
+// Spout or bolt of a topology, with the tasks it subscribes
+type ComponentInfo struct {
+	Name      string
+	Kind      string
+	InstNum   int
+	PrevTasks []string
+}
+
+// Running topology with the placements of its tasks
+type TopologyDescription struct {
+	TopologySummary
+	Components []ComponentInfo
+	Tasks      []TaskPlacement
+}
+
+// Supervisor joined the cluster
+type SupervisorInfo struct {
+	Id        string
+	Name      string
+	Tasks     int
+	FreeSlots int
+	Capacity  Resources
+	Available Resources
+	// Resources used on the supervisor and the seconds since its latest heartbeat
+	Used          Resources
+	HeartbeatSecs float64
+}
+
+// Snapshot request, response or commit of a version of the topology
+type SnapshotMessage struct {
+	Topology string
+	Version  int
+}
+
+// Addresses of the tasks moved to other supervisors, the old to the new,
+// bolts subscribing to the old ones subscribe to the new ones instead.
+// The barriers of the abandoned snapshot version are dropped
+type RewireMessage struct {
+	Topology  string
+	Addrs     map[string]string
+	Abandoned int
+}
+
+// Batching of the tuples a task sends over each of its outgoing edges.
+// A batch is sent once it has MaxTuples tuples or MaxBytes bytes, or
+// Linger milliseconds after its first tuple. A zero Linger sends the
+// batches as soon as the task has no more tuples queued, and MaxTuples
+// of 1 or less sends every tuple alone
+type BatchConfig struct {
+	MaxTuples int
+	MaxBytes  int
+	Linger    int
+}
+
 type BoltTaskMessage struct {
+	Topology             string
 	Name                 string
 	Port                 string
 	PrevBoltAddr         []string
@@ -54,26 +329,83 @@ type BoltTaskMessage struct {
 	PluginFile           string
 	PluginSymbol         string
 	SnapshotVersion      int
+	StateBackend         string
+	AckerAddr            string
+	SuccStreams          map[string][]string
+	SuccFieldIndexes     map[string]map[string][]int
+	// Number of the tasks of the bolt, and the number when the snapshot
+	// restored from was taken, they differ after rebalancing
+	InstNum      int
+	StateInstNum int
+	// Codec the bolt prefers for the tuples it receives
+	Codec string
+	Batch BatchConfig
 }
 
 type SpoutTaskMessage struct {
-	Name            string
-	Port            string
-	GroupingHint    string
-	FieldIndex      int
-	PluginFile      string
-	PluginSymbol    string
-	SnapshotVersion int
+	Topology         string
+	Name             string
+	Port             string
+	GroupingHint     string
+	FieldIndex       int
+	PluginFile       string
+	PluginSymbol     string
+	SnapshotVersion  int
+	StateBackend     string
+	AckerAddr        string
+	AckTimeout       int
+	SuccStreams      map[string][]string
+	SuccFieldIndexes map[string]map[string][]int
+	Batch            BatchConfig
+}
+
+type AckerTaskMessage struct {
+	Topology string
+	Name     string
+	Port     string
+}
+
+// Hello of a bolt task subscribing a previous task, with the codecs of
+// the tuples it decodes in the order it prefers
+type SubscribeHello struct {
+	Name   string
+	Codecs []string
+}
+
+// Tuple passing between workers. Root is the id of the spout tuple
+// it derives from (0 if not tracked) and Id is the edge id for acking.
+// Stream is the output stream of the producer the tuple is emitted to.
+// A tuple with non-zero Barrier is the checkpoint barrier of that snapshot
+// version instead, and carries no values
+type TupleMessage struct {
+	Id      uint64
+	Root    uint64
+	Stream  string
+	Values  []interface{}
+	Barrier int
+}
+
+// Ack message between workers and the acker, Xor is the XOR
+// of the edge ids acked or emitted for the root tuple
+type AckMessage struct {
+	Type string
+	Root uint64
+	Xor  uint64
 }
 
 func Marshal(contentType string, content interface{}) ([]byte, error) {
+	return MarshalRequest(contentType, 0, content)
+}
+
+// Marshal the request or the response of the request of the id
+func MarshalRequest(contentType string, requestId uint64, content interface{}) ([]byte, error) {
 	contentBytes, err := json.Marshal(content)
 	if err != nil {
 		return nil, err
 	}
 
 	msg := PayloadMessage{
-		PayloadHeader{Type: contentType},
+		PayloadHeader{Type: contentType, RequestId: requestId},
 		contentBytes,
 	}
 
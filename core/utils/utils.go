@@ -8,10 +8,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"log"
+	"math/rand"
 	"net"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"plugin"
+	"strings"
+	"sync"
+)
+
+// Symbols of the spouts and bolts registered in this process, they are
+// looked up instead of the plugin file LOCAL_PLUGIN
+var (
+	localSymbols = make(map[string]plugin.Symbol)
+	lockSymbols  sync.RWMutex
 )
 
 func Serialize(data interface{}) []byte {
@@ -66,49 +77,54 @@ func PrintError(err error) {
 	}
 }
 
-// A trick to simply get local IP address
+// Local IP address of the first network interface up, other than the
+// loopback. The loopback address if there is no network, e.g. offline
 func GetLocalIP() net.IP {
-	dial, err := net.Dial("udp", "8.8.8.8:80")
-	PrintError(err)
-	localAddr := dial.LocalAddr().(*net.UDPAddr)
-	dial.Close()
-
-	return localAddr.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Println(err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP
+		}
+	}
+	return net.IPv4(127, 0, 0, 1)
 }
 
-// Return local FQDN
+// Return local hostname
 func GetLocalHostname() string {
-	cmd := exec.Command("/bin/hostname", "-f")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	hostname, err := os.Hostname()
 	if err != nil {
-		fmt.Println(err.Error())
+		log.Println(err)
 	}
-	hostname := out.String()
-	hostname = hostname[:len(hostname)-1] // removing EOL
-
 	return hostname
 }
 
-// Get VM Index to IP mapping
-func GetVmMap() map[int]string {
-	res := make(map[int]string, 0)
-	for i := 1; i <= 10; i++ {
-		host := fmt.Sprintf("fa24-cs425-87%.2d.cs.illinois.edu", i)
-		addr := LookupIP(host)
-		res[i] = addr
+// Total memory of the machine in MB, 0 if it is not found
+func GetTotalMemory() int {
+	b, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
 	}
-	return res
+	for _, line := range strings.Split(string(b), "\n") {
+		var kb int
+		if n, _ := fmt.Sscanf(line, "MemTotal: %d kB", &kb); n == 1 {
+			return kb / 1024
+		}
+	}
+	return 0
 }
 
-func LookupIP(hostname string) string {
-	addrs, err := net.LookupHost(hostname)
+// Load average of the last minute in percent of a core, 0 if it is not found
+func GetLoadAverage() float64 {
+	b, err := os.ReadFile("/proc/loadavg")
 	if err != nil {
-		fmt.Println(err.Error())
+		return 0
 	}
-
-	return addrs[0]
+	var load float64
+	fmt.Sscanf(string(b), "%f", &load)
+	return load * 100
 }
 
 func Hash(value interface{}) int {
@@ -118,6 +134,58 @@ func Hash(value interface{}) int {
 	return int(h.Sum32())
 }
 
+// Values of the fields at the indexes, as the composite key for grouping
+func SelectFields(values []interface{}, indexes []int) []interface{} {
+	key := make([]interface{}, len(indexes))
+	for i, index := range indexes {
+		if index < len(values) {
+			key[i] = values[index]
+		}
+	}
+	return key
+}
+
+// Whether the successor component subscribes the stream, only the
+// default stream is subscribed if the subscriptions are not given
+func Subscribed(succStreams map[string][]string, component string, stream string) bool {
+	if succStreams == nil {
+		return stream == DEFAULT_STREAM
+	}
+	for _, s := range succStreams[component] {
+		if s == stream {
+			return true
+		}
+	}
+	return false
+}
+
+// Random non-zero id to track tuples
+func NewTupleId() uint64 {
+	for {
+		if id := rand.Uint64(); id != 0 {
+			return id
+		}
+	}
+}
+
+// Look up an optional callback of the plugin, nil if it is not exported
+func LookupCallback(pluginFile string, callbackName string) func([]interface{}, *[]interface{}) {
+	plug, err := plugin.Open(pluginFile)
+	if err != nil {
+		return nil
+	}
+	symCallback, err := plug.Lookup(callbackName)
+	if err != nil {
+		return nil
+	}
+	callback, ok := symCallback.(func([]interface{}, *[]interface{}))
+	if !ok {
+		fmt.Println("unexpected type from module symbol", callbackName)
+		return nil
+	}
+	return callback
+}
+
 func LookupProcFunc(pluginFile string, procFuncName string) func([]interface{}, *[]interface{}, *[]interface{}) error {
 	// Load module
 	plug, err := plugin.Open(pluginFile)
@@ -143,3 +211,31 @@ func LookupProcFunc(pluginFile string, procFuncName string) func([]interface{},
 
 	return procFunc
 }
+
+// Look up a symbol of any type, the caller asserts its type. The error
+// fails the task only, not the supervisor running it
+func LookupSymbol(pluginFile string, symbolName string) (plugin.Symbol, error) {
+	if filepath.Base(pluginFile) == LOCAL_PLUGIN {
+		lockSymbols.RLock()
+		defer lockSymbols.RUnlock()
+		symbol, ok := localSymbols[symbolName]
+		if !ok {
+			return nil, fmt.Errorf("symbol %s is not registered in this process", symbolName)
+		}
+		return symbol, nil
+	}
+	// Load module
+	plug, err := plugin.Open(pluginFile)
+	if err != nil {
+		return nil, err
+	}
+	return plug.Lookup(symbolName)
+}
+
+// Register the symbol of the plugin file LOCAL_PLUGIN, e.g. the factory
+// of a bolt running in the same process without a plugin build
+func RegisterSymbol(symbolName string, symbol plugin.Symbol) {
+	lockSymbols.Lock()
+	defer lockSymbols.Unlock()
+	localSymbols[symbolName] = symbol
+}
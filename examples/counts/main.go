@@ -5,11 +5,13 @@ import (
 	"crane/core/utils"
 	"crane/spout"
 	"crane/topology"
+	"log"
 )
 
 func main() {
 	// Create a topology
 	tm := topology.Topology{}
+	tm.SetName("counts")
 
 	// Create a spout
 	sp := spout.NewSpoutInst("WordSpout", "process.so", "WordSpout", utils.GROUPING_BY_FIELD, 0)
@@ -27,6 +29,16 @@ func main() {
 	cb.AddPrevTaskName("WordSpout")
 	tm.AddBolt(cb)
 
-	tm.SubmitFile("./process.so", "process.so")
-	tm.Submit(":5050")
+	// Commit the counts into SDFS exactly once
+	ws := bolt.NewBoltInst("WordCountSink", "process.so", "WordCountSink", utils.GROUPING_BY_SHUFFLE, 0)
+	ws.SetInstanceNum(1)
+	ws.AddPrevTaskName("WordCountBolt")
+	tm.AddBolt(ws)
+
+	if err := tm.SubmitFile("./process.so", "process.so"); err != nil {
+		log.Fatal(err)
+	}
+	if err := tm.Submit(""); err != nil {
+		log.Fatal(err)
+	}
 }
\ No newline at end of file
@@ -1,6 +1,8 @@
 package main 
 
 import (
+	"crane/bolt"
+	sdfs "crane/simpledfs/client"
 	// "fmt"
 	"log"
 	"time"
@@ -168,4 +170,10 @@ func WordSpout(tuple []interface{}, result *[]interface{}, variables *[]interfac
 // 	*result = []interface{}{num}
 
 // 	return nil
-// }
\ No newline at end of file
+// }
+
+// Sample exactly-once sink, the word counts are committed into SDFS
+// files named wordcount_<task>_<version> with the snapshots
+func WordCountSink() bolt.Sink {
+	return bolt.NewFileSink(sdfs.DefaultMasterAddr, "wordcount", "./sink")
+}
@@ -5,11 +5,13 @@ import (
 	"crane/core/utils"
 	"crane/spout"
 	"crane/topology"
+	"log"
 )
 
 func main() {
 	// Create a topology
 	tm := topology.Topology{}
+	tm.SetName("join")
 
 	// Create a spout
 	sp := spout.NewSpoutInst("GenderSpout", "process.so", "GenderSpout", utils.GROUPING_BY_FIELD, 0)
@@ -33,7 +35,11 @@ func main() {
 	// mergeBolt.AddPrevTaskName("GenderAgeJoinBolt")
 	// tm.AddBolt(mergeBolt)
 
-	tm.SubmitFile("./process.so", "process.so")
+	if err := tm.SubmitFile("./process.so", "process.so"); err != nil {
+		log.Fatal(err)
+	}
 	// tm.SubmitFile("./data.json", "data.json")
-	tm.Submit(":5050")
+	if err := tm.Submit(""); err != nil {
+		log.Fatal(err)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crane/bolt"
+	"crane/core/local"
+	"crane/core/utils"
+	"crane/topology"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Upper case the words, a Go value run in the local cluster without
+// a plugin build
+type UpperBolt struct {
+	collector *bolt.BoltOutputCollector
+}
+
+func (ub *UpperBolt) Prepare(task string, collector *bolt.BoltOutputCollector) error {
+	ub.collector = collector
+	return nil
+}
+
+func (ub *UpperBolt) Execute(tuple []interface{}) error {
+	ub.collector.Emit(strings.ToUpper(tuple[0].(string)))
+	return nil
+}
+
+func (ub *UpperBolt) Cleanup() {}
+
+func main() {
+	// Run a driver and two supervisors in this process
+	lc, err := local.NewLocalCluster(2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lc.Shutdown()
+
+	feeder := local.NewFeeder()
+	capture := local.NewCapture()
+
+	tm := topology.NewTopology()
+	tm.SetName("local")
+	tm.AddSpout(feeder.Spout("WordSpout", utils.GROUPING_BY_SHUFFLE, 0))
+	ub := local.NewBolt("UpperBolt", func() bolt.Bolt { return &UpperBolt{} }, utils.GROUPING_BY_SHUFFLE, 0)
+	ub.SetInstanceNum(2)
+	ub.AddPrevTaskName("WordSpout")
+	tm.AddBolt(ub)
+	cb := capture.Bolt("CaptureBolt", utils.GROUPING_BY_SHUFFLE, 0)
+	cb.AddPrevTaskName("UpperBolt")
+	tm.AddBolt(cb)
+
+	id, err := lc.Submit(tm)
+	if err != nil {
+		log.Fatal(err)
+	}
+	words := []string{"storm", "crane", "bolt", "spout"}
+	for _, word := range words {
+		feeder.Feed(word)
+	}
+	if err := capture.Wait(len(words), 30*time.Second); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(capture.Tuples())
+	if err := lc.Kill(id); err != nil {
+		log.Fatal(err)
+	}
+}
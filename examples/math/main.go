@@ -5,11 +5,13 @@ import (
 	"crane/core/utils"
 	"crane/spout"
 	"crane/topology"
+	"log"
 )
 
 func main() {
 	// Create a topology
 	tm := topology.Topology{}
+	tm.SetName("math")
 
 	// Create a Integer Spout
 	sp := spout.NewSpoutInst("IntegerSpout", "process.so", "IntegerSpout", utils.GROUPING_BY_SHUFFLE, 0)
@@ -29,7 +31,11 @@ func main() {
 	db.AddPrevTaskName("MultiplyBolt")
 	tm.AddBolt(db)
 
-	tm.SubmitFile("./process.so", "process.so")
+	if err := tm.SubmitFile("./process.so", "process.so"); err != nil {
+		log.Fatal(err)
+	}
 	// tm.SubmitFile("./data.json", "data.json")
-	tm.Submit(":5050")
+	if err := tm.Submit(""); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -1,66 +1,97 @@
-package main 
+package main
 
 import (
-	// "fmt"
+	"crane/bolt"
+	"crane/spout"
+	"encoding/json"
+	"errors"
 	"log"
 	"time"
-	"errors"
-	// "strings"
-	// "os"
-	// "bufio"
 )
 
 // Sample Divide Two Bolt
-func DivideBolt(tuple []interface{}, result *[]interface{}, variables *[]interface{}) error {
+type divideBolt struct {
+	collector *bolt.BoltOutputCollector
+}
+
+func DivideBolt() bolt.Bolt {
+	return &divideBolt{}
+}
+
+func (b *divideBolt) Prepare(task string, collector *bolt.BoltOutputCollector) error {
+	b.collector = collector
+	return nil
+}
+
+func (b *divideBolt) Execute(tuple []interface{}) error {
 	num := tuple[0].(float64)
 	num /= 2
-	*result = []interface{}{num}
-
-	// Return value
-	if (len(*result) > 0) {
-		log.Printf("Divide Bolt Emit (%v)\n", *result)
-		return nil
-	} else {
-		return errors.New("next tuple is nil")
-	}
+	log.Printf("Divide Bolt Emit (%v)\n", num)
+	b.collector.Emit(num)
+	return nil
 }
 
+func (b *divideBolt) Cleanup() {
+}
 
 // Sample Multiply Two Bolt
-func MultiplyBolt(tuple []interface{}, result *[]interface{}, variables *[]interface{}) error {
+type multiplyBolt struct {
+	collector *bolt.BoltOutputCollector
+}
+
+func MultiplyBolt() bolt.Bolt {
+	return &multiplyBolt{}
+}
+
+func (b *multiplyBolt) Prepare(task string, collector *bolt.BoltOutputCollector) error {
+	b.collector = collector
+	return nil
+}
+
+func (b *multiplyBolt) Execute(tuple []interface{}) error {
 	num := tuple[0].(float64)
 	num *= 2
-	*result = []interface{}{num}
+	log.Printf("Multiply Bolt Emit (%v)\n", num)
+	b.collector.Emit(num)
+	return nil
+}
 
-	// Return value
-	if (len(*result) > 0) {
-		log.Printf("Multiply Bolt Emit (%v)\n", *result)
-		return nil
-	} else {
-		return errors.New("next tuple is nil")
-	}
+func (b *multiplyBolt) Cleanup() {
 }
 
-// Integer generator
-func IntegerSpout(tuple []interface{}, result *[]interface{}, variables *[]interface{}) error {
-	var counter *float64
-	if (len(*variables) == 0) {
-		counter = new(float64)
-		*variables = append(*variables, counter)
-	}
-	counter = ((*variables)[0]).(*float64)
+// Integer generator, the counter is restored from the snapshots
+type integerSpout struct {
+	Counter   int
+	collector *spout.SpoutOutputCollector
+}
 
-	if *counter < 10000 {
-		log.Printf("Integer Spout Emit (%d)\n", *counter)
-		*result = []interface{}{*counter}
-	}
-	(*counter)++
+func IntegerSpout() spout.Spout {
+	return &integerSpout{}
+}
 
-	time.Sleep(1 * time.Millisecond)
+func (s *integerSpout) Open(task string, collector *spout.SpoutOutputCollector) error {
+	s.collector = collector
+	return nil
+}
 
-	if (len(*result) > 0) {
-		return nil
-	} else {
+func (s *integerSpout) NextTuple() error {
+	time.Sleep(1 * time.Millisecond)
+	if s.Counter >= 10000 {
 		return errors.New("next tuple is nil")
 	}
-}
\ No newline at end of file
+	log.Printf("Integer Spout Emit (%d)\n", s.Counter)
+	s.collector.Emit(float64(s.Counter))
+	s.Counter++
+	return nil
+}
+
+func (s *integerSpout) Close() {
+}
+
+func (s *integerSpout) Snapshot() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *integerSpout) Restore(data []byte) error {
+	return json.Unmarshal(data, s)
+}
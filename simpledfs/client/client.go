@@ -0,0 +1,426 @@
+package client
+
+import (
+	"context"
+	"crane/simpledfs/utils"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	DefaultMasterAddr  = "127.0.0.1:5000"
+	DefaultDialTimeout = time.Second
+	DefaultTimeout     = time.Minute
+)
+
+var (
+	ErrNotFound          = errors.New("sdfs file does not exist")
+	ErrUnexpectedMessage = errors.New("unexpected message from master node")
+	ErrNoDatanode        = errors.New("cannot dial any datanode")
+	ErrPutRejected       = errors.New("master did not confirm the put")
+	ErrSizeMismatch      = errors.New("received size does not match the file size")
+)
+
+// Error records a failed SDFS operation on a file
+type Error struct {
+	Op       string
+	Filename string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if e.Filename == "" {
+		return "sdfs " + e.Op + ": " + e.Err.Error()
+	}
+	return "sdfs " + e.Op + " " + e.Filename + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Client, the in-process SDFS client talking to the master and datanodes
+type Client struct {
+	MasterAddr  string
+	DialTimeout time.Duration
+	Timeout     time.Duration
+}
+
+// Factory mode to return the Client instance
+func NewClient(masterAddr string) *Client {
+	client := &Client{}
+	client.MasterAddr = masterAddr
+	client.DialTimeout = DefaultDialTimeout
+	client.Timeout = DefaultTimeout
+	return client
+}
+
+// Put the local file into SDFS as a new version of sdfsName
+func (c *Client) Put(ctx context.Context, localPath, sdfsName string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return &Error{"put", sdfsName, err}
+	}
+	masterConn, err := c.dial(ctx, c.MasterAddr)
+	if err != nil {
+		return &Error{"put", sdfsName, err}
+	}
+	defer masterConn.Close()
+
+	prPacket := utils.PutRequest{MsgType: utils.PutRequestMsg, Filesize: uint64(stat.Size())}
+	copy(prPacket.Filename[:], sdfsName)
+	if _, err := masterConn.Write(utils.Serialize(prPacket)); err != nil {
+		return &Error{"put", sdfsName, c.ctxErr(ctx, err)}
+	}
+
+	response := utils.PutResponse{}
+	if err := utils.ReadPacket(masterConn, &response); err != nil {
+		if err == io.EOF {
+			err = ErrPutRejected
+		}
+		return &Error{"put", sdfsName, c.ctxErr(ctx, err)}
+	}
+	if response.MsgType != utils.PutResponseMsg {
+		return &Error{"put", sdfsName, ErrUnexpectedMessage}
+	}
+
+	if err := c.filePut(ctx, response, localPath); err != nil {
+		return &Error{"put", sdfsName, c.ctxErr(ctx, err)}
+	}
+
+	// Read Put Confirm
+	pc := utils.PutConfirm{}
+	if err := utils.ReadPacket(masterConn, &pc); err != nil {
+		if err == io.EOF {
+			err = ErrPutRejected
+		}
+		return &Error{"put", sdfsName, c.ctxErr(ctx, err)}
+	}
+	if pc.MsgType != utils.PutConfirmMsg {
+		return &Error{"put", sdfsName, ErrUnexpectedMessage}
+	}
+	return nil
+}
+
+// Get the latest version of sdfsName into the local file
+func (c *Client) Get(ctx context.Context, sdfsName, localPath string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	masterConn, err := c.dial(ctx, c.MasterAddr)
+	if err != nil {
+		return &Error{"get", sdfsName, err}
+	}
+	defer masterConn.Close()
+
+	grPacket := utils.GetRequest{MsgType: utils.GetRequestMsg}
+	copy(grPacket.Filename[:], sdfsName)
+	if _, err := masterConn.Write(utils.Serialize(grPacket)); err != nil {
+		return &Error{"get", sdfsName, c.ctxErr(ctx, err)}
+	}
+
+	response := utils.GetResponse{}
+	if err := utils.ReadPacket(masterConn, &response); err != nil {
+		return &Error{"get", sdfsName, c.ctxErr(ctx, err)}
+	}
+	if response.MsgType != utils.GetResponseMsg {
+		return &Error{"get", sdfsName, ErrUnexpectedMessage}
+	}
+	if !response.Found {
+		return &Error{"get", sdfsName, ErrNotFound}
+	}
+	// No datanode is asked for an empty file
+	if response.Filesize == 0 {
+		if err := os.WriteFile(localPath, nil, 0644); err != nil {
+			return &Error{"get", sdfsName, err}
+		}
+		return nil
+	}
+
+	rr := utils.ReadRequest{MsgType: utils.ReadRequestMsg, FilenameHash: response.FilenameHash}
+	err = c.fileGet(ctx, response.DataNodeIPList, response.DataNodePortList, rr, response.Filesize, localPath)
+	if err != nil {
+		return &Error{"get", sdfsName, c.ctxErr(ctx, err)}
+	}
+	return nil
+}
+
+// Get at most num latest versions of sdfsName, each one is stored
+// as localPath-v<timestamp>. The local paths are returned newest first
+func (c *Client) GetVersions(ctx context.Context, sdfsName string, num int, localPath string) ([]string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	masterConn, err := c.dial(ctx, c.MasterAddr)
+	if err != nil {
+		return nil, &Error{"get-versions", sdfsName, err}
+	}
+	defer masterConn.Close()
+
+	gvrPacket := utils.GetVersionsRequest{MsgType: utils.GetVersionsRequestMsg, VersionNum: uint8(num)}
+	copy(gvrPacket.Filename[:], sdfsName)
+	if _, err := masterConn.Write(utils.Serialize(gvrPacket)); err != nil {
+		return nil, &Error{"get-versions", sdfsName, c.ctxErr(ctx, err)}
+	}
+
+	responses := make([]utils.GetVersionsResponse, 0)
+	for i := 0; i < num; i++ {
+		response := utils.GetVersionsResponse{}
+		if err := utils.ReadPacket(masterConn, &response); err != nil {
+			return nil, &Error{"get-versions", sdfsName, c.ctxErr(ctx, err)}
+		}
+		if response.MsgType != utils.GetVersionsResponseMsg {
+			return nil, &Error{"get-versions", sdfsName, ErrUnexpectedMessage}
+		}
+		if response.VersionNum == 0 {
+			return nil, &Error{"get-versions", sdfsName, ErrNotFound}
+		}
+		responses = append(responses, response)
+		if len(responses) >= int(response.VersionNum) {
+			break
+		}
+	}
+
+	paths := make([]string, 0)
+	for _, response := range responses {
+		path := localPath + fmt.Sprintf("-v%d", response.Timestamp)
+		rvr := utils.ReadVersionRequest{
+			MsgType:      utils.ReadVersionRequestMsg,
+			FilenameHash: response.FilenameHash,
+			Timestamp:    response.Timestamp,
+		}
+		err := c.fileGet(ctx, response.DataNodeIPList, response.DataNodePortList, rvr, response.Filesize, path)
+		if err != nil {
+			return paths, &Error{"get-versions", sdfsName, c.ctxErr(ctx, err)}
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Delete all versions of sdfsName
+func (c *Client) Delete(ctx context.Context, sdfsName string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	masterConn, err := c.dial(ctx, c.MasterAddr)
+	if err != nil {
+		return &Error{"delete", sdfsName, err}
+	}
+	defer masterConn.Close()
+
+	drPacket := utils.DeleteRequest{MsgType: utils.DeleteRequestMsg}
+	copy(drPacket.Filename[:], sdfsName)
+	if _, err := masterConn.Write(utils.Serialize(drPacket)); err != nil {
+		return &Error{"delete", sdfsName, c.ctxErr(ctx, err)}
+	}
+
+	response := utils.DeleteResponse{}
+	if err := utils.ReadPacket(masterConn, &response); err != nil {
+		return &Error{"delete", sdfsName, c.ctxErr(ctx, err)}
+	}
+	if response.MsgType != utils.DeleteResponseMsg {
+		return &Error{"delete", sdfsName, ErrUnexpectedMessage}
+	}
+	if !response.IsSuccess {
+		return &Error{"delete", sdfsName, ErrNotFound}
+	}
+	return nil
+}
+
+// List the IP addresses of datanodes storing sdfsName
+func (c *Client) List(ctx context.Context, sdfsName string) ([]string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	masterConn, err := c.dial(ctx, c.MasterAddr)
+	if err != nil {
+		return nil, &Error{"ls", sdfsName, err}
+	}
+	defer masterConn.Close()
+
+	lrPacket := utils.ListRequest{MsgType: utils.ListRequestMsg}
+	copy(lrPacket.Filename[:], sdfsName)
+	if _, err := masterConn.Write(utils.Serialize(lrPacket)); err != nil {
+		return nil, &Error{"ls", sdfsName, c.ctxErr(ctx, err)}
+	}
+
+	response := utils.ListResponse{}
+	if err := utils.ReadPacket(masterConn, &response); err != nil {
+		return nil, &Error{"ls", sdfsName, c.ctxErr(ctx, err)}
+	}
+	if response.MsgType != utils.ListResponseMsg {
+		return nil, &Error{"ls", sdfsName, ErrUnexpectedMessage}
+	}
+
+	addrs := make([]string, 0)
+	for _, value := range response.DataNodeIPList {
+		if value == 0 {
+			break
+		}
+		addrs = append(addrs, utils.StringIP(value))
+	}
+	if len(addrs) == 0 {
+		return nil, &Error{"ls", sdfsName, ErrNotFound}
+	}
+	return addrs, nil
+}
+
+// List all SDFS files stored on this machine
+func (c *Client) Store(ctx context.Context) ([]string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	masterConn, err := c.dial(ctx, c.MasterAddr)
+	if err != nil {
+		return nil, &Error{"store", "", err}
+	}
+	defer masterConn.Close()
+
+	srPacket := utils.StoreRequest{MsgType: utils.StoreRequestMsg}
+	if _, err := masterConn.Write(utils.Serialize(srPacket)); err != nil {
+		return nil, &Error{"store", "", c.ctxErr(ctx, err)}
+	}
+
+	response := utils.StoreResponse{}
+	if err := utils.ReadPacket(masterConn, &response); err != nil {
+		return nil, &Error{"store", "", c.ctxErr(ctx, err)}
+	}
+	if response.MsgType != utils.StoreResponseMsg {
+		return nil, &Error{"store", "", ErrUnexpectedMessage}
+	}
+
+	files := make([]string, 0)
+	for i := uint32(0); i < response.FilesNum; i++ {
+		var filename [128]byte
+		if _, err := io.ReadFull(masterConn, filename[:]); err != nil {
+			return nil, &Error{"store", "", c.ctxErr(ctx, err)}
+		}
+		files = append(files, utils.ParseFilename(filename[:]))
+	}
+	return files, nil
+}
+
+// Send the local file to the first datanode of the replica pipeline
+func (c *Client) filePut(ctx context.Context, pr utils.PutResponse, localPath string) error {
+	conn, err := c.dial(ctx, utils.StringIP(pr.NexthopIP)+":"+utils.StringPort(pr.NexthopPort))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	wr := utils.WriteRequest{MsgType: utils.WriteRequestMsg}
+	wr.FilenameHash = pr.FilenameHash
+	wr.Filesize = pr.Filesize
+	wr.Timestamp = pr.Timestamp
+	wr.DataNodeList = pr.DataNodeList
+	if _, err := conn.Write(utils.Serialize(wr)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if string(buf) != "OK" {
+		return ErrUnexpectedMessage
+	}
+
+	_, err = io.CopyN(conn, file, int64(pr.Filesize))
+	return err
+}
+
+// Read a file from the first reachable datanode into the local file
+func (c *Client) fileGet(ctx context.Context, ips [utils.NumReplica]uint32, ports [utils.NumReplica]uint16,
+	request interface{}, filesize uint64, localPath string) error {
+	var conn net.Conn
+	for index, ip := range ips {
+		if ip == 0 {
+			break
+		}
+		var err error
+		conn, err = c.dial(ctx, utils.StringIP(ip)+":"+utils.StringPort(ports[index]))
+		if err == nil {
+			break
+		}
+	}
+	if conn == nil {
+		return ErrNoDatanode
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(utils.Serialize(request)); err != nil {
+		return err
+	}
+
+	tmp := localPath + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	receivedBytes, err := io.Copy(file, conn)
+	file.Close()
+	if err == nil && uint64(receivedBytes) != filesize {
+		err = ErrSizeMismatch
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, localPath)
+}
+
+// Dial the address, the connection is closed once the context is done
+func (c *Client) dial(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: c.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	return &ctxConn{conn, stop}, nil
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.Timeout)
+}
+
+// Report the context error instead of the I/O error it caused
+func (c *Client) ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Connection released from its context once closed
+type ctxConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (cc *ctxConn) Close() error {
+	cc.stop()
+	return cc.Conn.Close()
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"crane/simpledfs/utils"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Datanode, the storage server of the SDFS. It stores file versions
+// on local disk, pipelines writes to the next replica and serves reads
+type Datanode struct {
+	Listener   net.Listener
+	MasterConn net.Conn
+	NodeID     utils.NodeID
+	IP         string
+	DataDir    string
+	WriteLock  sync.Mutex
+}
+
+// Factory mode to return the Datanode instance
+// ip is the address the datanode listens on and advertises to the master
+func NewDatanode(ip string, dataDir string) *Datanode {
+	listener, err := net.Listen("tcp", ip+":"+fmt.Sprintf("%d", utils.DatanodePort))
+	if err != nil {
+		utils.PrintError(err)
+		return nil
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		utils.PrintError(err)
+		return nil
+	}
+	datanode := &Datanode{}
+	datanode.Listener = listener
+	datanode.IP = ip
+	datanode.DataDir = dataDir
+	return datanode
+}
+
+// Start the Datanode daemon
+func (dn *Datanode) StartDaemon(masterAddr string) error {
+	if err := dn.Join(masterAddr); err != nil {
+		return err
+	}
+	go dn.SendHeartbeats()
+	go dn.ListenToMaster()
+	for {
+		conn, err := dn.Listener.Accept()
+		if err != nil {
+			return err
+		}
+		go dn.HandleConn(conn)
+	}
+}
+
+// Join the SDFS cluster and get the node ID assigned by the master
+func (dn *Datanode) Join(masterAddr string) error {
+	dialer := net.Dialer{
+		Timeout:   time.Second,
+		LocalAddr: &net.TCPAddr{IP: net.ParseIP(dn.IP)},
+	}
+	conn, err := dialer.Dial("tcp", masterAddr)
+	if err != nil {
+		return err
+	}
+	jr := utils.JoinRequest{MsgType: utils.JoinRequestMsg, IP: utils.BinaryIP(dn.IP)}
+	if _, err := conn.Write(utils.Serialize(jr)); err != nil {
+		conn.Close()
+		return err
+	}
+	resp := utils.JoinResponse{}
+	if err := utils.ReadPacket(conn, &resp); err != nil {
+		conn.Close()
+		return err
+	}
+	if resp.MsgType != utils.JoinResponseMsg {
+		conn.Close()
+		return errors.New("Unexpected message from MasterNode")
+	}
+	dn.MasterConn = conn
+	dn.NodeID = resp.NodeID
+	log.Printf("Joined SDFS master %s as %s\n", masterAddr, dn.IP)
+	return nil
+}
+
+// Periodically tell the master this datanode is alive
+func (dn *Datanode) SendHeartbeats() {
+	hb := utils.Heartbeat{MsgType: utils.HeartbeatMsg, NodeID: dn.NodeID}
+	for {
+		if err := dn.WriteMaster(hb); err != nil {
+			log.Println("Lost SDFS master", err)
+			os.Exit(1)
+		}
+		time.Sleep(utils.HeartbeatPeriod * time.Second)
+	}
+}
+
+// Serve the requests sent by the master over the control connection
+func (dn *Datanode) ListenToMaster() {
+	reader := bufio.NewReader(dn.MasterConn)
+	for {
+		header, err := reader.Peek(1)
+		if err != nil {
+			log.Println("Lost SDFS master", err)
+			os.Exit(1)
+		}
+		switch header[0] {
+		case utils.RmRequestMsg:
+			rm := utils.RmRequest{}
+			if utils.ReadPacket(reader, &rm) != nil {
+				continue
+			}
+			dn.RemoveFile(rm.FilenameHash)
+		case utils.ReReplicaRequestMsg:
+			rr := utils.ReReplicaRequest{}
+			if utils.ReadPacket(reader, &rr) != nil {
+				continue
+			}
+			go dn.ReReplicate(rr)
+		default:
+			log.Printf("Unknown message type %d from master\n", header[0])
+			os.Exit(1)
+		}
+	}
+}
+
+// Serve the requests from clients and other datanodes
+func (dn *Datanode) HandleConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	header, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+	switch header[0] {
+	case utils.WriteRequestMsg:
+		wr := utils.WriteRequest{}
+		if utils.ReadPacket(reader, &wr) != nil {
+			return
+		}
+		dn.HandleWrite(wr, conn, reader)
+	case utils.ReadRequestMsg:
+		rr := utils.ReadRequest{}
+		if utils.ReadPacket(reader, &rr) != nil {
+			return
+		}
+		timestamp, ok := dn.LatestVersion(rr.FilenameHash)
+		if !ok {
+			return
+		}
+		dn.SendFile(conn, rr.FilenameHash, timestamp)
+	case utils.ReadVersionRequestMsg:
+		rvr := utils.ReadVersionRequest{}
+		if utils.ReadPacket(reader, &rvr) != nil {
+			return
+		}
+		dn.SendFile(conn, rvr.FilenameHash, rvr.Timestamp)
+	case utils.ReReplicaGetMsg:
+		rg := utils.ReReplicaGet{}
+		if utils.ReadPacket(reader, &rg) != nil {
+			return
+		}
+		dn.HandleReReplicaGet(rg, conn, reader)
+	case utils.ReReplicaRequestMsg:
+		rr := utils.ReReplicaRequest{}
+		if utils.ReadPacket(reader, &rr) != nil {
+			return
+		}
+		dn.ReReplicate(rr)
+	default:
+		log.Printf("Unknown message type %d from %s\n", header[0], conn.RemoteAddr().String())
+	}
+}
+
+// Receive a file version, pipeline it to the next replica and confirm to the master
+func (dn *Datanode) HandleWrite(wr utils.WriteRequest, conn net.Conn, reader *bufio.Reader) {
+	if _, err := conn.Write([]byte("OK")); err != nil {
+		utils.PrintError(err)
+		return
+	}
+	path := dn.FilePath(wr.FilenameHash, wr.Timestamp)
+	if err := dn.StoreFile(path, io.LimitReader(reader, int64(wr.Filesize)), wr.Filesize); err != nil {
+		utils.PrintError(err)
+		return
+	}
+	log.Printf("Stored %s with %d bytes\n", filepath.Base(path), wr.Filesize)
+
+	unreachable := dn.ForwardWrite(wr, path)
+
+	wc := utils.WriteConfirm{
+		MsgType:      utils.WriteConfirmMsg,
+		FilenameHash: wr.FilenameHash,
+		Filesize:     wr.Filesize,
+		Timestamp:    wr.Timestamp,
+		DataNode:     dn.NodeID,
+		Unreachable:  unreachable,
+	}
+	utils.PrintError(dn.WriteMaster(wc))
+}
+
+// Pipeline the write to the first reachable replica after this datanode,
+// return the number of replicas skipped since they were unreachable
+func (dn *Datanode) ForwardWrite(wr utils.WriteRequest, path string) uint8 {
+	self := utils.BinaryIP(dn.IP)
+	index := -1
+	for i, node := range wr.DataNodeList {
+		if node.IP == self {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return 0
+	}
+	var unreachable uint8
+	for _, node := range wr.DataNodeList[index+1:] {
+		if node.IP == 0 {
+			break
+		}
+		conn, err := net.DialTimeout("tcp", utils.StringIP(node.IP)+":"+utils.StringPort(utils.DatanodePort), time.Second)
+		if err != nil {
+			utils.PrintError(err)
+			unreachable++
+			continue
+		}
+		err = dn.forwardTo(conn, wr, path)
+		conn.Close()
+		if err == nil {
+			break
+		}
+		utils.PrintError(err)
+		unreachable++
+	}
+	return unreachable
+}
+
+func (dn *Datanode) forwardTo(conn net.Conn, wr utils.WriteRequest, path string) error {
+	if _, err := conn.Write(utils.Serialize(wr)); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if string(buf) != "OK" {
+		return errors.New("Unexpected write reply from datanode")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(conn, file)
+	return err
+}
+
+// Answer whether this datanode needs the file version, and receive it if so
+func (dn *Datanode) HandleReReplicaGet(rg utils.ReReplicaGet, conn net.Conn, reader *bufio.Reader) {
+	path := dn.FilePath(rg.FilenameHash, rg.Timestamp)
+	_, err := os.Stat(path)
+	reply := utils.ReReplicaGet{
+		MsgType:      utils.ReReplicaGetMsg,
+		FilenameHash: rg.FilenameHash,
+		Timestamp:    rg.Timestamp,
+		GetNeed:      os.IsNotExist(err),
+	}
+	if _, err := conn.Write(utils.Serialize(reply)); err != nil || !reply.GetNeed {
+		return
+	}
+	if err := dn.StoreFile(path, reader, 0); err != nil {
+		utils.PrintError(err)
+		return
+	}
+	log.Printf("Re-replicated %s\n", filepath.Base(path))
+}
+
+// Copy a file version to the datanodes in the request and report the new holders
+func (dn *Datanode) ReReplicate(rr utils.ReReplicaRequest) {
+	path := dn.FilePath(rr.FilenameHash, rr.Timestamp)
+	info, err := os.Stat(path)
+	if err != nil {
+		// This replica is lost, hand the request over to another holder
+		if rr.TimeToLive == 0 {
+			log.Printf("Drop re-replica request of %s\n", filepath.Base(path))
+			return
+		}
+		rr.TimeToLive--
+		for _, node := range rr.DataNodeList {
+			if node.IP == 0 || node.IP == utils.BinaryIP(dn.IP) {
+				continue
+			}
+			conn, err := net.DialTimeout("tcp", utils.StringIP(node.IP)+":"+utils.StringPort(utils.DatanodePort), time.Second)
+			if err != nil {
+				continue
+			}
+			_, err = conn.Write(utils.Serialize(rr))
+			conn.Close()
+			if err == nil {
+				return
+			}
+		}
+		return
+	}
+
+	var holders [utils.NumReplica]utils.NodeID
+	n := 0
+	for _, node := range rr.DataNodeList {
+		if node.IP == 0 {
+			continue
+		}
+		if node.IP == utils.BinaryIP(dn.IP) || dn.pushReplica(node, rr, path) == nil {
+			holders[n] = node
+			n++
+		}
+	}
+
+	resp := utils.ReReplicaResponse{
+		MsgType:      utils.ReReplicaResponseMsg,
+		FilenameHash: rr.FilenameHash,
+		Filesize:     uint64(info.Size()),
+		Timestamp:    rr.Timestamp,
+		DataNodeList: holders,
+	}
+	utils.PrintError(dn.WriteMaster(resp))
+}
+
+func (dn *Datanode) pushReplica(node utils.NodeID, rr utils.ReReplicaRequest, path string) error {
+	conn, err := net.DialTimeout("tcp", utils.StringIP(node.IP)+":"+utils.StringPort(utils.DatanodePort), time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rg := utils.ReReplicaGet{
+		MsgType:      utils.ReReplicaGetMsg,
+		FilenameHash: rr.FilenameHash,
+		Timestamp:    rr.Timestamp,
+		GetNeed:      true,
+	}
+	if _, err := conn.Write(utils.Serialize(rg)); err != nil {
+		return err
+	}
+	reply := utils.ReReplicaGet{}
+	if err := utils.ReadPacket(conn, &reply); err != nil {
+		return err
+	}
+	if !reply.GetNeed {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(conn, file)
+	return err
+}
+
+// Stream a stored file version to the connection
+func (dn *Datanode) SendFile(conn net.Conn, hash [32]byte, timestamp uint64) {
+	file, err := os.Open(dn.FilePath(hash, timestamp))
+	if err != nil {
+		utils.PrintError(err)
+		return
+	}
+	defer file.Close()
+	_, err = io.Copy(conn, file)
+	utils.PrintError(err)
+}
+
+// Store the content into path, checking its size when it is known
+func (dn *Datanode) StoreFile(path string, reader io.Reader, filesize uint64) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(file, reader)
+	file.Close()
+	if err == nil && filesize > 0 && uint64(n) != filesize {
+		err = fmt.Errorf("received %d bytes of %d", n, filesize)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove all the versions of a file
+func (dn *Datanode) RemoveFile(hash [32]byte) {
+	files, _ := filepath.Glob(filepath.Join(dn.DataDir, utils.StringHashFilename(hash[:])+"_*"))
+	for _, f := range files {
+		utils.PrintError(os.Remove(f))
+	}
+	log.Printf("Removed %d versions of %s\n", len(files), utils.StringHashFilename(hash[:]))
+}
+
+// Timestamp of the latest stored version of a file
+func (dn *Datanode) LatestVersion(hash [32]byte) (uint64, bool) {
+	files, _ := filepath.Glob(filepath.Join(dn.DataDir, utils.StringHashFilename(hash[:])+"_*"))
+	versions := make([]uint64, 0)
+	for _, f := range files {
+		words := strings.Split(filepath.Base(f), "_")
+		ts, err := strconv.ParseUint(words[len(words)-1], 10, 64)
+		if err == nil {
+			versions = append(versions, ts)
+		}
+	}
+	if len(versions) == 0 {
+		return 0, false
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	return versions[0], true
+}
+
+func (dn *Datanode) FilePath(hash [32]byte, timestamp uint64) string {
+	return filepath.Join(dn.DataDir, fmt.Sprintf("%s_%d", utils.StringHashFilename(hash[:]), timestamp))
+}
+
+// Write a packet to the master, serialized with other writers
+func (dn *Datanode) WriteMaster(packet interface{}) error {
+	dn.WriteLock.Lock()
+	defer dn.WriteLock.Unlock()
+	_, err := dn.MasterConn.Write(utils.Serialize(packet))
+	return err
+}
+
+func main() {
+	masterPtr := flag.String("master", "127.0.0.1:"+fmt.Sprintf("%d", utils.MasterPort), "Master's IP:Port address")
+	ipPtr := flag.String("ip", "", "IP address to listen on, use 127.0.0.x to run several datanodes on one machine")
+	dirPtr := flag.String("dir", "./sdfs_data", "Directory to store the files")
+	flag.Parse()
+
+	ip := *ipPtr
+	if ip == "" {
+		ip = utils.GetLocalIP().String()
+	}
+	datanode := NewDatanode(ip, *dirPtr)
+	if datanode == nil {
+		log.Println("Initialize datanode failed")
+		return
+	}
+	log.Printf("SDFS Datanode listening on %s:%d\n", ip, utils.DatanodePort)
+	if err := datanode.StartDaemon(*masterPtr); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"crane/simpledfs/utils"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	PutConfirmTimeout = 30
+)
+
+// Put request waiting for the write confirms from its datanodes
+type PendingPut struct {
+	Filename  string
+	Info      utils.Info
+	Confirmed map[uint32]bool
+	Expected  int
+	Done      chan bool
+}
+
+// Master, the metadata server of the SDFS. It places replicas on datanodes,
+// keeps track of file versions and re-replicates files on datanode failure
+type Master struct {
+	Listener   net.Listener
+	Meta       utils.Meta
+	MetaFile   string
+	Members    []utils.NodeID
+	Heartbeats map[uint32]time.Time
+	DataConns  map[uint32]net.Conn
+	Pending    map[string]*PendingPut
+	Mutex      sync.Mutex
+}
+
+// Factory mode to return the Master instance
+func NewMaster(addr string, metaFile string) *Master {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		utils.PrintError(err)
+		return nil
+	}
+	master := &Master{}
+	master.Listener = listener
+	master.MetaFile = metaFile
+	master.Meta = utils.NewMeta(metaFile)
+	master.Members = make([]utils.NodeID, 0)
+	master.Heartbeats = make(map[uint32]time.Time)
+	master.DataConns = make(map[uint32]net.Conn)
+	master.Pending = make(map[string]*PendingPut)
+	return master
+}
+
+// Start the Master daemon
+func (m *Master) StartDaemon() {
+	go m.DetectFailures()
+	for {
+		conn, err := m.Listener.Accept()
+		if err != nil {
+			log.Println("Fail to accept connection. ", err)
+			return
+		}
+		go m.HandleConn(conn)
+	}
+}
+
+// Dispatch the connection either as a datanode control link or a client session
+func (m *Master) HandleConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		header, err := reader.Peek(1)
+		if err != nil {
+			return
+		}
+		switch header[0] {
+		case utils.JoinRequestMsg:
+			jr := utils.JoinRequest{}
+			if utils.ReadPacket(reader, &jr) != nil {
+				return
+			}
+			m.HandleDatanode(jr, conn, reader)
+			return
+		case utils.PutRequestMsg:
+			pr := utils.PutRequest{}
+			if utils.ReadPacket(reader, &pr) != nil {
+				return
+			}
+			if !m.HandlePut(pr, conn) {
+				return
+			}
+		case utils.GetRequestMsg:
+			gr := utils.GetRequest{}
+			if utils.ReadPacket(reader, &gr) != nil {
+				return
+			}
+			m.HandleGet(gr, conn)
+		case utils.GetVersionsRequestMsg:
+			gvr := utils.GetVersionsRequest{}
+			if utils.ReadPacket(reader, &gvr) != nil {
+				return
+			}
+			m.HandleGetVersions(gvr, conn)
+		case utils.DeleteRequestMsg:
+			dr := utils.DeleteRequest{}
+			if utils.ReadPacket(reader, &dr) != nil {
+				return
+			}
+			m.HandleDelete(dr, conn)
+		case utils.ListRequestMsg:
+			lr := utils.ListRequest{}
+			if utils.ReadPacket(reader, &lr) != nil {
+				return
+			}
+			m.HandleList(lr, conn)
+		case utils.StoreRequestMsg:
+			sr := utils.StoreRequest{}
+			if utils.ReadPacket(reader, &sr) != nil {
+				return
+			}
+			m.HandleStore(conn)
+		default:
+			log.Printf("Unknown message type %d from %s\n", header[0], conn.RemoteAddr().String())
+			return
+		}
+	}
+}
+
+// Register the datanode and serve its heartbeats and confirmations
+func (m *Master) HandleDatanode(jr utils.JoinRequest, conn net.Conn, reader *bufio.Reader) {
+	nodeID := utils.NodeID{Timestamp: uint64(time.Now().UnixNano()), IP: jr.IP}
+	resp := utils.JoinResponse{MsgType: utils.JoinResponseMsg, NodeID: nodeID}
+	if _, err := conn.Write(utils.Serialize(resp)); err != nil {
+		utils.PrintError(err)
+		return
+	}
+
+	m.Mutex.Lock()
+	m.removeMember(jr.IP)
+	m.Members = append(m.Members, nodeID)
+	sort.Slice(m.Members, func(i, j int) bool { return m.Members[i].IP < m.Members[j].IP })
+	m.Heartbeats[jr.IP] = time.Now()
+	m.DataConns[jr.IP] = conn
+	m.Mutex.Unlock()
+	log.Printf("Datanode %s joined. Datanodes in cluster: %d\n", utils.StringIP(jr.IP), len(m.Members))
+
+	for {
+		header, err := reader.Peek(1)
+		if err != nil {
+			log.Printf("Lost datanode %s: %s\n", utils.StringIP(jr.IP), err)
+			return
+		}
+		switch header[0] {
+		case utils.HeartbeatMsg:
+			hb := utils.Heartbeat{}
+			if utils.ReadPacket(reader, &hb) != nil {
+				return
+			}
+			m.Mutex.Lock()
+			if _, ok := m.DataConns[hb.NodeID.IP]; ok {
+				m.Heartbeats[hb.NodeID.IP] = time.Now()
+			}
+			m.Mutex.Unlock()
+		case utils.WriteConfirmMsg:
+			wc := utils.WriteConfirm{}
+			if utils.ReadPacket(reader, &wc) != nil {
+				return
+			}
+			m.HandleWriteConfirm(wc)
+		case utils.ReReplicaResponseMsg:
+			rr := utils.ReReplicaResponse{}
+			if utils.ReadPacket(reader, &rr) != nil {
+				return
+			}
+			m.HandleReReplicaResponse(rr)
+		default:
+			log.Printf("Unknown message type %d from datanode %s\n", header[0], utils.StringIP(jr.IP))
+			return
+		}
+	}
+}
+
+// Assign datanodes for a new file version and wait for them to confirm the write
+func (m *Master) HandlePut(pr utils.PutRequest, conn net.Conn) bool {
+	filename := utils.ParseFilename(pr.Filename[:])
+	hash := utils.HashFilename(filename)
+	timestamp := uint64(time.Now().UnixNano())
+
+	m.Mutex.Lock()
+	dataNodes, err := m.placeReplicas(filename)
+	if err != nil {
+		m.Mutex.Unlock()
+		log.Printf("Put %s failed: %s\n", filename, err)
+		return false
+	}
+	pending := &PendingPut{
+		Filename:  filename,
+		Info:      utils.Info{Timestamp: timestamp, Filesize: pr.Filesize, DataNodes: dataNodes[:]},
+		Confirmed: make(map[uint32]bool),
+		Done:      make(chan bool, 1),
+	}
+	for _, node := range dataNodes {
+		if node.IP != 0 {
+			pending.Expected++
+		}
+	}
+	m.Pending[pendingKey(hash, timestamp)] = pending
+	m.Mutex.Unlock()
+
+	resp := utils.PutResponse{
+		MsgType:      utils.PutResponseMsg,
+		FilenameHash: hash,
+		Filesize:     pr.Filesize,
+		Timestamp:    timestamp,
+		NexthopIP:    dataNodes[0].IP,
+		NexthopPort:  utils.DatanodePort,
+		DataNodeList: dataNodes,
+	}
+	if _, err := conn.Write(utils.Serialize(resp)); err != nil {
+		utils.PrintError(err)
+		m.dropPending(hash, timestamp)
+		return false
+	}
+
+	select {
+	case <-pending.Done:
+	case <-time.After(PutConfirmTimeout * time.Second):
+	}
+
+	m.Mutex.Lock()
+	delete(m.Pending, pendingKey(hash, timestamp))
+	if len(pending.Confirmed) == 0 {
+		m.Mutex.Unlock()
+		log.Printf("Put %s failed: no datanode confirmed the write\n", filename)
+		return false
+	}
+	var confirmed [utils.NumReplica]utils.NodeID
+	i := 0
+	for _, node := range dataNodes {
+		if pending.Confirmed[node.IP] {
+			confirmed[i] = node
+			i++
+		}
+	}
+	pending.Info.DataNodes = confirmed[:]
+	m.Meta.PutFileInfo(filename, pending.Info)
+	m.Meta.StoreMeta(m.MetaFile)
+	m.Mutex.Unlock()
+
+	pc := utils.PutConfirm{MsgType: utils.PutConfirmMsg}
+	copy(pc.Filename[:], filename)
+	if _, err := conn.Write(utils.Serialize(pc)); err != nil {
+		utils.PrintError(err)
+		return false
+	}
+	log.Printf("Put %s finished with version %d on %d datanodes\n", filename, timestamp, i)
+	return true
+}
+
+// Record a datanode's write confirmation for a pending put. The replicas
+// the datanode could not pipeline the write to are not waited for
+func (m *Master) HandleWriteConfirm(wc utils.WriteConfirm) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	pending, ok := m.Pending[pendingKey(wc.FilenameHash, wc.Timestamp)]
+	if !ok {
+		return
+	}
+	pending.Confirmed[wc.DataNode.IP] = true
+	pending.Expected -= int(wc.Unreachable)
+	if len(pending.Confirmed) >= pending.Expected {
+		// Never block with the mutex held, the put may be done already
+		select {
+		case pending.Done <- true:
+		default:
+		}
+	}
+}
+
+// Reply the datanodes holding the latest version of the file
+func (m *Master) HandleGet(gr utils.GetRequest, conn net.Conn) {
+	filename := utils.ParseFilename(gr.Filename[:])
+	resp := utils.GetResponse{MsgType: utils.GetResponseMsg, FilenameHash: utils.HashFilename(filename)}
+
+	m.Mutex.Lock()
+	info, ok := m.Meta.FileInfo(filename)
+	if ok {
+		resp.Found = true
+		resp.Filesize = info.Filesize
+		resp.DataNodeIPList, resp.DataNodePortList = m.aliveHolders(info)
+	}
+	m.Mutex.Unlock()
+
+	_, err := conn.Write(utils.Serialize(resp))
+	utils.PrintError(err)
+}
+
+// Reply one response for each of the latest versions requested
+func (m *Master) HandleGetVersions(gvr utils.GetVersionsRequest, conn net.Conn) {
+	filename := utils.ParseFilename(gvr.Filename[:])
+	hash := utils.HashFilename(filename)
+
+	m.Mutex.Lock()
+	infos, _ := m.Meta.FileInfos(filename)
+	if int(gvr.VersionNum) < len(infos) {
+		infos = infos[:gvr.VersionNum]
+	}
+	responses := make([]utils.GetVersionsResponse, 0)
+	for _, info := range infos {
+		resp := utils.GetVersionsResponse{
+			MsgType:      utils.GetVersionsResponseMsg,
+			VersionNum:   uint8(len(infos)),
+			FilenameHash: hash,
+			Timestamp:    info.Timestamp,
+			Filesize:     info.Filesize,
+		}
+		resp.DataNodeIPList, resp.DataNodePortList = m.aliveHolders(info)
+		responses = append(responses, resp)
+	}
+	m.Mutex.Unlock()
+
+	if len(responses) == 0 {
+		responses = append(responses, utils.GetVersionsResponse{MsgType: utils.GetVersionsResponseMsg, FilenameHash: hash})
+	}
+	for _, resp := range responses {
+		if _, err := conn.Write(utils.Serialize(resp)); err != nil {
+			utils.PrintError(err)
+			return
+		}
+	}
+}
+
+// Remove the file from metadata and all datanodes holding any version of it
+func (m *Master) HandleDelete(dr utils.DeleteRequest, conn net.Conn) {
+	filename := utils.ParseFilename(dr.Filename[:])
+	hash := utils.HashFilename(filename)
+
+	m.Mutex.Lock()
+	infos, ok := m.Meta.RmFileInfo(filename)
+	if ok {
+		m.Meta.StoreMeta(m.MetaFile)
+		holders := make(map[uint32]bool)
+		for _, info := range infos {
+			for _, node := range info.DataNodes {
+				if node.IP != 0 {
+					holders[node.IP] = true
+				}
+			}
+		}
+		rm := utils.RmRequest{MsgType: utils.RmRequestMsg, FilenameHash: hash}
+		for ip := range holders {
+			if dataConn, alive := m.DataConns[ip]; alive {
+				_, err := dataConn.Write(utils.Serialize(rm))
+				utils.PrintError(err)
+			}
+		}
+	}
+	m.Mutex.Unlock()
+
+	resp := utils.DeleteResponse{MsgType: utils.DeleteResponseMsg, IsSuccess: ok}
+	_, err := conn.Write(utils.Serialize(resp))
+	utils.PrintError(err)
+}
+
+// Reply the addresses storing the latest version of the file
+func (m *Master) HandleList(lr utils.ListRequest, conn net.Conn) {
+	filename := utils.ParseFilename(lr.Filename[:])
+	resp := utils.ListResponse{MsgType: utils.ListResponseMsg}
+
+	m.Mutex.Lock()
+	info, ok := m.Meta.FileInfo(filename)
+	if ok {
+		resp.DataNodeIPList, _ = m.aliveHolders(info)
+	}
+	m.Mutex.Unlock()
+
+	_, err := conn.Write(utils.Serialize(resp))
+	utils.PrintError(err)
+}
+
+// Reply all the files stored on the requesting machine
+func (m *Master) HandleStore(conn net.Conn) {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	m.Mutex.Lock()
+	files := m.Meta.FilesIn(utils.BinaryIP(host))
+	m.Mutex.Unlock()
+
+	resp := utils.StoreResponse{MsgType: utils.StoreResponseMsg, FilesNum: uint32(len(files))}
+	if _, err := conn.Write(utils.Serialize(resp)); err != nil {
+		utils.PrintError(err)
+		return
+	}
+	for _, file := range files {
+		var filename [128]byte
+		copy(filename[:], file)
+		if _, err := conn.Write(filename[:]); err != nil {
+			utils.PrintError(err)
+			return
+		}
+	}
+}
+
+// Update the datanodes of a file version after its re-replication
+func (m *Master) HandleReReplicaResponse(rr utils.ReReplicaResponse) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	for filename, infos := range m.Meta {
+		if utils.HashFilename(filename) != rr.FilenameHash {
+			continue
+		}
+		for _, info := range infos {
+			if info.Timestamp == rr.Timestamp {
+				m.Meta.UpdateFileInfoWithTs(filename, rr.DataNodeList[:], rr.Timestamp)
+				m.Meta.StoreMeta(m.MetaFile)
+				log.Printf("Re-replicated %s version %d\n", filename, rr.Timestamp)
+				return
+			}
+		}
+	}
+}
+
+// Periodically check datanode heartbeats and re-replicate files of failed ones
+func (m *Master) DetectFailures() {
+	for {
+		time.Sleep(utils.HeartbeatPeriod * time.Second)
+		failed := make([]uint32, 0)
+		m.Mutex.Lock()
+		for ip, last := range m.Heartbeats {
+			if time.Since(last) > utils.HeartbeatTimeout*time.Second {
+				failed = append(failed, ip)
+			}
+		}
+		for _, ip := range failed {
+			log.Printf("Datanode %s failed\n", utils.StringIP(ip))
+			if conn, ok := m.DataConns[ip]; ok {
+				conn.Close()
+			}
+			m.removeMember(ip)
+		}
+		if len(failed) > 0 {
+			m.reReplicate()
+		}
+		m.Mutex.Unlock()
+	}
+}
+
+// Ask an alive holder of each under-replicated version to copy it to new datanodes
+// Must be called with the mutex held
+func (m *Master) reReplicate() {
+	for filename, infos := range m.Meta {
+		for _, info := range infos {
+			holders := make([]utils.NodeID, 0)
+			for _, node := range info.DataNodes {
+				if _, alive := m.DataConns[node.IP]; alive && node.IP != 0 {
+					holders = append(holders, node)
+				}
+			}
+			if len(holders) == 0 {
+				log.Printf("All replicas of %s version %d are lost\n", filename, info.Timestamp)
+				continue
+			}
+			wanted := utils.NumReplica
+			if len(m.Members) < wanted {
+				wanted = len(m.Members)
+			}
+			if len(holders) >= wanted {
+				continue
+			}
+
+			var dataNodes [utils.NumReplica]utils.NodeID
+			copy(dataNodes[:], holders)
+			n := len(holders)
+			for _, member := range m.Members {
+				if n == wanted {
+					break
+				}
+				if !containsNode(holders, member.IP) {
+					dataNodes[n] = member
+					n++
+				}
+			}
+
+			req := utils.ReReplicaRequest{
+				MsgType:      utils.ReReplicaRequestMsg,
+				FilenameHash: utils.HashFilename(filename),
+				Timestamp:    info.Timestamp,
+				DataNodeList: dataNodes,
+				TimeToLive:   uint8(len(holders)),
+			}
+			conn := m.DataConns[holders[0].IP]
+			_, err := conn.Write(utils.Serialize(req))
+			utils.PrintError(err)
+		}
+	}
+}
+
+// Choose the replica datanodes of a file with the hash replica range
+// Must be called with the mutex held
+func (m *Master) placeReplicas(filename string) ([utils.NumReplica]utils.NodeID, error) {
+	var dataNodes [utils.NumReplica]utils.NodeID
+	indexes, err := utils.HashReplicaRange(filename, uint32(len(m.Members)))
+	if len(m.Members) == 0 {
+		return dataNodes, err
+	}
+	for i, index := range indexes {
+		if index != 255 {
+			dataNodes[i] = m.Members[index]
+		}
+	}
+	return dataNodes, nil
+}
+
+// Alive datanodes first, so that clients dial a reachable replica at first
+// Must be called with the mutex held
+func (m *Master) aliveHolders(info utils.Info) ([utils.NumReplica]uint32, [utils.NumReplica]uint16) {
+	var ips [utils.NumReplica]uint32
+	var ports [utils.NumReplica]uint16
+	n := 0
+	for _, node := range info.DataNodes {
+		if _, alive := m.DataConns[node.IP]; alive && node.IP != 0 && n < utils.NumReplica {
+			ips[n] = node.IP
+			ports[n] = utils.DatanodePort
+			n++
+		}
+	}
+	return ips, ports
+}
+
+// Must be called with the mutex held
+func (m *Master) removeMember(ip uint32) {
+	for i, member := range m.Members {
+		if member.IP == ip {
+			m.Members = append(m.Members[:i], m.Members[i+1:]...)
+			break
+		}
+	}
+	delete(m.Heartbeats, ip)
+	delete(m.DataConns, ip)
+}
+
+func (m *Master) dropPending(hash [32]byte, timestamp uint64) {
+	m.Mutex.Lock()
+	delete(m.Pending, pendingKey(hash, timestamp))
+	m.Mutex.Unlock()
+}
+
+func pendingKey(hash [32]byte, timestamp uint64) string {
+	return fmt.Sprintf("%x_%d", hash, timestamp)
+}
+
+func containsNode(nodes []utils.NodeID, ip uint32) bool {
+	for _, node := range nodes {
+		if node.IP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	portPtr := flag.Int("port", utils.MasterPort, "Master's listening port")
+	metaPtr := flag.String("meta", "meta.json", "File to persist the metadata")
+	flag.Parse()
+
+	master := NewMaster(":"+fmt.Sprintf("%d", *portPtr), *metaPtr)
+	if master == nil {
+		log.Println("Initialize master failed")
+		return
+	}
+	log.Printf("SDFS Master listening on port %d\n", *portPtr)
+	master.StartDaemon()
+}
@@ -4,6 +4,10 @@ import ()
 
 const (
 	NumReplica             = 4
+	MasterPort             = 5000
+	DatanodePort           = 5001
+	HeartbeatPeriod        = 1
+	HeartbeatTimeout       = 5
 	PutRequestMsg          = 1
 	PutResponseMsg         = 2
 	PutConfirmMsg          = 3
@@ -25,6 +29,9 @@ const (
 	GetVersionsResponseMsg = 19
 	ReadVersionRequestMsg  = 20
 	RmRequestMsg           = 21
+	JoinRequestMsg         = 22
+	JoinResponseMsg        = 23
+	HeartbeatMsg           = 24
 )
 
 type PutRequest struct {
@@ -62,6 +69,7 @@ type WriteConfirm struct {
 	Filesize     uint64
 	Timestamp    uint64
 	DataNode     NodeID
+	Unreachable  uint8 // Replicas the write could not be pipelined to
 }
 
 type GetRequest struct {
@@ -72,6 +80,7 @@ type GetRequest struct {
 type GetResponse struct {
 	MsgType          uint8
 	FilenameHash     [32]byte
+	Found            bool // An empty file is found with no size
 	Filesize         uint64
 	DataNodeIPList   [NumReplica]uint32
 	DataNodePortList [NumReplica]uint16
@@ -161,6 +170,21 @@ type ReReplicaResponse struct {
 	DataNodeList [NumReplica]NodeID
 }
 
+type JoinRequest struct {
+	MsgType uint8
+	IP      uint32
+}
+
+type JoinResponse struct {
+	MsgType uint8
+	NodeID  NodeID
+}
+
+type Heartbeat struct {
+	MsgType uint8
+	NodeID  NodeID
+}
+
 type NodeID struct {
 	Timestamp uint64
 	IP        uint32
@@ -8,9 +8,9 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"net"
 	"os"
-	"os/exec"
 )
 
 func Serialize(data interface{}) []byte {
@@ -24,6 +24,11 @@ func Deserialize(data []byte, sample interface{}) {
 	binary.Read(buf, binary.BigEndian, sample)
 }
 
+// Read a fixed size binary packet from the stream into sample
+func ReadPacket(reader io.Reader, sample interface{}) error {
+	return binary.Read(reader, binary.BigEndian, sample)
+}
+
 func ParseFilename(data []byte) string {
 	n := bytes.IndexByte(data, 0)
 	filename := fmt.Sprintf("%s", data[:n])
@@ -92,35 +97,32 @@ func PrintError(err error) {
 	}
 }
 
-// A trick to simply get local IP address
+// Local IP address of the first network interface up, other than the
+// loopback. The loopback address if there is no network, e.g. offline
 func GetLocalIP() net.IP {
-	dial, err := net.Dial("udp", "8.8.8.8:80")
+	addrs, err := net.InterfaceAddrs()
 	PrintError(err)
-	localAddr := dial.LocalAddr().(*net.UDPAddr)
-	dial.Close()
-
-	return localAddr.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP
+		}
+	}
+	return net.IPv4(127, 0, 0, 1)
 }
 
-// Return local FQDN
+// Return local hostname
 func GetLocalHostname() string {
-	cmd := exec.Command("/bin/hostname", "-f")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	hostname := out.String()
-	hostname = hostname[:len(hostname)-1] // removing EOL
-
+	hostname, err := os.Hostname()
+	PrintError(err)
 	return hostname
 }
 
+// IP address of the hostname, empty if it is not resolved
 func LookupIP(hostname string) string {
 	addrs, err := net.LookupHost(hostname)
-	if err != nil {
-		fmt.Println(err.Error())
+	if err != nil || len(addrs) == 0 {
+		PrintError(err)
+		return ""
 	}
 
 	return addrs[0]
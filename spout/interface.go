@@ -1,5 +1,9 @@
 package spout
 
+import (
+	"crane/core/utils"
+)
+
 type SpoutInst struct {
 	Name         string
 	InputFile    string
@@ -8,7 +12,12 @@ type SpoutInst struct {
 	GroupingHint string
 	FieldIndex   int
 	InstNum      int
+	Streams      []string
+	OutputFields map[string][]string
 	TaskAddrs    []string
+	CPU          float64
+	Memory       int
+	Batch        utils.BatchConfig
 }
 
 func NewSpoutInst(name, pluginFile, pluginSymbol string, grouping string, mainField int) *SpoutInst {
@@ -19,6 +28,8 @@ func NewSpoutInst(name, pluginFile, pluginSymbol string, grouping string, mainFi
 	spoutInst.GroupingHint = grouping
 	spoutInst.FieldIndex = mainField
 	spoutInst.InstNum = 1
+	spoutInst.Streams = []string{utils.DEFAULT_STREAM}
+	spoutInst.OutputFields = make(map[string][]string)
 	spoutInst.TaskAddrs = make([]string, 0)
 	return spoutInst
 }
@@ -32,3 +43,80 @@ func (si *SpoutInst) SetInstanceNum(n int) {
 func (si *SpoutInst) SetInputFile(input string) {
 	si.InputFile = input
 }
+
+// Declare the resources every task requires for the resource aware
+// scheduler, CPU in percent of a core and memory in MB
+func (si *SpoutInst) SetResources(cpu float64, memory int) {
+	si.CPU = cpu
+	si.Memory = memory
+}
+
+// Batch the tuples sent over every outgoing edge, up to maxTuples tuples
+// or maxBytes bytes (0 for no limit) waiting at most linger milliseconds
+func (si *SpoutInst) SetBatching(maxTuples, maxBytes, linger int) {
+	si.Batch = utils.BatchConfig{MaxTuples: maxTuples, MaxBytes: maxBytes, Linger: linger}
+}
+
+// Declare a named output stream besides the default one, with the
+// names of its tuples' fields
+func (si *SpoutInst) DeclareStream(stream string, fields ...string) {
+	si.Streams = append(si.Streams, stream)
+	if len(fields) > 0 {
+		si.declareFields(stream, fields)
+	}
+}
+
+// Declare the names of the fields of the default stream's tuples
+func (si *SpoutInst) DeclareOutputFields(fields ...string) {
+	si.declareFields(utils.DEFAULT_STREAM, fields)
+}
+
+func (si *SpoutInst) declareFields(stream string, fields []string) {
+	if si.OutputFields == nil {
+		si.OutputFields = make(map[string][]string)
+	}
+	si.OutputFields[stream] = fields
+}
+
+// Collector of the tuples a spout emits
+type SpoutOutputCollector struct {
+	emit func(string, []interface{})
+}
+
+// Factory mode to return the SpoutOutputCollector instance,
+// used by the spout workers
+func NewSpoutOutputCollector(emit func(string, []interface{})) *SpoutOutputCollector {
+	return &SpoutOutputCollector{emit: emit}
+}
+
+// Emit a tuple to the default stream, a spout can emit any number
+// of tuples per NextTuple call
+func (c *SpoutOutputCollector) Emit(values ...interface{}) {
+	c.emit(utils.DEFAULT_STREAM, values)
+}
+
+// Emit a tuple to the named output stream
+func (c *SpoutOutputCollector) EmitTo(stream string, values ...interface{}) {
+	c.emit(stream, values)
+}
+
+// Spout, the typed interface of the spouts. The plugin exports a factory
+// of type func() Spout as the spout's plugin symbol, every task gets its own
+// instance. A spout keeping state implements state.Stateful as well
+type Spout interface {
+	// Open the spout of the task before it emits any tuple
+	Open(task string, collector *SpoutOutputCollector) error
+	// Emit the next tuples with the collector, it is called in a loop
+	// and an error means there is no tuple for now
+	NextTuple() error
+	// Close the spout when the task terminates
+	Close()
+}
+
+// Optional interface of the spouts to be notified when their tuples are
+// completed or failed with acking enabled. Ack and Fail are called between
+// two NextTuple calls
+type ReliableSpout interface {
+	Ack(tuple []interface{})
+	Fail(tuple []interface{})
+}
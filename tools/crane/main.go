@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crane/core/client"
+	"crane/core/utils"
+	"crane/topology"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Usage of correct crane command
+func usage() {
+	fmt.Println("Usage of ./crane")
+	fmt.Println("   -driver=[driver IP:Port] submit [manifest.yaml|manifest.json]")
+	fmt.Println("   validate [manifest.yaml|manifest.json]")
+	fmt.Println("   -driver=[driver IP:Port] list")
+	fmt.Println("   -driver=[driver IP:Port] describe [topology]")
+	fmt.Println("   -driver=[driver IP:Port] supervisors")
+	fmt.Println("   -driver=[driver IP:Port] checkpoint [topology]")
+	fmt.Println("   -driver=[driver IP:Port] logs [topology] [task] [-n lines]")
+	fmt.Println("   -driver=[driver IP:Port] kill [topology]")
+	fmt.Println("   -driver=[driver IP:Port] deactivate [topology]")
+	fmt.Println("   -driver=[driver IP:Port] activate [topology]")
+	fmt.Println("   -driver=[driver IP:Port] rebalance [topology] [bolt] [instances]  (restarts all the tasks from the last snapshot)")
+}
+
+func main() {
+	// If no command line arguments, return
+	if len(os.Args) <= 1 {
+		usage()
+		return
+	}
+	configPtr := flag.String("config", "", "Cluster config file, YAML or JSON, $"+utils.ENV_CONFIG+" by default")
+	driverPtr := flag.String("driver", "", "Driver's IP:Port address, or the addresses of the standby drivers too separated by commas, the drivers of the config by default")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		return
+	}
+	command := args[0]
+	// Validate the manifest without the driver
+	if command == "validate" {
+		if len(args) != 2 {
+			fmt.Println("Invalid validate usage")
+			usage()
+			return
+		}
+		m, err := topology.LoadManifest(args[1])
+		exitOnError(err)
+		_, err = m.Topology()
+		exitOnError(err)
+		fmt.Printf("Manifest %s is valid\n", args[1])
+		return
+	}
+
+	// The topology package submits the plugin files with the same config
+	if *configPtr != "" {
+		os.Setenv(utils.ENV_CONFIG, *configPtr)
+	}
+	cfg, err := utils.LoadConfig("")
+	exitOnError(err)
+	if *driverPtr == "" {
+		*driverPtr = strings.Join(cfg.Drivers, ",")
+	}
+	c := client.NewClient(*driverPtr)
+	if c == nil {
+		exitOnError(errors.New("initialize client failed"))
+	}
+
+	switch command {
+	case "submit":
+		if len(args) != 2 {
+			fmt.Println("Invalid submit usage")
+			usage()
+			return
+		}
+		id, err := submit(c, args[1])
+		exitOnError(err)
+		fmt.Printf("Topology %s submitted\n", id)
+
+	case "list":
+		summaries, err := c.ListTopologies()
+		exitOnError(err)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSTATUS\tTASKS\tSUPERVISORS\tSNAPSHOT")
+		for _, s := range summaries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", s.Id, s.Name, status(s.Active), s.Tasks, s.Supervisors, s.SnapshotVersion)
+		}
+		w.Flush()
+
+	case "describe":
+		if len(args) != 2 {
+			fmt.Println("Invalid describe usage")
+			usage()
+			return
+		}
+		desc, err := c.DescribeTopology(args[1])
+		exitOnError(err)
+		fmt.Printf("Topology %s (%s), %s, snapshot version %d\n", desc.Id, desc.Name, status(desc.Active), desc.SnapshotVersion)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "COMPONENT\tKIND\tINSTANCES\tSUBSCRIBES")
+		for _, comp := range desc.Components {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", comp.Name, comp.Kind, comp.InstNum, strings.Join(comp.PrevTasks, ","))
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "TASK\tCOMPONENT\tSUPERVISOR\tADDRESS\tSTATE\tRESTARTS\tEMITTED\tEXECUTED\tACKED\tFAILED")
+		for _, t := range desc.Tasks {
+			state := t.Status.State
+			if state == "" {
+				state = "unknown"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\n", t.Task, t.Component, t.Supervisor, t.Addr,
+				state, t.Status.Restarts, t.Status.Emitted, t.Status.Executed, t.Status.Acked, t.Status.Failed)
+		}
+		w.Flush()
+		// Why the tasks crashed the last time
+		for _, t := range desc.Tasks {
+			if t.Status.Error != "" {
+				fmt.Printf("Task %s crashed: %s\n", t.Task, t.Status.Error)
+			}
+		}
+
+	case "supervisors":
+		supervisors, err := c.ListSupervisors()
+		exitOnError(err)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tTASKS\tFREE SLOTS\tCPU %\tMEMORY MB\tLOAD %\tUSED MB\tHEARTBEAT")
+		for _, s := range supervisors {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.0f/%.0f\t%d/%d\t%.0f\t%d\t%.0fs ago\n", s.Id, s.Name, s.Tasks, s.FreeSlots,
+				s.Available.CPU, s.Capacity.CPU, s.Available.Memory, s.Capacity.Memory, s.Used.CPU, s.Used.Memory, s.HeartbeatSecs)
+		}
+		w.Flush()
+
+	case "checkpoint":
+		if len(args) != 2 {
+			fmt.Println("Invalid checkpoint usage")
+			usage()
+			return
+		}
+		exitOnError(c.Checkpoint(args[1]))
+		fmt.Printf("Topology %s checkpoint requested\n", args[1])
+
+	case "logs":
+		logsFlag := flag.NewFlagSet("logs", flag.ExitOnError)
+		linesPtr := logsFlag.Int("n", 100, "Number of the latest lines")
+		if len(args) < 3 {
+			fmt.Println("Invalid logs usage")
+			usage()
+			return
+		}
+		logsFlag.Parse(args[3:])
+		lines, err := c.TailLogs(args[1], args[2], *linesPtr)
+		exitOnError(err)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+
+	case "kill", "deactivate", "activate":
+		if len(args) != 2 {
+			fmt.Printf("Invalid %s usage\n", command)
+			usage()
+			return
+		}
+		id := args[1]
+		switch command {
+		case "kill":
+			exitOnError(c.Kill(id))
+			fmt.Printf("Topology %s killed\n", id)
+		case "deactivate":
+			exitOnError(c.Deactivate(id))
+			fmt.Printf("Topology %s deactivated\n", id)
+		case "activate":
+			exitOnError(c.Activate(id))
+			fmt.Printf("Topology %s activated\n", id)
+		}
+
+	case "rebalance":
+		if len(args) != 4 {
+			fmt.Println("Invalid rebalance usage")
+			usage()
+			return
+		}
+		instNum, err := strconv.Atoi(args[3])
+		exitOnError(err)
+		exitOnError(c.Rebalance(args[1], args[2], instNum))
+		fmt.Printf("Topology %s rebalancing %s to %d instances, all its tasks restart from the last snapshot\n", args[1], args[2], instNum)
+
+	default:
+		usage()
+	}
+}
+
+// Submit the topology of the manifest, the files it lists are
+// submitted to the distributed file system first
+func submit(c *client.Client, path string) (string, error) {
+	m, err := topology.LoadManifest(path)
+	if err != nil {
+		return "", err
+	}
+	topo, err := m.Topology()
+	if err != nil {
+		return "", err
+	}
+	if err := m.SubmitFiles(topo); err != nil {
+		return "", err
+	}
+	return c.Submit(*topo)
+}
+
+func status(active bool) string {
+	if active {
+		return "ACTIVE"
+	}
+	return "INACTIVE"
+}
+
+// Print the error and exit with failure status
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "[ERROR]", err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crane/core/codec"
+	"crane/core/messages"
+	"crane/core/utils"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Benchmark of a worker to worker link: a publisher sends the tuples to a
+// subscriber over TCP on this machine, the way the workers do, once for
+// every batch size
+func main() {
+	tuplesPtr := flag.Int("tuples", 200000, "Number of tuples sent for every batch size")
+	sizePtr := flag.Int("size", 64, "Bytes of the string value of every tuple")
+	codecPtr := flag.String("codec", utils.CODEC_JSON, "Codec of the tuples, json or binary")
+	batchesPtr := flag.String("batches", "1,10,100,1000", "Max tuples of a batch to compare, separated by commas")
+	maxBytesPtr := flag.Int("maxbytes", 0, "Max bytes of a batch, 0 for no limit")
+	lingerPtr := flag.Int("linger", 0, "Milliseconds a batch waits for more tuples")
+	flag.Parse()
+
+	c, err := codec.NewCodec(*codecPtr)
+	exitOnError(err)
+	batches := make([]int, 0)
+	for _, s := range strings.Split(*batchesPtr, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		exitOnError(err)
+		batches = append(batches, n)
+	}
+	// The subscribers log every frame they receive
+	log.SetOutput(io.Discard)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BATCH\tTUPLES/S\tMB/S\tSPEEDUP")
+	var base float64
+	for _, n := range batches {
+		batch := utils.BatchConfig{MaxTuples: n, MaxBytes: *maxBytesPtr, Linger: *lingerPtr}
+		elapsed, bytes, err := run(c, batch, *tuplesPtr, *sizePtr)
+		exitOnError(err)
+		rate := float64(*tuplesPtr) / elapsed.Seconds()
+		if base == 0 {
+			base = rate
+		}
+		fmt.Fprintf(w, "%d\t%.0f\t%.1f\t%.1fx\n", n, rate, float64(bytes)/elapsed.Seconds()/(1<<20), rate/base)
+	}
+	w.Flush()
+}
+
+// Send the tuples over a new link and wait for the subscriber to decode
+// them all. Returns the time taken and the bytes of the tuples
+func run(c codec.Codec, batch utils.BatchConfig, n int, size int) (time.Duration, int, error) {
+	pub := messages.NewPublisher("127.0.0.1:0")
+	if pub == nil {
+		return 0, 0, fmt.Errorf("listen failed")
+	}
+	defer pub.Close()
+	go pub.AcceptConns()
+	go pub.PublishMessage(pub.PublishBoard)
+	sub := messages.NewSubscriber(pub.Listener.Addr().String())
+	if sub == nil {
+		return 0, 0, fmt.Errorf("connect to %s failed", pub.Listener.Addr())
+	}
+	defer sub.Conn.Close()
+	go sub.ReadMessage()
+	// The publisher adds the connection after the handshake
+	connId := sub.Conn.LocalAddr().String()
+	for pub.Pool.Get(connId) == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	value := strings.Repeat("x", size)
+	bin, err := c.Encode(utils.TupleMessage{Values: []interface{}{value, int64(n)}})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The tuples queued for the sender, as the outputs of a worker
+	tuples := make(chan utils.TupleMessage, 1024)
+	go func() {
+		for i := 0; i < n; i++ {
+			tuples <- utils.TupleMessage{Values: []interface{}{value, int64(i)}}
+		}
+		close(tuples)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			message := <-sub.PublishBoard
+			var tuple utils.TupleMessage
+			if err := codec.Decode(message.Payload, &tuple); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	started := time.Now()
+	batcher := messages.NewBatcher(pub.PublishBoard, batch)
+	for {
+		if len(tuples) == 0 {
+			batcher.Idle()
+		}
+		var tuple utils.TupleMessage
+		var ok bool
+		select {
+		case tuple, ok = <-tuples:
+		case <-batcher.Expired():
+			batcher.Expire()
+			continue
+		}
+		if !ok {
+			batcher.Flush()
+			break
+		}
+		payload, err := c.Encode(tuple)
+		if err != nil {
+			return 0, 0, err
+		}
+		batcher.Add(messages.Message{Payload: payload, TargetConnId: connId})
+	}
+	if err := <-done; err != nil {
+		return 0, 0, err
+	}
+	return time.Since(started), n * len(bin), nil
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -1,20 +1,26 @@
 package topology
 
 import (
+	"context"
 	"crane/bolt"
 	"crane/core/client"
 	"crane/core/utils"
+	sdfs "crane/simpledfs/client"
 	"crane/spout"
-	"fmt"
+	"errors"
 	"log"
-	"os/exec"
-	"os/user"
+	"strings"
 )
 
 // Topology interface for bolts and spouts submissions to driver
 type Topology struct {
-	Bolts  []bolt.BoltInst
-	Spouts []spout.SpoutInst
+	Name         string
+	Bolts        []bolt.BoltInst
+	Spouts       []spout.SpoutInst
+	StateBackend string
+	AckTimeout   int
+	Scheduler    string
+	Recovery     string
 }
 
 // Factory mode to create a new Topology instance
@@ -22,9 +28,40 @@ func NewTopology() *Topology {
 	topology := &Topology{}
 	topology.Bolts = make([]bolt.BoltInst, 0)
 	topology.Spouts = make([]spout.SpoutInst, 0)
+	topology.StateBackend = utils.STATE_BACKEND_SDFS
 	return topology
 }
 
+// Name the topology, the driver identifies it by the name and a sequence
+// number, so that topologies of the same name can run together
+func (t *Topology) SetName(name string) {
+	t.Name = name
+}
+
+// Select where the bolts and spouts checkpoint their states,
+// one of utils.STATE_BACKEND_LOCAL, STATE_BACKEND_SDFS or STATE_BACKEND_MEMORY
+func (t *Topology) SetStateBackend(kind string) {
+	t.StateBackend = kind
+}
+
+// Track every spout tuple until it is fully processed, the tuples not
+// acked in timeout seconds are failed and replayed by their spouts
+func (t *Topology) EnableAcking(timeout int) {
+	t.AckTimeout = timeout
+}
+
+// Select how the driver places the tasks on the supervisors, one of
+// utils.SCHEDULER_ROUND_ROBIN (default) or SCHEDULER_RESOURCE_AWARE
+func (t *Topology) SetScheduler(scheduler string) {
+	t.Scheduler = scheduler
+}
+
+// Select how the topology recovers from a supervisor failure, one of
+// utils.RECOVERY_TASKS (default) or RECOVERY_TOPOLOGY
+func (t *Topology) SetRecovery(recovery string) {
+	t.Recovery = recovery
+}
+
 // Add a new spout instance
 func (t *Topology) AddSpout(s *spout.SpoutInst) {
 	t.Spouts = append(t.Spouts, *s)
@@ -35,30 +72,40 @@ func (t *Topology) AddBolt(b *bolt.BoltInst) {
 	t.Bolts = append(t.Bolts, *b)
 }
 
-// Submit the topology
-func (t *Topology) Submit(driverAddr string) {
+// Submit the topology, the error is a *client.SubmissionError
+// with all the reasons if the driver rejects it. The drivers of the
+// cluster config are used if the address is empty
+func (t *Topology) Submit(driverAddr string) error {
+	if driverAddr == "" {
+		cfg, err := utils.LoadConfig("")
+		if err != nil {
+			return err
+		}
+		driverAddr = strings.Join(cfg.Drivers, ",")
+	}
 	client := client.NewClient(driverAddr)
 	if client == nil {
-		log.Println("Initialize client failed")
-		return
+		return errors.New("initialize client failed")
 	}
-	b, err := utils.Marshal(utils.TOPO_SUBMISSION, *t)
+	id, err := client.Submit(*t)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	client.ContactDriver(b)
-	client.Start()
+	log.Printf("Topology %s Accepted\n", id)
+	return nil
 }
 
-// Submit the related file to distributed file system
-func (t *Topology) SubmitFile(localPath, remoteName string) {
-	usr, _ := user.Current()
-	usrHome := usr.HomeDir
-	cmd := exec.Command(usrHome+"/go/src/crane/tools/sdfs_client/sdfs_client", "-master", "fa18-cs425-g29-01.cs.illinois.edu:5000", "put", localPath, remoteName)
-	stdoutStderr, err := cmd.CombinedOutput()
+// Submit the related file to distributed file system, the SDFS master
+// is the one of the cluster config
+func (t *Topology) SubmitFile(localPath, remoteName string) error {
+	cfg, err := utils.LoadConfig("")
+	if err != nil {
+		return err
+	}
+	err = sdfs.NewClient(cfg.SdfsMaster).Put(context.Background(), localPath, remoteName)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	fmt.Printf("%s\n", stdoutStderr)
+	log.Printf("Submit File %s as %s\n", localPath, remoteName)
+	return nil
 }
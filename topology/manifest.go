@@ -0,0 +1,208 @@
+package topology
+
+import (
+	"bytes"
+	"crane/bolt"
+	"crane/core/utils"
+	"crane/spout"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest, the declarative description of a topology in YAML or JSON,
+// so that a pipeline is versioned as config instead of a main program
+type Manifest struct {
+	Name   string          `json:"name" yaml:"name"`
+	Config ManifestConfig  `json:"config" yaml:"config"`
+	Files  []string        `json:"files" yaml:"files"`
+	Spouts []SpoutManifest `json:"spouts" yaml:"spouts"`
+	Bolts  []BoltManifest  `json:"bolts" yaml:"bolts"`
+	// Directory of the manifest file, the files are relative to it
+	dir string
+}
+
+// Settings of the whole topology
+type ManifestConfig struct {
+	StateBackend string `json:"stateBackend" yaml:"stateBackend"`
+	AckTimeout   int    `json:"ackTimeout" yaml:"ackTimeout"`
+	Scheduler    string `json:"scheduler" yaml:"scheduler"`
+	Recovery     string `json:"recovery" yaml:"recovery"`
+}
+
+// Named output stream of a spout or bolt
+type StreamManifest struct {
+	Name   string   `json:"name" yaml:"name"`
+	Fields []string `json:"fields" yaml:"fields"`
+}
+
+// Stream of a spout or bolt subscribed by a bolt, grouped by
+// the fields if they are given
+type InputManifest struct {
+	Component string   `json:"component" yaml:"component"`
+	Stream    string   `json:"stream" yaml:"stream"`
+	Fields    []string `json:"fields" yaml:"fields"`
+}
+
+// Batching of the tuples sent over the outgoing edges, linger in
+// milliseconds
+type BatchManifest struct {
+	MaxTuples int `json:"maxTuples" yaml:"maxTuples"`
+	MaxBytes  int `json:"maxBytes" yaml:"maxBytes"`
+	Linger    int `json:"linger" yaml:"linger"`
+}
+
+type SpoutManifest struct {
+	Name         string           `json:"name" yaml:"name"`
+	Plugin       string           `json:"plugin" yaml:"plugin"`
+	Symbol       string           `json:"symbol" yaml:"symbol"`
+	Grouping     string           `json:"grouping" yaml:"grouping"`
+	FieldIndex   int              `json:"fieldIndex" yaml:"fieldIndex"`
+	Parallelism  int              `json:"parallelism" yaml:"parallelism"`
+	CPU          float64          `json:"cpu" yaml:"cpu"`
+	Memory       int              `json:"memory" yaml:"memory"`
+	InputFile    string           `json:"inputFile" yaml:"inputFile"`
+	OutputFields []string         `json:"outputFields" yaml:"outputFields"`
+	Streams      []StreamManifest `json:"streams" yaml:"streams"`
+	Batch        BatchManifest    `json:"batch" yaml:"batch"`
+}
+
+type BoltManifest struct {
+	Name         string           `json:"name" yaml:"name"`
+	Plugin       string           `json:"plugin" yaml:"plugin"`
+	Symbol       string           `json:"symbol" yaml:"symbol"`
+	Grouping     string           `json:"grouping" yaml:"grouping"`
+	FieldIndex   int              `json:"fieldIndex" yaml:"fieldIndex"`
+	Parallelism  int              `json:"parallelism" yaml:"parallelism"`
+	CPU          float64          `json:"cpu" yaml:"cpu"`
+	Memory       int              `json:"memory" yaml:"memory"`
+	Inputs       []InputManifest  `json:"inputs" yaml:"inputs"`
+	OutputFields []string         `json:"outputFields" yaml:"outputFields"`
+	Streams      []StreamManifest `json:"streams" yaml:"streams"`
+	Codec        string           `json:"codec" yaml:"codec"`
+	Batch        BatchManifest    `json:"batch" yaml:"batch"`
+}
+
+// Load the manifest file, YAML for the .yaml and .yml files and
+// JSON for the others. Unknown keys are errors to catch the typos
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		err = dec.Decode(m)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %v", path, err)
+	}
+	m.dir = filepath.Dir(path)
+	return m, nil
+}
+
+// Grouping hint of the manifest, the short names field, shuffle and all
+// or the utils.GROUPING_BY_* constants. The others are kept as they are
+// and reported by the validation
+func groupingHint(grouping string) string {
+	switch grouping {
+	case "field", "fields":
+		return utils.GROUPING_BY_FIELD
+	case "shuffle", "":
+		return utils.GROUPING_BY_SHUFFLE
+	case "all":
+		return utils.GROUPING_BY_ALL
+	}
+	return grouping
+}
+
+// Build the topology of the manifest and validate it, the error
+// joins all the problems found
+func (m *Manifest) Topology() (*Topology, error) {
+	t := NewTopology()
+	t.SetName(m.Name)
+	if m.Config.StateBackend != "" {
+		t.SetStateBackend(m.Config.StateBackend)
+	}
+	t.EnableAcking(m.Config.AckTimeout)
+	t.SetScheduler(m.Config.Scheduler)
+	t.SetRecovery(m.Config.Recovery)
+
+	for _, sm := range m.Spouts {
+		s := spout.NewSpoutInst(sm.Name, sm.Plugin, sm.Symbol, groupingHint(sm.Grouping), sm.FieldIndex)
+		s.InstNum = sm.Parallelism
+		if sm.Parallelism == 0 {
+			s.InstNum = 1
+		}
+		s.SetInputFile(sm.InputFile)
+		s.SetResources(sm.CPU, sm.Memory)
+		s.SetBatching(sm.Batch.MaxTuples, sm.Batch.MaxBytes, sm.Batch.Linger)
+		if len(sm.OutputFields) > 0 {
+			s.DeclareOutputFields(sm.OutputFields...)
+		}
+		for _, stream := range sm.Streams {
+			s.DeclareStream(stream.Name, stream.Fields...)
+		}
+		t.AddSpout(s)
+	}
+
+	for _, bm := range m.Bolts {
+		b := bolt.NewBoltInst(bm.Name, bm.Plugin, bm.Symbol, groupingHint(bm.Grouping), bm.FieldIndex)
+		b.InstNum = bm.Parallelism
+		if bm.Parallelism == 0 {
+			b.InstNum = 1
+		}
+		b.SetResources(bm.CPU, bm.Memory)
+		b.SetCodec(bm.Codec)
+		b.SetBatching(bm.Batch.MaxTuples, bm.Batch.MaxBytes, bm.Batch.Linger)
+		for _, input := range bm.Inputs {
+			stream := input.Stream
+			if stream == "" {
+				stream = utils.DEFAULT_STREAM
+			}
+			b.AddPrevTaskStream(input.Component, stream)
+			if len(input.Fields) > 0 {
+				b.GroupByFields(input.Component, input.Fields...)
+			}
+		}
+		if len(bm.OutputFields) > 0 {
+			b.DeclareOutputFields(bm.OutputFields...)
+		}
+		for _, stream := range bm.Streams {
+			b.DeclareStream(stream.Name, stream.Fields...)
+		}
+		t.AddBolt(b)
+	}
+
+	if errs := t.Validate(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return t, nil
+}
+
+// Submit the files of the manifest to the distributed file system,
+// named by their base names, e.g. the plugin files
+func (m *Manifest) SubmitFiles(t *Topology) error {
+	for _, file := range m.Files {
+		local := file
+		if !filepath.IsAbs(local) {
+			local = filepath.Join(m.dir, file)
+		}
+		if err := t.SubmitFile(local, filepath.Base(file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
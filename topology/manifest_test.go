@@ -0,0 +1,105 @@
+package topology
+
+import (
+	"crane/core/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const yamlManifest = `
+name: wordcount
+config:
+  ackTimeout: 30
+spouts:
+  - name: SentenceSpout
+    plugin: wordcount.so
+    symbol: SentenceSpout
+    parallelism: 2
+    outputFields: [sentence]
+bolts:
+  - name: CountBolt
+    plugin: wordcount.so
+    symbol: CountBolt
+    grouping: fields
+    inputs:
+      - component: SentenceSpout
+        fields: [sentence]
+`
+
+const jsonManifest = `{
+  "name": "wordcount",
+  "config": {"ackTimeout": 30},
+  "spouts": [{
+    "name": "SentenceSpout", "plugin": "wordcount.so", "symbol": "SentenceSpout",
+    "parallelism": 2, "outputFields": ["sentence"]
+  }],
+  "bolts": [{
+    "name": "CountBolt", "plugin": "wordcount.so", "symbol": "CountBolt",
+    "grouping": "fields",
+    "inputs": [{"component": "SentenceSpout", "fields": ["sentence"]}]
+  }]
+}`
+
+func writeManifest(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadManifestYAMLAndJSON(t *testing.T) {
+	dir := t.TempDir()
+	var loaded []*Manifest
+	for _, name := range []string{"topology.yaml", "topology.YML", "topology.json"} {
+		content := yamlManifest
+		if strings.HasSuffix(name, ".json") {
+			content = jsonManifest
+		}
+		m, err := LoadManifest(writeManifest(t, dir, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		loaded = append(loaded, m)
+	}
+	for _, m := range loaded[1:] {
+		if !reflect.DeepEqual(m, loaded[0]) {
+			t.Fatalf("manifest %+v, expected %+v", m, loaded[0])
+		}
+	}
+
+	topo, err := loaded[0].Topology()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topo.AckTimeout != 30 || topo.Spouts[0].InstNum != 2 || topo.Bolts[0].InstNum != 1 {
+		t.Fatalf("topology %+v does not match the manifest", topo)
+	}
+	if topo.Bolts[0].GroupingHint != utils.GROUPING_BY_FIELD {
+		t.Fatalf("grouping %q, expected %q", topo.Bolts[0].GroupingHint, utils.GROUPING_BY_FIELD)
+	}
+}
+
+func TestLoadManifestUnknownFields(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"top.yaml", yamlManifest + "parallelism: 2\n"},
+		{"spout.yaml", strings.Replace(yamlManifest, "parallelism: 2", "paralelism: 2", 1)},
+		{"top.json", strings.Replace(jsonManifest, `"name": "wordcount",`, `"name": "wordcount", "acking": true,`, 1)},
+		{"bolt.json", strings.Replace(jsonManifest, `"grouping": "fields"`, `"groupBy": "fields"`, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadManifest(writeManifest(t, dir, tt.name, tt.content))
+			if err == nil || !strings.Contains(err.Error(), "parse manifest") {
+				t.Fatalf("error %v, expected the unknown field to fail the parse", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package topology
+
+import (
+	"crane/bolt"
+	"crane/core/messages"
+	"crane/core/utils"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Output fields of the stream declared by the bolt or spout
+func (t *Topology) OutputFields(component string, stream string) ([]string, bool) {
+	for _, s := range t.Spouts {
+		if s.Name == component {
+			fields, ok := s.OutputFields[stream]
+			return fields, ok
+		}
+	}
+	for _, b := range t.Bolts {
+		if b.Name == component {
+			fields, ok := b.OutputFields[stream]
+			return fields, ok
+		}
+	}
+	return nil, false
+}
+
+// Indexes of the named fields in the tuples of the component's stream
+func (t *Topology) FieldIndexes(component string, stream string, fields []string) ([]int, error) {
+	declared, ok := t.OutputFields(component, stream)
+	if !ok {
+		return nil, fmt.Errorf("%s does not declare the fields of stream %s", component, stream)
+	}
+	indexes := make([]int, 0)
+	for _, field := range fields {
+		index := -1
+		for i, name := range declared {
+			if name == field {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("field %s does not exist in stream %s of %s %v", field, stream, component, declared)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// Total number of the task instances of the topology
+func (t *Topology) TaskNum() int {
+	num := 0
+	for _, s := range t.Spouts {
+		num += s.InstNum
+	}
+	for _, b := range t.Bolts {
+		num += b.InstNum
+	}
+	return num
+}
+
+// Validate the topology before scheduling it, and return all the errors
+// found, none if the topology can be built
+func (t *Topology) Validate() []error {
+	errs := make([]error, 0)
+	// The name prefixes the state and file names of the topology
+	for _, c := range t.Name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '-' && c != '.' {
+			errs = append(errs, fmt.Errorf("topology name %q has invalid character %q", t.Name, c))
+			break
+		}
+	}
+	if len(t.Spouts) == 0 {
+		errs = append(errs, fmt.Errorf("topology has no spout"))
+	}
+	switch t.StateBackend {
+	case utils.STATE_BACKEND_SDFS, utils.STATE_BACKEND_LOCAL, utils.STATE_BACKEND_MEMORY, "":
+	default:
+		errs = append(errs, fmt.Errorf("unknown state backend %q", t.StateBackend))
+	}
+	if t.AckTimeout < 0 {
+		errs = append(errs, fmt.Errorf("negative ack timeout %d", t.AckTimeout))
+	}
+	switch t.Scheduler {
+	case utils.SCHEDULER_ROUND_ROBIN, utils.SCHEDULER_RESOURCE_AWARE, "":
+	default:
+		errs = append(errs, fmt.Errorf("unknown scheduler %q", t.Scheduler))
+	}
+	switch t.Recovery {
+	case utils.RECOVERY_TASKS, utils.RECOVERY_TOPOLOGY, "":
+	default:
+		errs = append(errs, fmt.Errorf("unknown recovery %q", t.Recovery))
+	}
+
+	// Declared streams of every component, also to find duplicate names
+	streams := make(map[string][]string)
+	for _, s := range t.Spouts {
+		errs = append(errs, validateComponent("spout", s.Name, s.PluginFile, s.PluginSymbol, s.GroupingHint, s.FieldIndex, s.InstNum, streams)...)
+		errs = append(errs, validateResources("spout", s.Name, s.CPU, s.Memory)...)
+		errs = append(errs, validateBatch("spout", s.Name, s.Batch)...)
+		streams[s.Name] = s.Streams
+	}
+	for _, b := range t.Bolts {
+		errs = append(errs, validateComponent("bolt", b.Name, b.PluginFile, b.PluginSymbol, b.GroupingHint, b.FieldIndex, b.InstNum, streams)...)
+		errs = append(errs, validateResources("bolt", b.Name, b.CPU, b.Memory)...)
+		errs = append(errs, validateBatch("bolt", b.Name, b.Batch)...)
+		switch b.Codec {
+		case utils.CODEC_JSON, utils.CODEC_BINARY, "":
+		default:
+			errs = append(errs, fmt.Errorf("bolt %s has unknown codec %q", b.Name, b.Codec))
+		}
+		streams[b.Name] = b.Streams
+	}
+
+	checked := make(map[string]bool)
+	for _, b := range t.Bolts {
+		// The duplicate bolts are reported already
+		if checked[b.Name] {
+			continue
+		}
+		checked[b.Name] = true
+		if len(b.PrevTaskNames) == 0 {
+			errs = append(errs, fmt.Errorf("bolt %s subscribes no task", b.Name))
+		}
+		for i, prev := range b.PrevTaskNames {
+			declared, ok := streams[prev]
+			if !ok {
+				errs = append(errs, fmt.Errorf("bolt %s subscribes unknown task %s", b.Name, prev))
+				continue
+			}
+			if !contains(declared, b.PrevStream(i)) {
+				errs = append(errs, fmt.Errorf("bolt %s subscribes undeclared stream %s of %s", b.Name, b.PrevStream(i), prev))
+			}
+		}
+	}
+	for i := range t.Bolts {
+		errs = append(errs, t.validateGroupingFields(&t.Bolts[i])...)
+	}
+
+	if cycle := t.findCycle(); cycle != nil {
+		errs = append(errs, fmt.Errorf("topology has a cycle %v", cycle))
+	}
+	return errs
+}
+
+func validateComponent(kind, name, pluginFile, pluginSymbol, grouping string, fieldIndex, instNum int, streams map[string][]string) []error {
+	errs := make([]error, 0)
+	// "None" is the root of the topology graph built by the driver, and
+	// "_" separates the component from the task index in the worker names
+	if strings.TrimSpace(name) == "" || name == "None" {
+		errs = append(errs, fmt.Errorf("%s has invalid name %q", kind, name))
+	} else {
+		for _, c := range name {
+			if c == '_' || unicode.IsSpace(c) {
+				errs = append(errs, fmt.Errorf("%s name %q has invalid character %q", kind, name, c))
+				break
+			}
+		}
+	}
+	if _, ok := streams[name]; ok {
+		return append(errs, fmt.Errorf("duplicate task name %s", name))
+	}
+	if pluginFile == "" || pluginSymbol == "" {
+		errs = append(errs, fmt.Errorf("%s %s has no plugin file or symbol", kind, name))
+	}
+	if instNum < 1 {
+		errs = append(errs, fmt.Errorf("%s %s has %d instances", kind, name, instNum))
+	}
+	switch grouping {
+	case utils.GROUPING_BY_SHUFFLE, utils.GROUPING_BY_ALL:
+	case utils.GROUPING_BY_FIELD:
+		if fieldIndex < 0 {
+			errs = append(errs, fmt.Errorf("%s %s groups by negative field index %d", kind, name, fieldIndex))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s %s has unknown grouping %q", kind, name, grouping))
+	}
+	return errs
+}
+
+// The resources required by the tasks can not be negative, zero for the defaults
+func validateResources(kind, name string, cpu float64, memory int) []error {
+	errs := make([]error, 0)
+	if cpu < 0 {
+		errs = append(errs, fmt.Errorf("%s %s requires negative CPU %v", kind, name, cpu))
+	}
+	if memory < 0 {
+		errs = append(errs, fmt.Errorf("%s %s requires negative memory %d", kind, name, memory))
+	}
+	return errs
+}
+
+func validateBatch(kind, name string, batch utils.BatchConfig) []error {
+	errs := make([]error, 0)
+	if batch.MaxTuples < 0 || batch.MaxBytes < 0 || batch.Linger < 0 {
+		errs = append(errs, fmt.Errorf("%s %s has negative batching %+v", kind, name, batch))
+	}
+	if batch.MaxBytes > messages.MAX_FRAME_SIZE {
+		errs = append(errs, fmt.Errorf("%s %s batches %d bytes, over the max frame size %d", kind, name, batch.MaxBytes, messages.MAX_FRAME_SIZE))
+	}
+	return errs
+}
+
+// Every field a bolt groups by must be declared by the streams it
+// subscribes from the previous task
+func (t *Topology) validateGroupingFields(b *bolt.BoltInst) []error {
+	errs := make([]error, 0)
+	for task, fields := range b.GroupingFields {
+		if len(fields) == 0 {
+			errs = append(errs, fmt.Errorf("bolt %s groups %s by no fields", b.Name, task))
+			continue
+		}
+		subscribed := false
+		for i, prev := range b.PrevTaskNames {
+			if prev != task {
+				continue
+			}
+			subscribed = true
+			if _, err := t.FieldIndexes(task, b.PrevStream(i), fields); err != nil {
+				errs = append(errs, fmt.Errorf("bolt %s groups by fields: %w", b.Name, err))
+			}
+		}
+		if !subscribed {
+			errs = append(errs, fmt.Errorf("bolt %s groups %s by fields but does not subscribe it", b.Name, task))
+		}
+	}
+	return errs
+}
+
+// Find a cycle of the bolts by DFS, nil if the topology is a DAG
+func (t *Topology) findCycle() []string {
+	prevs := make(map[string][]string)
+	for _, b := range t.Bolts {
+		prevs[b.Name] = b.PrevTaskNames
+	}
+	// 1 for visiting, 2 for visited
+	status := make(map[string]int)
+	path := make([]string, 0)
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch status[name] {
+		case 1:
+			for i, n := range path {
+				if n == name {
+					return append(append([]string{}, path[i:]...), name)
+				}
+			}
+		case 2:
+			return nil
+		}
+		status[name] = 1
+		path = append(path, name)
+		for _, prev := range prevs[name] {
+			if cycle := visit(prev); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		status[name] = 2
+		return nil
+	}
+	for _, b := range t.Bolts {
+		if cycle := visit(b.Name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}